@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"test/quotes"
+)
+
+// keyStore looks up and manages API keys, see quotes.DB.LookupAPIKey.
+type keyStore interface {
+	LookupAPIKey(key string) (*quotes.APIKey, error)
+	keyManager
+}
+
+// keyManager manages API keys, see quotes.DB.CreateAPIKey.
+type keyManager interface {
+	CreateAPIKey(k *quotes.APIKey) (string, error)
+	APIKeys() ([]*quotes.APIKey, error)
+	RevokeAPIKey(id uint64) error
+}
+
+// apiKeyKey is the context key of the API key a request was made with.
+type apiKeyKey struct{}
+
+// requestAPIKey returns the API key r was made with, or nil if it has not
+// been checked.
+func requestAPIKey(r *http.Request) *quotes.APIKey {
+	key, _ := r.Context().Value(apiKeyKey{}).(*quotes.APIKey)
+	return key
+}
+
+// requireKey returns a middleware that only lets requests with a valid API
+// key through, admin keys only if admin is set and otherwise only keys of
+// the tenant of the request, and rate-limits them per key with limiter.
+// The key is sent as "Authorization: Bearer <key>" or in an X-API-Key
+// header, and recorded as the actor of the changes made with it. Without a
+// key store, all requests are let through.
+func (app *App) requireKey(limiter *rateLimiter, admin bool) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if app.keys == nil {
+				next(w, r)
+				return
+			}
+			key := requestAPIKey(r)
+			if key == nil {
+				key = app.authenticate(w, r)
+				if key == nil {
+					return
+				}
+			}
+			if admin && !key.Admin {
+				writeError(w, http.StatusForbidden, "forbidden", "key "+key.Name+" is not an admin key")
+				return
+			}
+			if !admin && !authorize(w, r, key) {
+				return
+			}
+
+			if !limiter.allow(w, key) {
+				writeError(w, http.StatusTooManyRequests, "too_many_requests", "rate limit of key "+key.Name+" exceeded")
+				return
+			}
+			ctx := context.WithValue(r.Context(), apiKeyKey{}, key)
+			next(w, r.WithContext(quotes.WithActor(ctx, key.ID)))
+		}
+	}
+}
+
+// authenticate returns the API key sent with r, or writes a 401 and
+// returns nil.
+func (app *App) authenticate(w http.ResponseWriter, r *http.Request) *quotes.APIKey {
+	token := requestKey(r)
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="quotes"`)
+		writeError(w, http.StatusUnauthorized, "unauthorized", "an API key is required")
+		return nil
+	}
+	key, err := app.keys.LookupAPIKey(token)
+	if errors.Cause(err) == quotes.ErrNotFound {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="quotes", error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "unauthorized", "invalid API key")
+		return nil
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return nil
+	}
+	return key
+}
+
+// authorize reports whether key is for the tenant of r, and writes a 403
+// if not.
+func authorize(w http.ResponseWriter, r *http.Request, key *quotes.APIKey) bool {
+	if key.Tenant == requestTenant(r) {
+		return true
+	}
+	if key.Tenant == "" {
+		writeError(w, http.StatusForbidden, "forbidden", "key "+key.Name+" is for the default tenant")
+	} else {
+		writeError(w, http.StatusForbidden, "forbidden", "key "+key.Name+" is for tenant "+key.Tenant)
+	}
+	return false
+}
+
+// requestKey returns the API key sent with r, or "".
+func requestKey(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// keyManager returns the key store, or writes a 501 and returns nil.
+func (app *App) keyManager(w http.ResponseWriter) keyManager {
+	if app.keys == nil {
+		writeError(w, http.StatusNotImplemented, "not_implemented", "API keys are not enabled")
+		return nil
+	}
+	return app.keys
+}
+
+// GET admin keys handler, lists the API keys without the keys themselves.
+func (app *App) listKeys(w http.ResponseWriter, r *http.Request) {
+	keys := app.keyManager(w)
+	if keys == nil {
+		return
+	}
+	list, err := keys.APIKeys()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// POST admin keys handler. Takes a name, rate limit, tenant and admin
+// flag, and answers 201 with the record and, only this once, the key.
+func (app *App) createKey(w http.ResponseWriter, r *http.Request) {
+	keys := app.keyManager(w)
+	if keys == nil {
+		return
+	}
+	k := &quotes.APIKey{}
+	if !decodeBody(w, r, k) {
+		return
+	}
+	key, err := keys.CreateAPIKey(k)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+strconv.FormatUint(k.ID, 10))
+	writeJSON(w, http.StatusCreated, struct {
+		Key string `json:"key"`
+		*quotes.APIKey
+	}{key, k})
+}
+
+// DELETE admin key handler, revokes an API key.
+func (app *App) revokeKey(w http.ResponseWriter, r *http.Request) {
+	keys := app.keyManager(w)
+	if keys == nil {
+		return
+	}
+	id, err := strconv.ParseUint(pathParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not_found", "key doesn`t exist")
+		return
+	}
+	err = keys.RevokeAPIKey(id)
+	if errors.Cause(err) == quotes.ErrNotFound {
+		writeError(w, http.StatusNotFound, "not_found", "key doesn`t exist")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rateLimiter keeps a token bucket per API key. A bucket holds up to
+// Burst tokens and refills at Rate tokens per minute; every request takes
+// one.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[uint64]*tokenBucket
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: map[uint64]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+// allow takes a token from the bucket of key and reports whether there
+// was one. It sets the X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset headers, the latter being the Unix time at which the
+// bucket is full again, and Retry-After if the request is refused. Keys
+// without a rate are not limited.
+func (l *rateLimiter) allow(w http.ResponseWriter, key *quotes.APIKey) bool {
+	if key.Rate <= 0 || key.Burst <= 0 {
+		return true
+	}
+	perSecond := key.Rate / 60
+
+	l.mu.Lock()
+	now := l.now()
+	b, ok := l.buckets[key.ID]
+	if !ok {
+		b = &tokenBucket{tokens: float64(key.Burst), last: now}
+		l.buckets[key.ID] = b
+	}
+	b.tokens = math.Min(float64(key.Burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	tokens := b.tokens
+	l.mu.Unlock()
+
+	full := now.Add(time.Duration((float64(key.Burst) - tokens) / perSecond * float64(time.Second)))
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(key.Burst))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(int(tokens)))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(float64(full.UnixNano())/1e9)), 10))
+	if !allowed {
+		wait := math.Ceil((1 - tokens) / perSecond)
+		h.Set("Retry-After", strconv.Itoa(int(wait)))
+	}
+	return allowed
+}
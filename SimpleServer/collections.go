@@ -0,0 +1,256 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"test/quotes"
+)
+
+// collector returns the store as a quotes.Collector, or writes a 501 and
+// returns nil.
+func (app *App) collector(w http.ResponseWriter, r *http.Request) quotes.Collector {
+	collector, ok := app.store(r).(quotes.Collector)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "not_implemented", "collections are not supported by this store")
+		return nil
+	}
+	return collector
+}
+
+// writeCollectionError writes the error envelope for err like
+// writeStoreError, with a missing record reported as missing.
+func writeCollectionError(w http.ResponseWriter, r *http.Request, err error, missing string) {
+	if errors.Cause(err) == quotes.ErrNotFound {
+		writeError(w, http.StatusNotFound, "not_found", missing)
+		return
+	}
+	writeStoreError(w, r, err)
+}
+
+// GET collections handler, lists all collections.
+func (app *App) listCollections(w http.ResponseWriter, r *http.Request) {
+	collector := app.collector(w, r)
+	if collector == nil {
+		return
+	}
+	collections, err := collector.Collections()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, collections)
+}
+
+// POST collections handler. Takes a name, a description and the IDs of
+// the first quotes, and answers 201 with the collection and its Location.
+func (app *App) createCollection(w http.ResponseWriter, r *http.Request) {
+	collector := app.collector(w, r)
+	if collector == nil {
+		return
+	}
+	c := &quotes.Collection{}
+	if !decodeBody(w, r, c) {
+		return
+	}
+	err := collector.CreateCollection(c)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+strconv.FormatUint(c.ID, 10))
+	writeJSON(w, http.StatusCreated, c)
+}
+
+// GET collection handler.
+func (app *App) getCollection(w http.ResponseWriter, r *http.Request) {
+	collector := app.collector(w, r)
+	if collector == nil {
+		return
+	}
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	c, err := collector.Collection(id)
+	if err != nil {
+		writeCollectionError(w, r, err, "collection doesn`t exist")
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// PUT collection handler, renames a collection or changes its
+// description. The quotes are changed through the quotes and order
+// routes.
+func (app *App) updateCollection(w http.ResponseWriter, r *http.Request) {
+	collector := app.collector(w, r)
+	if collector == nil {
+		return
+	}
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	c := &quotes.Collection{}
+	if !decodeBody(w, r, c) {
+		return
+	}
+	c.ID = id
+	err := collector.UpdateCollection(c)
+	if err != nil {
+		writeCollectionError(w, r, err, "collection doesn`t exist")
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// DELETE collection handler. The quotes of the collection are kept.
+func (app *App) deleteCollection(w http.ResponseWriter, r *http.Request) {
+	collector := app.collector(w, r)
+	if collector == nil {
+		return
+	}
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	err := collector.DeleteCollection(id)
+	if err != nil {
+		writeCollectionError(w, r, err, "collection doesn`t exist")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET collection quotes handler, lists the quotes of a collection in
+// their order.
+func (app *App) listCollectionQuotes(w http.ResponseWriter, r *http.Request) {
+	collector := app.collector(w, r)
+	if collector == nil {
+		return
+	}
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	c, err := collector.Collection(id)
+	if err != nil {
+		writeCollectionError(w, r, err, "collection doesn`t exist")
+		return
+	}
+	list := make([]*quotes.Quote, 0, len(c.QuoteIDs))
+	for _, quoteID := range c.QuoteIDs {
+		q, err := app.store(r).Get(quoteID)
+		if err == quotes.ErrNotFound {
+			// Deleted since the collection was read.
+			continue
+		}
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		list = append(list, q)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// POST collection quotes handler, adds the quote with the ID quote_id to a
+// collection, at position (counted from 0) or at the end. Answers with the
+// collection.
+func (app *App) addToCollection(w http.ResponseWriter, r *http.Request) {
+	collector := app.collector(w, r)
+	if collector == nil {
+		return
+	}
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var body struct {
+		QuoteID  uint64 `json:"quote_id"`
+		Position *int   `json:"position"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	pos := -1
+	if body.Position != nil {
+		pos = *body.Position
+	}
+	c, err := collector.AddToCollection(id, body.QuoteID, pos)
+	if err != nil {
+		writeCollectionError(w, r, err, "collection doesn`t exist")
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// DELETE collection quote handler, takes a quote out of a collection.
+// Answers with the collection.
+func (app *App) removeFromCollection(w http.ResponseWriter, r *http.Request) {
+	collector := app.collector(w, r)
+	if collector == nil {
+		return
+	}
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	quoteID, ok := pathID(w, r, "quote")
+	if !ok {
+		return
+	}
+	c, err := collector.RemoveFromCollection(id, quoteID)
+	if err != nil {
+		writeCollectionError(w, r, err, "collection doesn`t exist or doesn`t contain the quote")
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// PUT collection order handler, takes the quote_ids of a collection in
+// their new order. Answers with the collection.
+func (app *App) reorderCollection(w http.ResponseWriter, r *http.Request) {
+	collector := app.collector(w, r)
+	if collector == nil {
+		return
+	}
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var body struct {
+		QuoteIDs []uint64 `json:"quote_ids"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	c, err := collector.ReorderCollection(id, body.QuoteIDs)
+	if err != nil {
+		writeCollectionError(w, r, err, "collection doesn`t exist")
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// GET quote collections handler, lists the collections a quote is in.
+func (app *App) listQuoteCollections(w http.ResponseWriter, r *http.Request) {
+	collector := app.collector(w, r)
+	if collector == nil {
+		return
+	}
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	collections, err := collector.CollectionsOf(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, collections)
+}
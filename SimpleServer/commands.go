@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"test/quotes"
+)
+
+// runCommand runs the subcommand name with its arguments and exits.
+func runCommand(name string, args []string) {
+	var err error
+	switch name {
+	case "migrate":
+		err = migrateCommand(args)
+	case "backup":
+		err = backupCommand(args)
+	case "restore":
+		err = restoreCommand(args)
+	case "apikey":
+		err = apikeyCommand(args)
+	case "reencode":
+		err = reencodeCommand(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		fmt.Fprintln(os.Stderr, "usage: main [migrate [-dry-run] | backup [-server url] [-key key] file | restore file | reencode [-codec name] [-dry-run] | apikey create|list|revoke]")
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalln(name+":", err)
+	}
+}
+
+// migrateCommand migrates quotes.db to the current schema version,
+// or with -dry-run only prints what each pending migration would change.
+func migrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report what would change")
+	flags.Parse(args)
+
+	if *dryRun {
+		reports, err := quotes.PlanMigrations(dbPath)
+		if err != nil {
+			return err
+		}
+		if len(reports) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		for _, r := range reports {
+			if r.Tenant != "" {
+				fmt.Printf("tenant %s, ", r.Tenant)
+			}
+			fmt.Printf("%d: %s\n", r.Version, r.Description)
+			for _, c := range r.Changes {
+				fmt.Println("\t" + c)
+			}
+		}
+		return nil
+	}
+
+	db, err := quotes.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Println("schema version", version)
+	return nil
+}
+
+// reencodeCommand rewrites the quotes in quotes.db that are stored in
+// another format, gob records of older versions included, with the codec
+// given by -codec. The server must be stopped.
+func reencodeCommand(args []string) error {
+	flags := flag.NewFlagSet("reencode", flag.ExitOnError)
+	name := flags.String("codec", quotes.DefaultCodec.Name(), "codec to store the quotes with: binary or json")
+	dryRun := flags.Bool("dry-run", false, "only report what would change")
+	flags.Parse(args)
+
+	codec, err := quotes.CodecByName(*name)
+	if err != nil {
+		return err
+	}
+	report, err := quotes.Reencode(dbPath, codec, *dryRun)
+	if err == quotes.ErrLocked {
+		return fmt.Errorf("%s: stop the server first", err)
+	}
+	if err != nil {
+		return err
+	}
+	buckets := make([]string, 0, len(report.Found))
+	for bucket := range report.Found {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	for _, bucket := range buckets {
+		found := report.Found[bucket]
+		names := make([]string, 0, len(found))
+		for name := range found {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s: %d %s\n", bucket, found[name], name)
+		}
+	}
+	verb := "rewrote"
+	if *dryRun {
+		verb = "would rewrite"
+	}
+	fmt.Printf("%s %d records as %s\n", verb, report.Rewritten, report.Codec)
+	return nil
+}
+
+// backupCommand writes a snapshot of quotes.db to the file given as
+// argument, or to stdout for "-". If a server has the database open, the
+// snapshot is streamed from the server's backup endpoint instead, with the
+// admin key -key or QUOTES_KEY.
+func backupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	server := flags.String("server", "http://localhost:8000", "server to fetch the snapshot from if quotes.db is in use")
+	key := flags.String("key", "", "admin API key to fetch the snapshot with")
+	flags.Parse(args)
+	err := flagsFromEnv(flags)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: backup [-server url] [-key key] file")
+	}
+
+	out := os.Stdout
+	if name := flags.Arg(0); name != "-" {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	var n int64
+	db, err := quotes.OpenReadOnly(dbPath)
+	switch err {
+	case nil:
+		defer db.Close()
+		n, err = db.Backup(out)
+	case quotes.ErrLocked:
+		n, err = fetchBackup(*server+"/api/v1/admin/backup", *key, out)
+	}
+	if err != nil {
+		return err
+	}
+	if out != os.Stdout {
+		err = out.Sync()
+	}
+	fmt.Fprintf(os.Stderr, "backup: wrote %d bytes\n", n)
+	return err
+}
+
+// fetchBackup downloads a snapshot from url to w with the admin API key
+// key.
+func fetchBackup(url, key string, w io.Writer) (int64, error) {
+	r, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	r.Header.Set("Authorization", "Bearer "+key)
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// keyClient manages API keys through the admin endpoints at url of a
+// running server, with the admin API key key.
+type keyClient struct {
+	url, key string
+}
+
+func (c *keyClient) CreateAPIKey(k *quotes.APIKey) (string, error) {
+	created := struct {
+		Key string `json:"key"`
+		*quotes.APIKey
+	}{APIKey: k}
+	err := c.do("POST", c.url, k, &created)
+	return created.Key, err
+}
+
+func (c *keyClient) APIKeys() ([]*quotes.APIKey, error) {
+	keys := []*quotes.APIKey{}
+	err := c.do("GET", c.url, nil, &keys)
+	return keys, err
+}
+
+func (c *keyClient) RevokeAPIKey(id uint64) error {
+	return c.do("DELETE", c.url+"/"+strconv.FormatUint(id, 10), nil, nil)
+}
+
+// do sends body as JSON to url and decodes the answer into v. Error
+// answers are turned back into the errors of quotes.DB where there is one.
+func (c *keyClient) do(method, url string, body, v interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	r, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", "Bearer "+c.key)
+	r.Header.Set("Content-Type", jsonType)
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e errorBody
+		json.NewDecoder(resp.Body).Decode(&e)
+		switch e.Error.Code {
+		case "not_found":
+			return quotes.ErrNotFound
+		case "tenant_not_found":
+			return quotes.ErrTenantNotFound
+		case "validation_failed":
+			return &quotes.ValidationError{Fields: e.Error.Fields}
+		}
+		return fmt.Errorf("%s %s: %s %s", method, url, resp.Status, e.Error.Message)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// restoreCommand validates the snapshot given as argument and swaps it
+// in as quotes.db. The server must be stopped.
+func restoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: restore file")
+	}
+
+	err := quotes.Restore(flags.Arg(0), dbPath)
+	if err == quotes.ErrLocked {
+		return fmt.Errorf("%s: stop the server first", err)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("restored", dbPath, "from", flags.Arg(0))
+	return nil
+}
+
+// apikeyCommand manages the API keys in quotes.db: "create [-rate n]
+// [-burst n] [-tenant name] [-admin] name" prints a new key, "list" lists
+// the keys and "revoke id" deletes one. If a server has the database open,
+// the keys are managed through the server's admin endpoints instead, with
+// the admin key -key or QUOTES_KEY.
+func apikeyCommand(args []string) error {
+	const usage = "usage: apikey create [-rate n] [-burst n] [-tenant name] [-admin] name | list | revoke id, with [-server url] [-key key] while a server runs"
+	if len(args) == 0 {
+		return fmt.Errorf(usage)
+	}
+	flags := flag.NewFlagSet("apikey "+args[0], flag.ExitOnError)
+	rate := flags.Float64("rate", 60, "requests per minute")
+	burst := flags.Int("burst", 10, "requests at once")
+	tenant := flags.String("tenant", "", "tenant whose quotes the key is for, the default tenant if empty")
+	admin := flags.Bool("admin", false, "let the key back up the database and manage tenants and keys")
+	server := flags.String("server", "http://localhost:8000", "server to manage the keys through if quotes.db is in use")
+	key := flags.String("key", "", "admin API key to manage the keys through the server with")
+	flags.Parse(args[1:])
+	err := flagsFromEnv(flags)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "create" && flags.NArg() == 1:
+		if *rate <= 0 || *burst <= 0 {
+			return fmt.Errorf("rate and burst must be positive")
+		}
+	case args[0] == "list" && flags.NArg() == 0:
+	case args[0] == "revoke" && flags.NArg() == 1:
+	default:
+		return fmt.Errorf(usage)
+	}
+
+	var db keyManager
+	d, err := quotes.Open(dbPath)
+	switch err {
+	case nil:
+		defer d.Close()
+		db = d
+	case quotes.ErrLocked:
+		if *key == "" {
+			return fmt.Errorf("%s is in use by a server, give an admin key with -key or %sKEY to manage the keys through %s", dbPath, envPrefix, *server)
+		}
+		db = &keyClient{url: *server + "/api/v1/admin/keys", key: *key}
+	default:
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		k := &quotes.APIKey{Name: flags.Arg(0), Rate: *rate, Burst: *burst, Tenant: *tenant, Admin: *admin}
+		key, err := db.CreateAPIKey(k)
+		if err == quotes.ErrTenantNotFound {
+			return fmt.Errorf("no tenant %q", *tenant)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "created key %d %q, it is not shown again:\n", k.ID, k.Name)
+		fmt.Println(key)
+	case "list":
+		keys, err := db.APIKeys()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tKEY\tRATE\tBURST\tTENANT\tADMIN\tCREATED")
+		for _, k := range keys {
+			tenant := k.Tenant
+			if tenant == "" {
+				tenant = "-"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s...\t%g/min\t%d\t%s\t%t\t%s\n", k.ID, k.Name, k.Hint, k.Rate, k.Burst, tenant, k.Admin, k.CreatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+	case "revoke":
+		id, err := strconv.ParseUint(flags.Arg(0), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid key id %q", flags.Arg(0))
+		}
+		err = db.RevokeAPIKey(id)
+		if err == quotes.ErrNotFound {
+			return fmt.Errorf("no key %d", id)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("revoked key", id)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"test/quotes"
+)
+
+// errorBody is the JSON envelope of every API error. Code is a stable,
+// machine-readable name of the error; Message is meant for humans. Fields
+// lists what is wrong with each field of an invalid quote.
+type errorBody struct {
+	Error struct {
+		Code    string              `json:"code"`
+		Message string              `json:"message"`
+		Fields  []quotes.FieldError `json:"fields,omitempty"`
+	} `json:"error"`
+}
+
+// writeError writes an error envelope with the given status.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	var body errorBody
+	body.Error.Code = code
+	body.Error.Message = message
+	writeJSON(w, status, body)
+}
+
+// writeStoreError writes the error envelope for an error of the quote
+// store. Errors the client cannot do anything about are logged with the
+// request and reported as internal.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if v, ok := errors.Cause(err).(*quotes.ValidationError); ok {
+		var body errorBody
+		body.Error.Code = "validation_failed"
+		body.Error.Message = "the quote is invalid"
+		body.Error.Fields = v.Fields
+		writeJSON(w, http.StatusUnprocessableEntity, body)
+		return
+	}
+	switch errors.Cause(err) {
+	case quotes.ErrNotFound:
+		writeError(w, http.StatusNotFound, "not_found", "quote doesn`t exist")
+	case quotes.ErrExists:
+		writeError(w, http.StatusConflict, "conflict", err.Error())
+	case quotes.ErrRevisionMismatch:
+		writeError(w, http.StatusPreconditionFailed, "precondition_failed", err.Error())
+	case quotes.ErrInvalidCursor:
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+	case quotes.ErrTenantNotFound:
+		writeError(w, http.StatusNotFound, "tenant_not_found", "tenant doesn`t exist")
+	case quotes.ErrQuotaExceeded:
+		writeError(w, http.StatusForbidden, "quota_exceeded", err.Error())
+	default:
+		logs.error(r.Context(), "store error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "internal error")
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logs.error(context.Background(), "cannot encode response", err)
+		status, data = http.StatusInternalServerError, []byte(`{"error":{"code":"internal_error","message":"internal error"}}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	io.WriteString(w, string(data))
+}
+
+// decodeQuote decodes a quote from the request body. Malformed JSON is a
+// 400, well-formed JSON that is not a quote object a 422; in both cases
+// the error is written and decodeQuote returns nil.
+func decodeQuote(w http.ResponseWriter, r *http.Request) *quotes.Quote {
+	var q *quotes.Quote
+	if !decodeBody(w, r, &q) {
+		return nil
+	}
+	if q == nil {
+		writeError(w, http.StatusUnprocessableEntity, "unprocessable_entity", "body must be a quote object")
+		return nil
+	}
+	return q
+}
+
+// decodeBody decodes the JSON request body into v. It writes a 400 for
+// malformed JSON and a 422 for JSON that does not fit v, and returns false
+// in both cases.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if _, ok := err.(*json.UnmarshalTypeError); ok {
+		writeError(w, http.StatusUnprocessableEntity, "unprocessable_entity", err.Error())
+		return false
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
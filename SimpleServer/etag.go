@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etag returns the entity tag of a quote revision in the tenant of r. The
+// tags of a tenant start with its name, so that the same URL never matches
+// across tenants.
+func etag(r *http.Request, rev uint64) string {
+	return `"` + etagPrefix(r) + strconv.FormatUint(rev, 10) + `"`
+}
+
+// etagPrefix returns what the entity tags of the tenant of r start with.
+func etagPrefix(r *http.Request) string {
+	if tenant := requestTenant(r); tenant != "" {
+		return tenant + ":"
+	}
+	return ""
+}
+
+// parseETags splits an If-Match or If-None-Match header into its tags.
+func parseETags(header string) []string {
+	tags := []string{}
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// ifMatchRev resolves the If-Match header of a write to quote id into the
+// revision the store must find. It returns 0 if the request has no
+// If-Match header, and ok == false if the precondition already failed.
+func (app *App) ifMatchRev(r *http.Request, id uint64) (rev uint64, ok bool) {
+	tags := parseETags(r.Header.Get("If-Match"))
+	if len(tags) == 0 {
+		return 0, true
+	}
+
+	// A single tag needs no lookup, the store compares atomically.
+	if len(tags) == 1 && tags[0] != "*" {
+		return parseETag(r, tags[0])
+	}
+
+	// For "*" or a list of tags, pick the one that matches now; the
+	// store still fails if the quote changes in between.
+	q, err := app.store(r).Get(id)
+	if err != nil {
+		return 0, false
+	}
+	for _, t := range tags {
+		if t == "*" || t == etag(r, q.Rev) {
+			return q.Rev, true
+		}
+	}
+	return 0, false
+}
+
+// parseETag returns the revision of a strong entity tag of the tenant of
+// r.
+func parseETag(r *http.Request, tag string) (uint64, bool) {
+	prefix := etagPrefix(r)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' || !strings.HasPrefix(tag[1:], prefix) {
+		return 0, false
+	}
+	rev, err := strconv.ParseUint(tag[1+len(prefix):len(tag)-1], 10, 64)
+	if err != nil || rev == 0 {
+		return 0, false
+	}
+	return rev, true
+}
+
+// noneMatch reports whether the If-None-Match header of r matches
+// revision rev, using the weak comparison.
+func noneMatch(r *http.Request, rev uint64) bool {
+	for _, t := range parseETags(r.Header.Get("If-None-Match")) {
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag(r, rev) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheControl sets the Cache-Control header of a read to r: clients may
+// reuse the response for app.maxAge, or must revalidate it first if that
+// is 0. Reads of a tenant or with an API key are only for the client that
+// made them, never for shared caches.
+func (app *App) cacheControl(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", tenantHeader+", Authorization, X-API-Key")
+	private := requestTenant(r) != "" || requestKey(r) != ""
+	switch {
+	case app.maxAge > 0 && private:
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(app.maxAge.Seconds())))
+	case app.maxAge > 0:
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(app.maxAge.Seconds())))
+	case private:
+		w.Header().Set("Cache-Control", "private, no-cache")
+	default:
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+}
+
+// notModifiedSince sets the Last-Modified header to t and reports whether
+// the If-Modified-Since header of r shows that the client has the version
+// of t. A zero t is unknown and sets no header. If-None-Match takes
+// precedence over If-Modified-Since, so notModifiedSince reports false
+// for requests that have it.
+func notModifiedSince(w http.ResponseWriter, r *http.Request, t time.Time) bool {
+	if t.IsZero() {
+		return false
+	}
+	t = t.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", t.Format(http.TimeFormat))
+	if r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !t.After(since)
+}
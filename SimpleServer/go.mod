@@ -4,6 +4,8 @@ go 1.16
 
 require (
 	github.com/boltdb/bolt v1.3.1
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/pkg/errors v0.9.1
 	golang.org/x/sys v0.0.0-20210301091718-77cc2087c03b // indirect
+	golang.org/x/text v0.3.7
 )
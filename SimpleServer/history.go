@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"test/quotes"
+)
+
+// historian returns the store as a quotes.Historian, or writes a 501 and
+// returns nil.
+func (app *App) historian(w http.ResponseWriter, r *http.Request) quotes.Historian {
+	historian, ok := app.store(r).(quotes.Historian)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "not_implemented", "history is not supported by this store")
+		return nil
+	}
+	return historian
+}
+
+// GET quote history handler, lists all revisions of a quote, oldest first.
+func (app *App) listHistory(w http.ResponseWriter, r *http.Request) {
+	historian := app.historian(w, r)
+	if historian == nil {
+		return
+	}
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	history, err := historian.History(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+// GET quote revision handler.
+func (app *App) getRevision(w http.ResponseWriter, r *http.Request) {
+	historian := app.historian(w, r)
+	if historian == nil {
+		return
+	}
+	revision, ok := app.pathRevision(w, r, historian)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, revision)
+}
+
+// GET revision diff handler. Lists the fields changed in a revision,
+// against the previous revision or the one given by ?against=.
+func (app *App) diffRevision(w http.ResponseWriter, r *http.Request) {
+	historian := app.historian(w, r)
+	if historian == nil {
+		return
+	}
+	to, ok := app.pathRevision(w, r, historian)
+	if !ok {
+		return
+	}
+
+	from := &quotes.Revision{}
+	rev := to.Rev - 1
+	if against := r.URL.Query().Get("against"); against != "" {
+		n, err := strconv.ParseUint(against, 10, 64)
+		if err != nil || n == 0 {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid revision "+strconv.Quote(against))
+			return
+		}
+		rev = n
+	}
+	if rev > 0 {
+		var err error
+		from, err = historian.Revision(to.ID, rev)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, quotes.Diff(&from.Quote, &to.Quote))
+}
+
+// POST revert handler. Stores a revision again as the newest revision of
+// its quote; honors If-Match like PUT.
+func (app *App) revertRevision(w http.ResponseWriter, r *http.Request) {
+	historian := app.historian(w, r)
+	if historian == nil {
+		return
+	}
+	old, ok := app.pathRevision(w, r, historian)
+	if !ok {
+		return
+	}
+
+	q := old.Quote
+	q.Rev, ok = app.ifMatchRev(r, q.ID)
+	if !ok {
+		writeStoreError(w, r, quotes.ErrRevisionMismatch)
+		return
+	}
+	err := app.store(r).Update(&q)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(r, q.Rev))
+	writeJSON(w, http.StatusOK, q)
+}
+
+// pathRevision looks up revision {rev} of quote {id}. It writes the error
+// and returns false if that fails.
+func (app *App) pathRevision(w http.ResponseWriter, r *http.Request, historian quotes.Historian) (*quotes.Revision, bool) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return nil, false
+	}
+	rev, ok := pathID(w, r, "rev")
+	if !ok {
+		return nil, false
+	}
+	revision, err := historian.Revision(id, rev)
+	if err == quotes.ErrNotFound {
+		writeError(w, http.StatusNotFound, "not_found", "revision doesn`t exist")
+		return nil, false
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return nil, false
+	}
+	return revision, true
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"test/quotes"
+)
+
+// importBatchSize is the number of records written per Bolt transaction.
+const importBatchSize = 500
+
+// csvHeader is the column order of CSV exports. Imports accept the columns
+// in any order; author and text are required.
+var csvHeader = []string{"id", "author", "text", "source", "tags"}
+
+// csvTagSeparator separates the tags in the tags column.
+const csvTagSeparator = ";"
+
+var conflictModes = map[string]quotes.ConflictMode{
+	"":       quotes.ConflictError,
+	"error":  quotes.ConflictError,
+	"skip":   quotes.ConflictSkip,
+	"upsert": quotes.ConflictUpsert,
+}
+
+type importError struct {
+	Line   int                 `json:"line"`
+	Error  string              `json:"error"`
+	Fields []quotes.FieldError `json:"fields,omitempty"`
+}
+
+// importReport is the response of the import handler.
+type importReport struct {
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Skipped int           `json:"skipped"`
+	Failed  int           `json:"failed"`
+	Errors  []importError `json:"errors"`
+}
+
+func (r *importReport) fail(line int, err error) {
+	r.Failed++
+	e := importError{Line: line, Error: err.Error()}
+	if v, ok := errors.Cause(err).(*quotes.ValidationError); ok {
+		e.Fields = v.Fields
+	}
+	r.Errors = append(r.Errors, e)
+}
+
+// recordReader returns the next record of an import body and the line
+// (JSON Lines) or row (CSV) it came from. It returns io.EOF at the end.
+type recordReader func() (*quotes.Quote, int, error)
+
+// lineError is returned by a recordReader for a broken record. Reading can
+// go on with the next one, unlike with any other error.
+type lineError struct {
+	err error
+}
+
+func (e *lineError) Error() string { return e.err.Error() }
+
+// POST bulk import handler. The body is streamed as JSON Lines
+// (?format=jsonl, the default) or CSV with a header row (?format=csv).
+// ?mode=error|skip|upsert decides what happens to records whose id exists.
+// The response reports the outcome per line.
+func (app *App) handleImport(w http.ResponseWriter, r *http.Request) {
+	importer, ok := app.store(r).(quotes.Importer)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "not_implemented", "import is not supported by this store")
+		return
+	}
+	mode, ok := conflictModes[r.URL.Query().Get("mode")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid mode "+r.URL.Query().Get("mode"))
+		return
+	}
+
+	var next recordReader
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "jsonl":
+		next = jsonlReader(r.Body)
+	case "csv":
+		var err error
+		next, err = csvReader(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid format "+format)
+		return
+	}
+
+	report := &importReport{Errors: []importError{}}
+	batch := []*quotes.Quote{}
+	lines := []int{}
+	flush := func() {
+		results, err := importer.Import(batch, mode)
+		for i, line := range lines {
+			if err != nil {
+				report.fail(line, err)
+				continue
+			}
+			switch results[i].Action {
+			case quotes.ImportCreated:
+				report.Created++
+			case quotes.ImportUpdated:
+				report.Updated++
+			case quotes.ImportSkipped:
+				report.Skipped++
+			default:
+				report.fail(line, results[i].Err)
+			}
+		}
+		batch, lines = batch[:0], lines[:0]
+	}
+
+	for {
+		q, line, err := next()
+		if err == io.EOF {
+			break
+		}
+		if e, ok := err.(*lineError); ok {
+			report.fail(line, e)
+			continue
+		}
+		if err != nil {
+			// The body cannot be read any further; report what
+			// was imported so far.
+			report.fail(line, err)
+			break
+		}
+		batch = append(batch, q)
+		lines = append(lines, line)
+		if len(batch) == importBatchSize {
+			flush()
+		}
+	}
+	if len(batch) > 0 {
+		flush()
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// jsonlReader reads one JSON quote per line. Blank lines are ignored.
+func jsonlReader(body io.Reader) recordReader {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	return func() (*quotes.Quote, int, error) {
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			q := &quotes.Quote{}
+			err := json.Unmarshal([]byte(text), q)
+			if err != nil {
+				return nil, line, &lineError{err}
+			}
+			return q, line, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, line + 1, err
+		}
+		return nil, line, io.EOF
+	}
+}
+
+// csvReader reads quotes from CSV with a header row naming the columns.
+// Rows are numbered from 1 for the header.
+func csvReader(body io.Reader) (recordReader, error) {
+	reader := csv.NewReader(body)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CSV header: %s", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, h := range csvHeader {
+			known = known || h == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, required := range []string{"author", "text"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing CSV column %q", required)
+		}
+	}
+
+	line := 1
+	return func() (*quotes.Quote, int, error) {
+		record, err := reader.Read()
+		line++
+		if err == io.EOF {
+			return nil, line, io.EOF
+		}
+		if _, ok := err.(*csv.ParseError); ok {
+			return nil, line, &lineError{err}
+		}
+		if err != nil {
+			return nil, line, err
+		}
+
+		q := &quotes.Quote{
+			Author: record[columns["author"]],
+			Text:   record[columns["text"]],
+		}
+		if i, ok := columns["source"]; ok {
+			q.Source = record[i]
+		}
+		if i, ok := columns["tags"]; ok && record[i] != "" {
+			q.Tags = strings.Split(record[i], csvTagSeparator)
+		}
+		if i, ok := columns["id"]; ok && record[i] != "" {
+			q.ID, err = strconv.ParseUint(record[i], 10, 64)
+			if err != nil {
+				return nil, line, &lineError{fmt.Errorf("invalid id %q", record[i])}
+			}
+		}
+		return q, line, nil
+	}, nil
+}
+
+// GET bulk export handler. Streams all quotes as JSON Lines
+// (?format=jsonl, the default) or CSV (?format=csv). The Bolt store reads
+// them page by page, so a slow client holds no transaction open.
+func (app *App) handleExport(w http.ResponseWriter, r *http.Request) {
+	var write func(q *quotes.Quote) error
+	var done func() error
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		write = func(q *quotes.Quote) error { return enc.Encode(q) }
+		done = func() error { return nil }
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write(csvHeader)
+		write = func(q *quotes.Quote) error {
+			return cw.Write([]string{strconv.FormatUint(q.ID, 10), q.Author, q.Text, q.Source, strings.Join(q.Tags, csvTagSeparator)})
+		}
+		done = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid format "+format)
+		return
+	}
+
+	noWriteTimeout(r)
+	err := quotes.ForEach(app.store(r), write)
+	if err == nil {
+		err = done()
+	}
+	if err != nil {
+		// The status line is already sent; the client sees a
+		// truncated body.
+		logs.error(r.Context(), "export failed", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"test/quotes"
+	"test/trace"
+)
+
+// logger writes log lines as JSON objects, one per line. Lines logged with
+// a request context carry its request and trace ID.
+type logger struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// logs is the log of the server.
+var logs = &logger{out: os.Stderr}
+
+// log writes a line at level with message msg and the given key/value
+// pairs.
+func (l *logger) log(ctx context.Context, level, msg string, kv ...interface{}) {
+	var b bytes.Buffer
+	field := func(k string, v interface{}) {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			data, _ = json.Marshal(fmt.Sprint(v))
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(data)
+	}
+	field("time", time.Now().UTC().Format(time.RFC3339Nano))
+	field("level", level)
+	field("msg", msg)
+	if id := trace.RequestID(ctx); id != "" {
+		field("request_id", id)
+	}
+	if span := trace.FromContext(ctx); span != nil {
+		field("trace_id", span.TraceID)
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		field(fmt.Sprint(kv[i]), kv[i+1])
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.out, "{%s}\n", b.Bytes())
+}
+
+func (l *logger) info(ctx context.Context, msg string, kv ...interface{}) {
+	l.log(ctx, "info", msg, kv...)
+}
+
+func (l *logger) error(ctx context.Context, msg string, err error, kv ...interface{}) {
+	l.log(ctx, "error", msg, append([]interface{}{"error", err}, kv...)...)
+}
+
+// fatal logs msg and err and exits.
+func (l *logger) fatal(msg string, err error) {
+	l.error(context.Background(), msg, err)
+	os.Exit(1)
+}
+
+// maxRequestIDLen caps the length of request IDs taken from clients.
+const maxRequestIDLen = 64
+
+// observe returns a middleware that gives every request a request ID and
+// a trace span, and logs it once it is answered. The request ID comes from
+// the X-Request-ID header if it is sane and is sent back in the response;
+// a W3C traceparent header continues the trace of the caller.
+func (app *App) observe(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		ctx := trace.WithRequestID(r.Context(), id)
+		ctx, span := app.tracer.Start(ctx, r.Method+" "+r.URL.Path, r.Header.Get("traceparent"))
+		span.SetAttr("http.method", r.Method)
+		span.SetAttr("http.target", r.URL.RequestURI())
+		w.Header().Set("X-Request-ID", id)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		span.SetAttr("http.status_code", rec.status)
+		if rec.status >= 500 {
+			span.SetError(fmt.Errorf("%d %s", rec.status, http.StatusText(rec.status)))
+		}
+		span.End()
+		logs.info(ctx, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", span.Name(),
+			"status", rec.status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"bytes", rec.bytes,
+			"remote", r.RemoteAddr,
+		)
+	})
+}
+
+// store returns the quote store with its calls tied to the context of r,
+// so that they show up in the trace of the request.
+func (app *App) store(r *http.Request) quotes.QuoteStore {
+	s := app.db
+	if name, ok := r.Context().Value(tenantKey{}).(string); ok {
+		s = s.(quotes.Tenanter).ForTenant(name)
+	}
+	return quotes.WithContext(s, r.Context())
+}
+
+// validRequestID reports whether a request ID sent by a client can be
+// used as is: not too long and only letters, digits and -_.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"test/quotes"
+	"test/trace"
 )
 
 type Quote struct {
@@ -17,115 +20,414 @@ type Quote struct {
 	Source string `json:"source,omitempty"`
 }
 
+// dbPath is the Bolt file the server and the subcommands work on. It is
+// set by the -db flag.
+var dbPath = "quotes.db"
+
+// sqlitePath is the file of the sqlite store, set by the -sqlite-db flag.
+var sqlitePath = "quotes.sqlite"
+
 type App struct {
-	db quotes.DB
+	db quotes.QuoteStore
+	// metrics records the requests to the routes if it is set.
+	metrics *metrics
+	// tracer starts the span of every request; a nil tracer does not
+	// export them.
+	tracer *trace.Tracer
+	// keys checks the API keys of mutating requests. If it is nil,
+	// anyone may write.
+	keys keyStore
+	// maxAge is how long clients may reuse quotes and lists without
+	// asking again, see cacheControl.
+	maxAge time.Duration
 }
 
-// dummy handler - all routes witch not handled
-func hello(w http.ResponseWriter, r *http.Request) {
-	io.WriteString(w, "Hello, world!\n")
-	io.WriteString(w, "URL:"+r.URL.Path)
+// POST quote handler. Answers 201 with the created quote, its ETag and
+// Location.
+func (app *App) createQuote(w http.ResponseWriter, r *http.Request) {
+	q := decodeQuote(w, r)
+	if q == nil {
+		return
+	}
+	err := app.store(r).Create(q)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(r, q.Rev))
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+strconv.FormatUint(q.ID, 10))
+	writeJSON(w, http.StatusCreated, q)
 }
 
-// quote CRUD handler
-func (app *App) handlerQoute(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "POST":
-		var body *quotes.Quote
-		err := json.NewDecoder(r.Body).Decode(&body)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		err = app.db.Create(body)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		io.WriteString(w, "Created")
-	case "GET":
-		q, err := app.db.Get(app.getQouteKey(r.URL.Path))
+// GET quote handler. Answers with an ETag of the quote revision, its
+// Last-Modified date if the store knows it, and honors If-None-Match and
+// If-Modified-Since. The quote is served as JSON, plain text, an HTML card
+// or Markdown, as the Accept header asks; the ETag of all but JSON is
+// weak.
+func (app *App) getQuote(w http.ResponseWriter, r *http.Request) {
+	mediaType := negotiate(w, r, quoteTypes)
+	if mediaType == "" {
+		return
+	}
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	store := app.store(r)
+	// The date is read first, so that it is never newer than the quote.
+	var modified time.Time
+	if m, ok := store.(quotes.Modifier); ok {
+		var err error
+		modified, err = m.Modified(id)
 		if err != nil {
-			http.Error(w, "Quote doesn`t exist", http.StatusBadRequest)
+			writeStoreError(w, r, err)
 			return
 		}
+	}
+	q, err := store.Get(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
 
-		data, err := json.Marshal(q)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		io.WriteString(w, string(data))
-	case "PUT":
-		var body *quotes.Quote
-		err := json.NewDecoder(r.Body).Decode(&body)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		err = app.db.Update(body)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		io.WriteString(w, "Updated")
-	case "DELETE":
-		app.db.Delete(app.getQouteKey(r.URL.Path))
-		io.WriteString(w, "Deleted")
-	default:
-		w.WriteHeader(http.StatusBadRequest)
+	tag := etag(r, q.Rev)
+	if mediaType != jsonType {
+		tag = "W/" + tag
+	}
+	w.Header().Set("ETag", tag)
+	app.cacheControl(w, r)
+	if notModifiedSince(w, r, modified) || noneMatch(r, q.Rev) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeQuote(w, r, http.StatusOK, mediaType, q)
+}
+
+// PUT quote handler. Honors If-Match and answers with the updated quote.
+func (app *App) updateQuote(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	q := decodeQuote(w, r)
+	if q == nil {
+		return
+	}
+	q.ID = id
+	q.Rev, ok = app.ifMatchRev(r, id)
+	if !ok {
+		writeStoreError(w, r, quotes.ErrRevisionMismatch)
+		return
+	}
+	err := app.store(r).Update(q)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
 	}
+
+	w.Header().Set("ETag", etag(r, q.Rev))
+	writeJSON(w, http.StatusOK, q)
 }
 
-// GET quote list handler
+// DELETE quote handler. Honors If-Match and moves the quote into the
+// trash, see handleUndelete.
+func (app *App) deleteQuote(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	rev, ok := app.ifMatchRev(r, id)
+	if !ok {
+		writeStoreError(w, r, quotes.ErrRevisionMismatch)
+		return
+	}
+	var err error
+	if rev != 0 {
+		err = app.store(r).DeleteIfMatch(id, rev)
+	} else {
+		err = app.store(r).Delete(id)
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET quote list handler. Supports ?limit=, ?cursor= (the next_cursor of
+// the previous page), ?author=, ?author_prefix=, ?source= and ?tag= with
+// ?tag_match=all|any. Answers with the Last-Modified date of the store
+// and honors If-Modified-Since. Besides JSON, the page is available in
+// the formats of a quote and as an RSS or Atom feed.
 func (app *App) handleQoutesList(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "GET":
-		arr, error := app.db.List()
-		if error != nil {
-			fmt.Println(error)
+	mediaType := negotiate(w, r, listTypes)
+	if mediaType == "" {
+		return
+	}
+	query := r.URL.Query()
+	opts := quotes.ListOptions{
+		Cursor:       query.Get("cursor"),
+		Author:       query.Get("author"),
+		AuthorPrefix: query.Get("author_prefix"),
+		Source:       query.Get("source"),
+	}
+	var ok bool
+	opts.Tags, opts.AnyTag, ok = tagFilter(w, query)
+	if !ok {
+		return
+	}
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid limit "+l)
 			return
 		}
+		opts.Limit = n
+	}
 
-		value, err := json.Marshal(arr)
+	store := app.store(r)
+	var modified time.Time
+	if m, ok := store.(quotes.Modifier); ok {
+		var err error
+		modified, err = m.LastModified()
 		if err != nil {
-			fmt.Println(err)
+			writeStoreError(w, r, err)
 			return
 		}
-
-		io.WriteString(w, string(value))
-	default:
-		w.WriteHeader(http.StatusBadRequest)
 	}
+	page, err := quotes.ListPage(store, opts)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	app.cacheControl(w, r)
+	if notModifiedSince(w, r, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeQuotes(w, r, mediaType, page, modified)
 }
 
-func main() {
-	db, err := quotes.Open("quotes.db")
+// GET full-text search handler, ?q= is the query and ?limit= caps the results
+func (app *App) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if strings.TrimSpace(query) == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "missing query parameter q")
+		return
+	}
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid limit "+l)
+			return
+		}
+		limit = n
+	}
+
+	searcher, ok := app.store(r).(quotes.Searcher)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "not_implemented", "search is not supported by this store")
+		return
+	}
+	results, err := searcher.Search(query, limit)
 	if err != nil {
-		log.Fatalln("Cannot open quotes.db:", err)
+		writeStoreError(w, r, err)
+		return
 	}
+	writeJSON(w, http.StatusOK, results)
+}
 
-	defer db.Close()
+// GET admin backup handler, streams a consistent snapshot of the database
+func (app *App) handleBackup(w http.ResponseWriter, r *http.Request) {
+	backuper, ok := app.store(r).(quotes.Backuper)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "not_implemented", "backup is not supported by this store")
+		return
+	}
 
-	app := &App{db: *db}
+	noWriteTimeout(r)
+	name := "quotes-" + time.Now().UTC().Format("20060102T150405Z") + ".db"
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	_, err := backuper.Backup(w)
+	if err != nil {
+		// The status line is already sent; the client sees a
+		// truncated body.
+		logs.error(r.Context(), "backup failed", err)
+	}
+}
 
-	prefix := "/api/v1/"
+// routes returns the route table of the API under prefix. Reading is
+// public, writing needs an API key, and the admin routes an admin key. The
+// routes but the admin ones are served per tenant, see tenantRoutes.
+func (app *App) routes(prefix string) *router {
+	limiter := newRateLimiter()
+	write, admin := app.requireKey(limiter, false), app.requireKey(limiter, true)
+	rt := newRouter(prefix)
+	rt.metrics = app.metrics
+	rt.handle("POST", "quote", jsonType, write(app.createQuote))
+	rt.handle("GET", "quote/{id}", "", app.getQuote)
+	rt.handle("PUT", "quote/{id}", jsonType, write(app.updateQuote))
+	rt.handle("DELETE", "quote/{id}", jsonType, write(app.deleteQuote))
+	rt.handle("POST", "quote/{id}/restore", jsonType, write(app.handleUndelete))
+	rt.handle("GET", "quote/{id}/history", jsonType, app.listHistory)
+	rt.handle("GET", "quote/{id}/history/{rev}", jsonType, app.getRevision)
+	rt.handle("GET", "quote/{id}/history/{rev}/diff", jsonType, app.diffRevision)
+	rt.handle("POST", "quote/{id}/history/{rev}/revert", jsonType, write(app.revertRevision))
+	rt.handle("GET", "quotes", "", app.handleQoutesList)
+	rt.handle("GET", "quotes/random", jsonType, app.handleRandom)
+	rt.handle("GET", "quotes/daily", jsonType, app.handleDaily)
+	rt.handle("POST", "quotes/import", jsonType, write(app.handleImport))
+	rt.handle("GET", "quotes/export", "", app.handleExport)
+	rt.handle("GET", "quotes/watch", "text/event-stream", app.handleWatch)
+	rt.handle("GET", "quote/{id}/collections", jsonType, app.listQuoteCollections)
+	rt.handle("GET", "collections", jsonType, app.listCollections)
+	rt.handle("POST", "collections", jsonType, write(app.createCollection))
+	rt.handle("GET", "collections/{id}", jsonType, app.getCollection)
+	rt.handle("PUT", "collections/{id}", jsonType, write(app.updateCollection))
+	rt.handle("DELETE", "collections/{id}", jsonType, write(app.deleteCollection))
+	rt.handle("GET", "collections/{id}/quotes", jsonType, app.listCollectionQuotes)
+	rt.handle("POST", "collections/{id}/quotes", jsonType, write(app.addToCollection))
+	rt.handle("DELETE", "collections/{id}/quotes/{quote}", jsonType, write(app.removeFromCollection))
+	rt.handle("PUT", "collections/{id}/order", jsonType, write(app.reorderCollection))
+	rt.handle("GET", "tags", jsonType, app.handleTags)
+	rt.handle("GET", "trash", jsonType, app.handleTrash)
+	rt.handle("GET", "search", jsonType, app.handleSearch)
+	rt.handle("GET", "admin/backup", "application/octet-stream", admin(app.handleBackup))
+	rt.handle("GET", "admin/tenants", jsonType, admin(app.listTenants))
+	rt.handle("POST", "admin/tenants", jsonType, admin(app.createTenant))
+	rt.handle("GET", "admin/tenants/{tenant}", jsonType, admin(app.getTenant))
+	rt.handle("PUT", "admin/tenants/{tenant}/quotas", jsonType, admin(app.setQuotas))
+	rt.handle("DELETE", "admin/tenants/{tenant}", jsonType, admin(app.dropTenant))
+	rt.handle("GET", "admin/keys", jsonType, admin(app.listKeys))
+	rt.handle("POST", "admin/keys", jsonType, admin(app.createKey))
+	rt.handle("DELETE", "admin/keys/{id}", jsonType, admin(app.revokeKey))
+	app.tenantRoutes(rt)
+	return rt
+}
 
-	http.HandleFunc(prefix+"quote/", app.handlerQoute)
-	http.HandleFunc(prefix+"quotes/", app.handleQoutesList)
-	http.HandleFunc("/", hello)
+// handler returns the handler of the server: the API under prefix and the
+// monitoring endpoints at the root. Any other path is a JSON 404. Every
+// request is traced and logged.
+func (app *App) handler(prefix string) http.Handler {
+	root := newRouter("/")
+	root.metrics = app.metrics
+	root.handle("GET", "healthz", "application/json", app.handleHealthz)
+	root.handle("GET", "readyz", "application/json", app.handleReadyz)
+	root.handle("GET", "metrics", "", app.handleMetrics)
 
-	error := http.ListenAndServe("localhost:8000", nil)
+	mux := http.NewServeMux()
+	mux.Handle(prefix, app.routes(prefix))
+	mux.Handle("/", root)
+	return app.observe(mux)
+}
 
-	if error != nil {
-		log.Fatal("ListenAndServe:", error)
+// openStore opens the storage backend of the given kind.
+func openStore(kind string) (quotes.QuoteStore, error) {
+	switch kind {
+	case "bolt":
+		return quotes.Open(dbPath)
+	case "memory":
+		return quotes.NewMemoryStore(), nil
+	case "sqlite":
+		return quotes.OpenSQLite(sqlitePath)
 	}
+	return nil, fmt.Errorf("unknown store %q", kind)
 }
 
-// get quote author key from path
-func (app *App) getQouteKey(path string) string {
-	arr := strings.Split(path, "/")
-	last := arr[len(arr)-1]
+func main() {
+	storeKind := flag.String("store", "bolt", "storage backend: bolt, memory or sqlite")
+	flag.StringVar(&dbPath, "db", dbPath, "Bolt database file, also holds the API keys")
+	flag.StringVar(&sqlitePath, "sqlite-db", sqlitePath, "sqlite database file")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "how long deleted quotes can be restored, 0 keeps them forever")
+	addr := flag.String("addr", "localhost:8000", "address to listen on")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file, serves HTTPS together with -tls-key")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	readTimeout := flag.Duration("read-timeout", 10*time.Second, "maximum time to read a request")
+	writeTimeout := flag.Duration("write-timeout", 30*time.Second, "maximum time to write a response, streams excepted")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "how long to keep idle connections open")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for requests in flight on SIGINT or SIGTERM")
+	cacheSize := flag.Int("cache-size", quotes.DefaultCacheSize, "number of Bolt reads to cache, 0 turns the cache off")
+	cacheTTL := flag.Duration("cache-ttl", quotes.DefaultCacheTTL, "how long to cache a Bolt read at most")
+	maxAge := flag.Duration("cache-max-age", 0, "how long clients may reuse quotes without revalidating them")
+	batchSize := flag.Int("batch-size", 0, fmt.Sprintf("number of concurrent Bolt creates and imports to commit together, for example %d; 0 commits each on its own", quotes.DefaultBatchSize))
+	batchDelay := flag.Duration("batch-delay", quotes.DefaultBatchDelay, "how long a Bolt create or import waits for others to commit with at most, if -batch-size is set")
+	codec := flag.String("codec", quotes.DefaultCodec.Name(), "encoding of the quotes the Bolt store writes: binary or json")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP endpoint to export trace spans to, for example http://localhost:4318/v1/traces")
+	flag.Parse()
+	err := flagsFromEnv(flag.CommandLine)
+	if err != nil {
+		logs.fatal("invalid configuration", err)
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		logs.fatal("invalid configuration", errors.New("-tls-cert and -tls-key must be given together"))
+	}
+	quotes.DefaultCodec, err = quotes.CodecByName(*codec)
+	if err != nil {
+		logs.fatal("invalid configuration", err)
+	}
+
+	if flag.NArg() > 0 {
+		runCommand(flag.Arg(0), flag.Args()[1:])
+		return
+	}
 
-	return last
+	db, err := openStore(*storeKind)
+	if err != nil {
+		logs.fatal("cannot open store", err)
+	}
+	app := &App{db: db, metrics: newMetrics(), maxAge: *maxAge}
+	if *otlpEndpoint != "" {
+		exporter := trace.NewOTLPExporter(*otlpEndpoint, "quotes", 5*time.Second)
+		exporter.OnError = func(err error) { logs.error(context.Background(), "cannot export spans", err) }
+		defer exporter.Close()
+		app.tracer = trace.NewTracer(exporter)
+	}
+
+	// API keys live in the Bolt database, also if the quotes do not.
+	var keys *quotes.DB
+	if d, ok := db.(*quotes.DB); ok {
+		d.SetCache(*cacheSize, *cacheTTL)
+		d.SetBatch(*batchSize, *batchDelay)
+		d.SetErrorLog(logs.error)
+		app.keys = d
+	} else {
+		keys, err = quotes.Open(dbPath)
+		if err != nil {
+			db.Close()
+			logs.fatal("cannot open API keys", err)
+		}
+		app.keys = keys
+	}
+
+	ctx, stopPurge := context.WithCancel(context.Background())
+	if _, ok := db.(quotes.Trasher); ok && *trashRetention > 0 {
+		go purgeTrash(ctx, db, *trashRetention)
+	}
+
+	server := &http.Server{
+		Addr:         *addr,
+		Handler:      app.handler("/api/v1/"),
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+	}
+	logs.info(context.Background(), "listening", "addr", *addr, "tls", *tlsCert != "")
+	err = serve(server, *tlsCert, *tlsKey, *shutdownTimeout)
+
+	// The store is closed only after the requests have drained, so that
+	// the Bolt file is released cleanly.
+	stopPurge()
+	if keys != nil {
+		keys.Close()
+	}
+	if cerr := db.Close(); cerr != nil {
+		logs.error(context.Background(), "cannot close store", cerr)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		logs.fatal("server failed", err)
+	}
+	logs.info(context.Background(), "stopped")
 }
@@ -1,11 +1,24 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"test/quotes"
+	"test/trace"
 )
 
 func TestApp_createQuote(t *testing.T) {
@@ -15,13 +28,16 @@ func TestApp_createQuote(t *testing.T) {
 		wantErr bool
 	}{
 		{"Alfred", quotes.Quote{Author: "Alfred E. Neuman", Text: "What, me worry?", Source: "MAD Magazine"}, false},
-		{"Alfred again", quotes.Quote{Author: "Alfred E. Neuman again", Text: "What, me worry?", Source: "MAD Magazine"}, false},
+		{"Alfred again", quotes.Quote{Author: "Alfred E. Neuman", Text: "What, me worry?", Source: "MAD Magazine"}, true},
+		{"Alfred shouting", quotes.Quote{Author: "Alfred E. Neuman", Text: "  WHAT  me worry!  "}, true},
+		{"Nobody", quotes.Quote{Author: " ", Text: "Who said that?"}, true},
+		{"Bender", quotes.Quote{Author: "Bender", Text: "Bite my shiny metal ass."}, false},
 	}
 	db, err := quotes.Open("testdb")
 	if err != nil {
 		t.Fatalf("Cannot create test DB")
 	}
-	app := &App{db: *db}
+	app := &App{db: db}
 	defer func() {
 		app.db.Close()
 		os.Remove("testdb")
@@ -49,22 +65,25 @@ func TestApp_createQuote(t *testing.T) {
 func TestApp_getQuote(t *testing.T) {
 	tests := []struct {
 		name    string
-		author  string
+		id      uint64
 		want    *quotes.Quote
 		wantErr bool
 	}{
-		{"Alfred", "Alfred E. Neuman", &quotes.Quote{
+		{"Alfred", 1, &quotes.Quote{
+			ID:     1,
+			Rev:    1,
 			Author: "Alfred E. Neuman",
 			Text:   "What, me worry?",
 			Source: "MAD Magazine",
 		},
 			false},
+		{"Missing", 2, nil, true},
 	}
 	db, err := quotes.Open("testdb")
 	if err != nil {
 		t.Fatalf("Cannot create test DB")
 	}
-	app := &App{db: *db}
+	app := &App{db: db}
 	defer func() {
 		app.db.Close()
 		os.Remove("testdb")
@@ -78,7 +97,7 @@ func TestApp_getQuote(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := app.db.Get(tt.author)
+			got, err := app.db.Get(tt.id)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("app.db.Get() error = %s, wantErr %t", err, tt.wantErr)
 				return
@@ -89,3 +108,1219 @@ func TestApp_getQuote(t *testing.T) {
 		})
 	}
 }
+
+func TestApp_importExport(t *testing.T) {
+	db, err := quotes.Open("testdb")
+	if err != nil {
+		t.Fatalf("Cannot create test DB")
+	}
+	app := &App{db: db}
+	defer func() {
+		app.db.Close()
+		os.Remove("testdb")
+	}()
+
+	tests := []struct {
+		name   string
+		url    string
+		body   string
+		status int
+		want   string
+	}{
+		{
+			"JSONLines", "/api/v1/quotes/import",
+			`{"author":"Gopher","text":"Errors are values."}` + "\n\n" +
+				`{"author":` + "\n" +
+				`{"id":7,"author":"Rob Pike","text":"Don't panic."}` + "\n",
+			http.StatusOK,
+			`{"created":2,"updated":0,"skipped":0,"failed":1,"errors":[{"line":3,"error":"unexpected end of JSON input"}]}`,
+		},
+		{
+			"CSVSkip", "/api/v1/quotes/import?format=csv&mode=skip",
+			"text,author,id,tags\n\"Clear is better than clever.\",Rob Pike,,Go;Style\nChanged,Gopher,1,\n",
+			http.StatusOK,
+			`{"created":1,"updated":0,"skipped":1,"failed":0,"errors":[]}`,
+		},
+		{
+			"CSVConflict", "/api/v1/quotes/import?format=csv",
+			"id,author,text\n7,Rob Pike,Changed\n",
+			http.StatusOK,
+			`{"created":0,"updated":0,"skipped":0,"failed":1,"errors":[{"line":2,"error":"record already exists"}]}`,
+		},
+		{
+			"CSVUnknownColumn", "/api/v1/quotes/import?format=csv",
+			"author,text,year\n",
+			http.StatusBadRequest,
+			`{"error":{"code":"bad_request","message":"unknown CSV column \"year\""}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.handleImport(w, httptest.NewRequest("POST", tt.url, strings.NewReader(tt.body)))
+			if w.Code != tt.status || w.Body.String() != tt.want {
+				t.Errorf("handleImport() = %d %s, want %d %s", w.Code, w.Body, tt.status, tt.want)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	app.handleExport(w, httptest.NewRequest("GET", "/api/v1/quotes/export?format=csv", nil))
+	want := "id,author,text,source,tags\n" +
+		"1,Gopher,Errors are values.,,\n" +
+		"7,Rob Pike,Don't panic.,,\n" +
+		"8,Rob Pike,Clear is better than clever.,,go;style\n"
+	if w.Body.String() != want {
+		t.Errorf("handleExport() = %q, want %q", w.Body, want)
+	}
+}
+
+func TestApp_etags(t *testing.T) {
+	app := &App{db: quotes.NewMemoryStore()}
+	defer app.db.Close()
+	routes := app.routes("/api/v1/")
+
+	q := &quotes.Quote{Author: "Gopher", Text: "Errors are values."}
+	err := app.db.Create(q)
+	if err != nil {
+		t.Fatalf("Cannot fill test store: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		header  string
+		value   string
+		body    string
+		status  int
+		wantTag string
+	}{
+		{"Get", "GET", "", "", "", http.StatusOK, `"1"`},
+		{"NotModified", "GET", "If-None-Match", `W/"1"`, "", http.StatusNotModified, `"1"`},
+		{"Modified", "GET", "If-None-Match", `"7"`, "", http.StatusOK, `"1"`},
+		{"Put", "PUT", "If-Match", `"1"`, `{"author":"Gopher","text":"Don't panic."}`, http.StatusOK, `"2"`},
+		{"PutStale", "PUT", "If-Match", `"1"`, `{"author":"Gopher","text":"Clobbered"}`, http.StatusPreconditionFailed, ""},
+		{"PutWeak", "PUT", "If-Match", `W/"2"`, `{"author":"Gopher","text":"Clobbered"}`, http.StatusPreconditionFailed, ""},
+		{"PutList", "PUT", "If-Match", `"1", "2"`, `{"author":"Gopher","text":"Errors are values."}`, http.StatusOK, `"3"`},
+		{"PutBlind", "PUT", "", "", `{"author":"Gopher","text":"Don't panic."}`, http.StatusOK, `"4"`},
+		{"DeleteStale", "DELETE", "If-Match", `"3"`, "", http.StatusPreconditionFailed, ""},
+		{"Delete", "DELETE", "If-Match", "*", "", http.StatusNoContent, ""},
+		{"DeleteGone", "DELETE", "If-Match", "*", "", http.StatusPreconditionFailed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v1/quote/1", strings.NewReader(tt.body))
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, r)
+			if w.Code != tt.status || w.Header().Get("ETag") != tt.wantTag {
+				t.Errorf("%s = %d ETag %s, want %d ETag %s", tt.method, w.Code, w.Header().Get("ETag"), tt.status, tt.wantTag)
+			}
+		})
+	}
+}
+
+func TestApp_cacheHeaders(t *testing.T) {
+	db, err := quotes.Open("testdb")
+	if err != nil {
+		t.Fatalf("Cannot create test DB")
+	}
+	app := &App{db: db, maxAge: time.Minute}
+	defer func() {
+		app.db.Close()
+		os.Remove("testdb")
+	}()
+	routes := app.routes("/api/v1/")
+
+	err = app.db.Create(&quotes.Quote{Author: "Gopher", Text: "Errors are values."})
+	if err != nil {
+		t.Fatalf("Cannot fill test store: %v", err)
+	}
+	get := func(path, header, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, path := range []string{"/api/v1/quote/1", "/api/v1/quotes"} {
+		w := get(path, "", "")
+		modified := w.Header().Get("Last-Modified")
+		if w.Code != http.StatusOK || modified == "" || w.Header().Get("Cache-Control") != "public, max-age=60" {
+			t.Fatalf("GET %s = %d, headers %v", path, w.Code, w.Header())
+		}
+		if w := get(path, "If-Modified-Since", modified); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("GET %s If-Modified-Since %s = %d %s, want %d", path, modified, w.Code, w.Body, http.StatusNotModified)
+		}
+		if w := get(path, "If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT"); w.Code != http.StatusOK {
+			t.Errorf("GET %s modified since 2006 = %d, want %d", path, w.Code, http.StatusOK)
+		}
+	}
+
+	// If-None-Match takes precedence.
+	r := httptest.NewRequest("GET", "/api/v1/quote/1", nil)
+	r.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	r.Header.Set("If-None-Match", `"7"`)
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("GET with a stale If-None-Match = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	app.maxAge = 0
+	if w := get("/api/v1/quotes", "", ""); w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("Cache-Control = %q without a max age, want no-cache", w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestApp_routes(t *testing.T) {
+	app := &App{db: quotes.NewMemoryStore()}
+	defer app.db.Close()
+	routes := app.routes("/api/v1/")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		accept string
+		body   string
+		status int
+		want   string
+	}{
+		{"Create", "POST", "/api/v1/quote/", "", `{"author":"Gopher","text":"Errors are values."}`, http.StatusCreated,
+			`{"id":1,"rev":1,"author":"Gopher","text":"Errors are values."}`},
+		{"BadJSON", "POST", "/api/v1/quote", "", `{"author":`, http.StatusBadRequest,
+			`{"error":{"code":"bad_request","message":"invalid JSON body: unexpected EOF"}}`},
+		{"NotAQuote", "POST", "/api/v1/quote", "", `["Gopher"]`, http.StatusUnprocessableEntity,
+			`{"error":{"code":"unprocessable_entity","message":"json: cannot unmarshal array into Go value of type quotes.Quote"}}`},
+		{"Invalid", "POST", "/api/v1/quote", "", `{"author":"  ","text":"Tab\tand\nnewline","source":"a\u0007b"}`, http.StatusUnprocessableEntity,
+			`{"error":{"code":"validation_failed","message":"the quote is invalid","fields":[` +
+				`{"field":"author","code":"required","message":"must not be empty"},` +
+				`{"field":"source","code":"invalid","message":"must not contain control characters"}]}}`},
+		{"Duplicate", "POST", "/api/v1/quote", "", `{"author":"Rob","text":"errors  are VALUES"}`, http.StatusUnprocessableEntity,
+			`{"error":{"code":"validation_failed","message":"the quote is invalid","fields":[` +
+				`{"field":"text","code":"duplicate","message":"nearly duplicates quote 1"}]}}`},
+		{"Get", "GET", "/api/v1/quote/1", "application/*", "", http.StatusOK,
+			`{"id":1,"rev":1,"author":"Gopher","text":"Errors are values."}`},
+		{"Missing", "GET", "/api/v1/quote/42", "", "", http.StatusNotFound,
+			`{"error":{"code":"not_found","message":"quote doesn` + "`" + `t exist"}}`},
+		{"BadID", "GET", "/api/v1/quote/abc", "", "", http.StatusBadRequest,
+			`{"error":{"code":"bad_request","message":"invalid id \"abc\""}}`},
+		{"NotAcceptable", "GET", "/api/v1/quote/1", "image/png, */*;q=0", "", http.StatusNotAcceptable,
+			`{"error":{"code":"not_acceptable","message":"this resource is only available as application/json, text/plain, text/html, text/markdown"}}`},
+		{"NotAcceptableRoute", "GET", "/api/v1/tags", "text/html, application/json;q=0", "", http.StatusNotAcceptable,
+			`{"error":{"code":"not_acceptable","message":"this resource is only available as application/json"}}`},
+		{"MethodNotAllowed", "PATCH", "/api/v1/quote/1", "", "", http.StatusMethodNotAllowed,
+			`{"error":{"code":"method_not_allowed","message":"method PATCH is not allowed"}}`},
+		{"UnknownPath", "GET", "/api/v1/quotations", "", "", http.StatusNotFound,
+			`{"error":{"code":"not_found","message":"no such resource /api/v1/quotations"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, r)
+			if w.Code != tt.status || w.Body.String() != tt.want {
+				t.Errorf("%s %s = %d %s, want %d %s", tt.method, tt.path, w.Code, w.Body, tt.status, tt.want)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("%s %s Content-Type = %s, want application/json", tt.method, tt.path, ct)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("PATCH", "/api/v1/quote/1", nil)
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+	if allow := w.Header().Get("Allow"); allow != "DELETE, GET, HEAD, PUT" {
+		t.Errorf("Allow = %s, want DELETE, GET, HEAD, PUT", allow)
+	}
+}
+
+func TestApp_tenants(t *testing.T) {
+	db, err := quotes.Open("testdb")
+	if err != nil {
+		t.Fatalf("Cannot create test DB")
+	}
+	app := &App{db: db}
+	defer func() {
+		app.db.Close()
+		os.Remove("testdb")
+	}()
+	routes := app.routes("/api/v1/")
+
+	do := func(method, path, tenant, body string) (int, string) {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if tenant != "" {
+			r.Header.Set(tenantHeader, tenant)
+		}
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+		return w.Code, strings.TrimSpace(w.Body.String())
+	}
+
+	tests := []struct {
+		method, path, tenant, body string
+		status                     int
+		want                       string
+	}{
+		{"POST", "/api/v1/admin/tenants", "", `{"name":"red","quotas":{"max_quotes":1}}`, http.StatusCreated, `"max_quotes":1`},
+		{"POST", "/api/v1/admin/tenants", "", `{"name":"blue"}`, http.StatusCreated, `"name":"blue"`},
+		{"POST", "/api/v1/admin/tenants", "", `{"name":"blue"}`, http.StatusConflict, `"code":"conflict"`},
+		{"POST", "/api/v1/admin/tenants", "", `{"name":"Blue!"}`, http.StatusUnprocessableEntity, `"field":"name"`},
+		{"POST", "/api/v1/quote", "", `{"author":"Gopher","text":"Default."}`, http.StatusCreated, `"id":1`},
+		{"POST", "/api/v1/tenants/red/quote", "", `{"author":"Gopher","text":"Red."}`, http.StatusCreated, `"id":1`},
+		{"POST", "/api/v1/quote", "blue", `{"author":"Gopher","text":"Blue."}`, http.StatusCreated, `"id":1`},
+		{"POST", "/api/v1/quote", "red", `{"author":"Gopher","text":"Red again."}`, http.StatusForbidden, `"code":"quota_exceeded"`},
+		{"GET", "/api/v1/quote/1", "", "", http.StatusOK, `"text":"Default."`},
+		{"GET", "/api/v1/tenants/red/quote/1", "", "", http.StatusOK, `"text":"Red."`},
+		{"GET", "/api/v1/quotes?author=Gopher", "blue", "", http.StatusOK, `"text":"Blue."`},
+		{"GET", "/api/v1/tenants/blue/quote/1", "red", "", http.StatusBadRequest, `"code":"bad_request"`},
+		{"GET", "/api/v1/tenants/green/quote/1", "", "", http.StatusNotFound, `"code":"tenant_not_found"`},
+		{"PUT", "/api/v1/admin/tenants/red/quotas", "", `{"max_quotes":2}`, http.StatusOK, `"quotes":1`},
+		{"POST", "/api/v1/quote", "red", `{"author":"Gopher","text":"Red again."}`, http.StatusCreated, `"id":2`},
+		{"GET", "/api/v1/admin/tenants", "", "", http.StatusOK, `[{"name":"blue",`},
+		{"GET", "/api/v1/admin/tenants/red", "red", "", http.StatusOK, `"quotes":2`},
+		{"DELETE", "/api/v1/admin/tenants/red", "", "", http.StatusNoContent, ""},
+		{"GET", "/api/v1/quote/1", "red", "", http.StatusNotFound, `"code":"tenant_not_found"`},
+		{"DELETE", "/api/v1/admin/tenants/red", "", "", http.StatusNotFound, `"code":"tenant_not_found"`},
+	}
+	for _, tt := range tests {
+		status, body := do(tt.method, tt.path, tt.tenant, tt.body)
+		if status != tt.status || !strings.Contains(body, tt.want) {
+			t.Errorf("%s %s for %q = %d %s, want %d with %s", tt.method, tt.path, tt.tenant, status, body, tt.status, tt.want)
+		}
+	}
+
+	// Location headers keep the tenant.
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/tenants/blue/quote", strings.NewReader(`{"author":"Gopher","text":"Blue again."}`)))
+	if loc := w.Header().Get("Location"); loc != "/api/v1/tenants/blue/quote/2" {
+		t.Errorf("Location = %q", loc)
+	}
+
+	// So do the links of feeds, also with the X-Tenant header.
+	for _, tenant := range []string{"", "blue"} {
+		path := "/api/v1/tenants/blue/quotes?limit=1"
+		if tenant != "" {
+			path = "/api/v1/quotes?limit=1"
+		}
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept", "application/rss+xml")
+		if tenant != "" {
+			r.Header.Set(tenantHeader, tenant)
+		}
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+		for _, want := range []string{
+			`<link>http://example.com/api/v1/tenants/blue/quotes?limit=1</link>`,
+			`<link>http://example.com/api/v1/tenants/blue/quote/1</link>`,
+			`href="http://example.com/api/v1/tenants/blue/quotes?cursor=`,
+		} {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("GET %s for %q lacks %q:\n%s", path, tenant, want, w.Body)
+			}
+		}
+	}
+
+	app.db = quotes.NewMemoryStore()
+	if status, _ := do("GET", "/api/v1/quotes", "blue", ""); status != http.StatusNotImplemented {
+		t.Errorf("GET quotes of a tenant of a memory store = %d, want %d", status, http.StatusNotImplemented)
+	}
+	if status, _ := do("GET", "/api/v1/quotes", "", ""); status != http.StatusOK {
+		t.Errorf("GET quotes of a memory store = %d, want %d", status, http.StatusOK)
+	}
+	app.db = db
+}
+
+func TestApp_tenantCaching(t *testing.T) {
+	db, err := quotes.Open("testdb")
+	if err != nil {
+		t.Fatalf("Cannot create test DB")
+	}
+	app := &App{db: db, maxAge: time.Minute}
+	defer func() {
+		app.db.Close()
+		os.Remove("testdb")
+	}()
+	routes := app.routes("/api/v1/")
+	for _, tenant := range []string{"red", "blue"} {
+		if err := db.CreateTenant(&quotes.Tenant{Name: tenant}); err != nil {
+			t.Fatalf("CreateTenant(%s): %v", tenant, err)
+		}
+		if err := db.ForTenant(tenant).Create(&quotes.Quote{Author: "Gopher", Text: "Hello " + tenant + "."}); err != nil {
+			t.Fatalf("Create in %s: %v", tenant, err)
+		}
+	}
+
+	get := func(tenant, match string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/v1/quote/1", nil)
+		r.Header.Set(tenantHeader, tenant)
+		if match != "" {
+			r.Header.Set("If-None-Match", match)
+		}
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+		return w
+	}
+
+	red, blue := get("red", ""), get("blue", "")
+	if red.Code != http.StatusOK || blue.Code != http.StatusOK {
+		t.Fatalf("GET quote 1 = %d and %d, want %d", red.Code, blue.Code, http.StatusOK)
+	}
+	if tag := red.Header().Get("ETag"); tag == blue.Header().Get("ETag") {
+		t.Errorf("ETag of quote 1 = %s in both tenants", tag)
+	}
+	for _, w := range []*httptest.ResponseRecorder{red, blue} {
+		if cc := w.Header().Get("Cache-Control"); cc != "private, max-age=60" {
+			t.Errorf("Cache-Control = %q, want private, max-age=60", cc)
+		}
+		if vary := strings.Join(w.Header()["Vary"], ", "); !strings.Contains(vary, tenantHeader) || !strings.Contains(vary, "Authorization") {
+			t.Errorf("Vary = %q, want %s and Authorization", vary, tenantHeader)
+		}
+	}
+	if w := get("blue", red.Header().Get("ETag")); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Hello blue.") {
+		t.Errorf("GET in blue with the ETag of red = %d %s, want %d", w.Code, w.Body, http.StatusOK)
+	}
+	if w := get("red", red.Header().Get("ETag")); w.Code != http.StatusNotModified {
+		t.Errorf("GET in red with its ETag = %d, want %d", w.Code, http.StatusNotModified)
+	}
+}
+
+func TestApp_tenantKeys(t *testing.T) {
+	db, err := quotes.Open("testdb")
+	if err != nil {
+		t.Fatalf("Cannot create test DB")
+	}
+	app := &App{db: db, keys: db}
+	defer func() {
+		app.db.Close()
+		os.Remove("testdb")
+	}()
+	for _, name := range []string{"red", "blue"} {
+		err := db.CreateTenant(&quotes.Tenant{Name: name})
+		if err != nil {
+			t.Fatalf("CreateTenant() error = %v", err)
+		}
+	}
+	keys := map[string]string{}
+	for _, k := range []*quotes.APIKey{{Name: "editor"}, {Name: "admin", Admin: true}, {Name: "red", Tenant: "red"}, {Name: "blue", Tenant: "blue"}} {
+		key, err := db.CreateAPIKey(k)
+		if err != nil {
+			t.Fatalf("CreateAPIKey() error = %v", err)
+		}
+		keys[k.Name] = key
+	}
+	routes := app.routes("/api/v1/")
+
+	tests := []struct {
+		method, path, tenant, key, body string
+		status                          int
+		want                            string
+	}{
+		{"POST", "/api/v1/quote", "red", "red", `{"author":"Gopher","text":"Red."}`, http.StatusCreated, `"id":1`},
+		{"POST", "/api/v1/tenants/blue/quote", "", "blue", `{"author":"Gopher","text":"Blue."}`, http.StatusCreated, `"id":1`},
+		{"POST", "/api/v1/quote", "", "editor", `{"author":"Gopher","text":"Default."}`, http.StatusCreated, `"id":1`},
+		// Keys only work for their own tenant.
+		{"POST", "/api/v1/quote", "blue", "red", `{"author":"Gopher","text":"Red in blue."}`, http.StatusForbidden, `is for tenant red`},
+		{"POST", "/api/v1/tenants/blue/quote", "", "red", `{"author":"Gopher","text":"Red in blue."}`, http.StatusForbidden, `"code":"forbidden"`},
+		{"POST", "/api/v1/quote", "", "red", `{"author":"Gopher","text":"Red in default."}`, http.StatusForbidden, `is for tenant red`},
+		{"POST", "/api/v1/quote", "red", "editor", `{"author":"Gopher","text":"Default in red."}`, http.StatusForbidden, `is for the default tenant`},
+		{"DELETE", "/api/v1/tenants/red/quote/1", "", "admin", "", http.StatusForbidden, `is for the default tenant`},
+		// So are reads of a tenant.
+		{"GET", "/api/v1/tenants/red/quote/1", "", "", "", http.StatusUnauthorized, `"code":"unauthorized"`},
+		{"GET", "/api/v1/quote/1", "red", "blue", "", http.StatusForbidden, `is for tenant blue`},
+		{"GET", "/api/v1/tenants/red/quote/1", "", "red", "", http.StatusOK, `"text":"Red."`},
+		{"GET", "/api/v1/quote/1", "", "", "", http.StatusOK, `"text":"Default."`},
+		// Tenants are managed with admin keys.
+		{"GET", "/api/v1/admin/tenants", "", "editor", "", http.StatusForbidden, `is not an admin key`},
+		{"POST", "/api/v1/admin/tenants", "", "red", `{"name":"green"}`, http.StatusForbidden, `is not an admin key`},
+		{"DELETE", "/api/v1/admin/tenants/blue", "", "blue", "", http.StatusForbidden, `is not an admin key`},
+		{"POST", "/api/v1/admin/tenants", "", "admin", `{"name":"green"}`, http.StatusCreated, `"name":"green"`},
+		{"DELETE", "/api/v1/admin/tenants/red", "", "admin", "", http.StatusNoContent, ""},
+		// The keys of a dropped tenant are revoked.
+		{"GET", "/api/v1/tenants/red/quote/1", "", "red", "", http.StatusUnauthorized, `invalid API key`},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.tenant != "" {
+			r.Header.Set(tenantHeader, tt.tenant)
+		}
+		if tt.key != "" {
+			r.Header.Set("X-API-Key", keys[tt.key])
+		}
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+		if body := strings.TrimSpace(w.Body.String()); w.Code != tt.status || !strings.Contains(body, tt.want) {
+			t.Errorf("%s %s for %q with key %s = %d %s, want %d with %s", tt.method, tt.path, tt.tenant, tt.key, w.Code, body, tt.status, tt.want)
+		}
+	}
+}
+
+func TestApp_render(t *testing.T) {
+	app := &App{db: quotes.NewMemoryStore()}
+	defer app.db.Close()
+	routes := app.routes("/api/v1/")
+
+	for _, q := range []*quotes.Quote{
+		{Author: "Gopher <g>", Text: "Errors are *values*.\nReally.", Source: "Go Proverbs", Tags: []string{"go"}},
+		{Author: "Rob Pike", Text: "Clear is better than clever."},
+	} {
+		err := app.db.Create(q)
+		if err != nil {
+			t.Fatalf("Cannot fill test store: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		accept    string
+		mediaType string
+		want      []string
+	}{
+		{"Default", "/api/v1/quote/1", "", "application/json", []string{`"author":"Gopher \u003cg\u003e"`}},
+		{"Text", "/api/v1/quote/1", "text/plain", "text/plain; charset=utf-8", []string{"\"Errors are *values*.\nReally.\"\n\n(Gopher <g>, Go Proverbs)\n"}},
+		{"HTML", "/api/v1/quote/1", "text/html", "text/html; charset=utf-8", []string{
+			`<figure class="quote" id="quote-1">`,
+			`<blockquote class="quote-text">Errors are *values*.<br>Really.</blockquote>`,
+			`<figcaption class="quote-author">Gopher &lt;g&gt;, <cite class="quote-source">Go Proverbs</cite></figcaption>`,
+		}},
+		{"Markdown", "/api/v1/quote/1", "text/markdown", "text/markdown; charset=utf-8", []string{"> Errors are \\*values\\*.\n> Really.\n>\n> — Gopher \\<g\\>, *Go Proverbs*\n"}},
+		{"Preferred", "/api/v1/quote/2", "text/html;q=0.5, text/*;q=0.9, */*;q=0.1", "text/plain; charset=utf-8", []string{"Clear is better"}},
+		{"TextList", "/api/v1/quotes", "text/plain", "text/plain; charset=utf-8", []string{"(Gopher <g>, Go Proverbs)\n\n\"Clear"}},
+		{"HTMLList", "/api/v1/quotes", "text/html", "text/html; charset=utf-8", []string{`<section class="quotes">`, `id="quote-2"`}},
+		{"RSS", "/api/v1/quotes?limit=1", "application/rss+xml", "application/rss+xml; charset=utf-8", []string{
+			`<rss version="2.0"`,
+			`<title>Gopher &lt;g&gt;: Errors are *values*. Really.</title>`,
+			`<link>http://example.com/api/v1/quote/1</link>`,
+			`<atom:link rel="next" href="http://example.com/api/v1/quotes?cursor=`,
+		}},
+		{"Atom", "/api/v1/quotes", "application/atom+xml", "application/atom+xml; charset=utf-8", []string{
+			`<feed xmlns="http://www.w3.org/2005/Atom">`,
+			`<entry><title>Rob Pike: Clear is better than clever.</title><id>http://example.com/api/v1/quote/2</id>`,
+			`<author><name>Gopher &lt;g&gt;</name></author>`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, r)
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != tt.mediaType || w.Header().Get("Vary") != "Accept" {
+				t.Fatalf("GET %s = %d, headers %v, want %s", tt.path, w.Code, w.Header(), tt.mediaType)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("GET %s lacks %q:\n%s", tt.path, want, w.Body)
+				}
+			}
+		})
+	}
+
+	// Feeds are only available for lists, and other formats have weak ETags.
+	r := httptest.NewRequest("GET", "/api/v1/quote/1", nil)
+	r.Header.Set("Accept", "application/atom+xml")
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("GET quote as Atom = %d, want %d", w.Code, http.StatusNotAcceptable)
+	}
+	r = httptest.NewRequest("GET", "/api/v1/quote/1", nil)
+	r.Header.Set("Accept", "text/html")
+	r.Header.Set("If-None-Match", `W/"1"`)
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified || w.Header().Get("ETag") != `W/"1"` {
+		t.Errorf("GET card If-None-Match = %d, ETag %s", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestApp_random(t *testing.T) {
+	app := &App{db: quotes.NewMemoryStore()}
+	defer app.db.Close()
+	routes := app.routes("/api/v1/")
+
+	for _, q := range []*quotes.Quote{
+		{Author: "Gopher", Text: "Errors are values."},
+		{Author: "Rob Pike", Text: "Clear is better than clever."},
+		{Author: "Gopher", Text: "Don't panic."},
+	} {
+		err := app.db.Create(q)
+		if err != nil {
+			t.Fatalf("Cannot fill test store: %v", err)
+		}
+	}
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	// The same day in different time zones, or the same seed, picks the
+	// same quote.
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"Daily", "/api/v1/quotes/daily?date=2026-10-18", "/api/v1/quotes/daily?date=2026-10-18"},
+		{"DailyTZ", "/api/v1/quotes/daily?tz=Pacific/Kiritimati", "/api/v1/quotes/daily?tz=Pacific/Kiritimati"},
+		{"Seed", "/api/v1/quotes/random?seed=42", "/api/v1/quotes/random?seed=42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, a := get(tt.a)
+			_, b := get(tt.b)
+			if status != http.StatusOK || a != b {
+				t.Errorf("GET %s = %d %s, then %s, want the same quote", tt.a, status, a, b)
+			}
+		})
+	}
+
+	for i := 0; i < 10; i++ {
+		status, body := get("/api/v1/quotes/random?author=Rob+Pike")
+		if status != http.StatusOK || !strings.Contains(body, `"id":2`) {
+			t.Fatalf("GET random by Rob Pike = %d %s, want quote 2", status, body)
+		}
+	}
+	if status, _ := get("/api/v1/quotes/daily?author=Nobody"); status != http.StatusNotFound {
+		t.Errorf("GET daily by Nobody = %d, want %d", status, http.StatusNotFound)
+	}
+	if status, _ := get("/api/v1/quotes/daily?tz=Mars/Olympus"); status != http.StatusBadRequest {
+		t.Errorf("GET daily in an unknown time zone = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	// Deleting other quotes does not change the quote of the day,
+	// deleting it does.
+	_, daily := get("/api/v1/quotes/daily")
+	var q quotes.Quote
+	json.Unmarshal([]byte(daily), &q)
+	for id := uint64(1); id <= 3; id++ {
+		if id == q.ID {
+			continue
+		}
+		if err := app.db.Delete(id); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if _, again := get("/api/v1/quotes/daily"); again != daily {
+			t.Errorf("GET daily after Delete(%d) = %s, want %s", id, again, daily)
+		}
+	}
+	if err := app.db.Create(&quotes.Quote{Author: "Gopher", Text: "A little copying is better than a little dependency."}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := app.db.Delete(q.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if status, again := get("/api/v1/quotes/daily"); status != http.StatusOK || !strings.Contains(again, `"id":4`) {
+		t.Errorf("GET daily after deleting it = %d %s, want quote 4", status, again)
+	}
+}
+
+func TestApp_tags(t *testing.T) {
+	app := &App{db: quotes.NewMemoryStore()}
+	defer app.db.Close()
+	routes := app.routes("/api/v1/")
+
+	for _, q := range []*quotes.Quote{
+		{Author: "Gopher", Text: "Errors are values.", Tags: []string{"go", "errors"}},
+		{Author: "Rob Pike", Text: "Clear is better than clever.", Tags: []string{"go", "style"}},
+		{Author: "Gopher", Text: "Don't panic.", Tags: []string{"errors"}},
+	} {
+		err := app.db.Create(q)
+		if err != nil {
+			t.Fatalf("Cannot fill test store: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		want   string
+	}{
+		{"Counts", "/api/v1/tags", http.StatusOK, `[{"tag":"errors","count":2},{"tag":"go","count":2},{"tag":"style","count":1}]`},
+		{"All", "/api/v1/quotes?tag=go&tag=errors", http.StatusOK, `"id":1`},
+		{"Any", "/api/v1/quotes?tag=style&tag=errors&tag_match=any&limit=2", http.StatusOK, `"next_cursor"`},
+		{"BadMatch", "/api/v1/quotes?tag=go&tag_match=some", http.StatusBadRequest, `"invalid tag_match some"`},
+		{"Random", "/api/v1/quotes/random?tag=style", http.StatusOK, `"id":2`},
+		{"DailyNone", "/api/v1/quotes/daily?tag=rust", http.StatusNotFound, `"not_found"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("GET %s = %d %s, want %d %s", tt.path, w.Code, w.Body, tt.status, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_undelete(t *testing.T) {
+	app := &App{db: quotes.NewMemoryStore()}
+	defer app.db.Close()
+	routes := app.routes("/api/v1/")
+
+	q := &quotes.Quote{Author: "Gopher", Text: "Errors are values."}
+	err := app.db.Create(q)
+	if err != nil {
+		t.Fatalf("Cannot fill test store: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"RestoreLive", "POST", "/api/v1/quote/1/restore", http.StatusNotFound},
+		{"Delete", "DELETE", "/api/v1/quote/1", http.StatusNoContent},
+		{"DeleteGone", "DELETE", "/api/v1/quote/1", http.StatusNotFound},
+		{"GetGone", "GET", "/api/v1/quote/1", http.StatusNotFound},
+		{"Restore", "POST", "/api/v1/quote/1/restore", http.StatusOK},
+		{"Get", "GET", "/api/v1/quote/1", http.StatusOK},
+		{"RestoreMissing", "POST", "/api/v1/quote/42/restore", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestApp_history(t *testing.T) {
+	app := &App{db: quotes.NewMemoryStore()}
+	defer app.db.Close()
+	routes := app.routes("/api/v1/")
+
+	q := &quotes.Quote{Author: "Gopher", Text: "Errors are values."}
+	err := app.db.Create(q)
+	if err != nil {
+		t.Fatalf("Cannot fill test store: %v", err)
+	}
+	q.Author = "Rob Pike"
+	err = app.db.Update(q)
+	if err != nil {
+		t.Fatalf("Cannot update test store: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"List", "GET", "/api/v1/quote/1/history", http.StatusOK, `"rev":2`},
+		{"Revision", "GET", "/api/v1/quote/1/history/1", http.StatusOK, `"author":"Gopher"`},
+		{"Diff", "GET", "/api/v1/quote/1/history/2/diff", http.StatusOK, `[{"field":"author","from":"Gopher","to":"Rob Pike"}]`},
+		{"Revert", "POST", "/api/v1/quote/1/history/1/revert", http.StatusOK, `{"id":1,"rev":3,"author":"Gopher"`},
+		{"Reverted", "GET", "/api/v1/quote/1/history/3/diff?against=1", http.StatusOK, `[]`},
+		{"MissingRevision", "GET", "/api/v1/quote/1/history/7", http.StatusNotFound, ""},
+		{"MissingQuote", "GET", "/api/v1/quote/42/history", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, r)
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("%s %s = %d %s, want %d %s", tt.method, tt.path, w.Code, w.Body, tt.status, tt.body)
+			}
+		})
+	}
+}
+
+func TestApp_watch(t *testing.T) {
+	db, err := quotes.Open("testdb")
+	if err != nil {
+		t.Fatalf("Cannot create test DB")
+	}
+	app := &App{db: db}
+	defer func() {
+		app.db.Close()
+		os.Remove("testdb")
+	}()
+
+	for _, text := range []string{"Errors are values.", "Don't panic."} {
+		err := app.db.Create(&quotes.Quote{Author: "Gopher", Text: text})
+		if err != nil {
+			t.Fatalf("Cannot fill test DB: %v", err)
+		}
+	}
+
+	server := httptest.NewServer(app.routes("/"))
+	defer server.Close()
+
+	r, err := http.NewRequest("GET", server.URL+"/quotes/watch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("GET watch error = %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %s, want text/event-stream", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func(prefix string) string {
+		for lines.Scan() {
+			if strings.HasPrefix(lines.Text(), prefix) {
+				return lines.Text()
+			}
+		}
+		t.Fatalf("stream ended before %q", prefix)
+		return ""
+	}
+
+	// The missed event 2 is replayed, then live events follow.
+	if got := next("id: "); got != "id: 2" {
+		t.Errorf("replayed %q, want id: 2", got)
+	}
+	err = app.db.Delete(1)
+	if err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if got := next("id: "); got != "id: 3" {
+		t.Errorf("streamed %q, want id: 3", got)
+	}
+	if got := next("event: "); got != "event: deleted" {
+		t.Errorf("streamed %q, want event: deleted", got)
+	}
+
+	// A client that missed trimmed events is told to start over.
+	trimmed := httptest.NewServer((&App{db: trimmedLog{db}}).routes("/"))
+	defer trimmed.Close()
+	r, err = http.NewRequest("GET", trimmed.URL+"/quotes/watch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Last-Event-ID", "0")
+	gone, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("GET watch error = %v", err)
+	}
+	gone.Body.Close()
+	if gone.StatusCode != http.StatusGone {
+		t.Errorf("GET watch after trimmed events = %d, want 410", gone.StatusCode)
+	}
+}
+
+// trimmedLog is a store whose change log was trimmed past event 1.
+type trimmedLog struct {
+	*quotes.DB
+}
+
+func (s trimmedLog) WithContext(ctx context.Context) quotes.QuoteStore {
+	return s
+}
+
+func (s trimmedLog) Changes(after uint64, limit int) ([]*quotes.Event, error) {
+	if after < 1 {
+		return nil, quotes.ErrTrimmed
+	}
+	return s.DB.Changes(after, limit)
+}
+
+func TestApp_collections(t *testing.T) {
+	db, err := quotes.Open("testdb")
+	if err != nil {
+		t.Fatalf("Cannot create test DB")
+	}
+	app := &App{db: db}
+	defer func() {
+		app.db.Close()
+		os.Remove("testdb")
+	}()
+	routes := app.routes("/api/v1/")
+
+	for _, q := range []*quotes.Quote{
+		{Author: "Gopher", Text: "Errors are values."},
+		{Author: "Rob Pike", Text: "Clear is better than clever."},
+	} {
+		err := app.db.Create(q)
+		if err != nil {
+			t.Fatalf("Cannot fill test store: %v", err)
+		}
+	}
+	do := func(method, path, body string) (int, string) {
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w.Code, strings.TrimSpace(w.Body.String())
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		want               string
+	}{
+		{"POST", "/api/v1/collections", `{"name":"Go proverbs","quote_ids":[1]}`, http.StatusCreated, `"quote_ids":[1]`},
+		{"POST", "/api/v1/collections", `{"name":" "}`, http.StatusUnprocessableEntity, `"field":"name","code":"required"`},
+		{"POST", "/api/v1/collections/1/quotes", `{"quote_id":2,"position":0}`, http.StatusOK, `"quote_ids":[2,1]`},
+		{"POST", "/api/v1/collections/1/quotes", `{"quote_id":2}`, http.StatusConflict, `"code":"conflict"`},
+		{"POST", "/api/v1/collections/1/quotes", `{"quote_id":7}`, http.StatusUnprocessableEntity, `quote 7 does not exist`},
+		{"PUT", "/api/v1/collections/1/order", `{"quote_ids":[1,2]}`, http.StatusOK, `"quote_ids":[1,2]`},
+		{"GET", "/api/v1/collections/1/quotes", "", http.StatusOK, `[{"id":1,`},
+		{"GET", "/api/v1/quote/2/collections", "", http.StatusOK, `"name":"Go proverbs"`},
+		{"PUT", "/api/v1/collections/1", `{"name":"Proverbs","description":"Go."}`, http.StatusOK, `"description":"Go."`},
+		{"DELETE", "/api/v1/quote/1", "", http.StatusNoContent, ""},
+		{"GET", "/api/v1/collections", "", http.StatusOK, `"quote_ids":[2]`},
+		{"DELETE", "/api/v1/collections/1/quotes/1", "", http.StatusNotFound, `"code":"not_found"`},
+		{"DELETE", "/api/v1/collections/1", "", http.StatusNoContent, ""},
+		{"GET", "/api/v1/collections/1", "", http.StatusNotFound, `"message":"collection doesn` + "`" + `t exist"`},
+	}
+	for _, tt := range tests {
+		status, body := do(tt.method, tt.path, tt.body)
+		if status != tt.status || !strings.Contains(body, tt.want) {
+			t.Errorf("%s %s = %d %s, want %d with %s", tt.method, tt.path, status, body, tt.status, tt.want)
+		}
+	}
+
+	app.db = quotes.NewMemoryStore()
+	if status, _ := do("GET", "/api/v1/collections", ""); status != http.StatusNotImplemented {
+		t.Errorf("GET collections of a memory store = %d, want %d", status, http.StatusNotImplemented)
+	}
+	app.db = db
+}
+
+func TestApp_apiKeys(t *testing.T) {
+	db, err := quotes.Open("testdb")
+	if err != nil {
+		t.Fatalf("Cannot create test DB")
+	}
+	app := &App{db: db, keys: db}
+	defer func() {
+		app.db.Close()
+		os.Remove("testdb")
+	}()
+
+	key, err := db.CreateAPIKey(&quotes.APIKey{Name: "editor", Rate: 60, Burst: 2})
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+	adminKey, err := db.CreateAPIKey(&quotes.APIKey{Name: "admin", Admin: true})
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+	routes := app.routes("/api/v1/")
+	posted := 0
+	post := func(header, value string) *httptest.ResponseRecorder {
+		posted++
+		body := fmt.Sprintf(`{"author":"Gopher","text":"Errors are values, %d."}`, posted)
+		r := httptest.NewRequest("POST", "/api/v1/quote", strings.NewReader(body))
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+		return w
+	}
+
+	// Reading needs no key.
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/quotes", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET quotes without a key = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if w := post("", ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("POST without a key = %d %s", w.Code, w.Body)
+	}
+	if w := post("Authorization", "Bearer qk_wrong"); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), `"unauthorized"`) {
+		t.Errorf("POST with a wrong key = %d %s", w.Code, w.Body)
+	}
+
+	// The burst of 2 is used up by two requests.
+	for i, remaining := range []string{"1", "0"} {
+		header, value := "Authorization", "Bearer "+key
+		if i == 1 {
+			header, value = "X-API-Key", key
+		}
+		w := post(header, value)
+		if w.Code != http.StatusCreated || w.Header().Get("X-RateLimit-Limit") != "2" || w.Header().Get("X-RateLimit-Remaining") != remaining {
+			t.Errorf("POST %d with a key = %d, headers %v", i, w.Code, w.Header())
+		}
+	}
+	// The revisions record the key they were written with.
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/quote/1/history", nil))
+	if !strings.Contains(w.Body.String(), `"actor":1}`) {
+		t.Errorf("GET history of a quote written with key 1 = %d %s", w.Code, w.Body)
+	}
+
+	w = post("X-API-Key", key)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" || w.Header().Get("X-RateLimit-Reset") == "" {
+		t.Errorf("POST over the limit = %d, headers %v", w.Code, w.Header())
+	}
+
+	// Backups need an admin key.
+	for _, tt := range []struct {
+		key    string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{key, http.StatusForbidden},
+		{adminKey, http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", "/api/v1/admin/backup", nil)
+		r.Header.Set("X-API-Key", tt.key)
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("GET backup with key %q = %d, want %d", tt.key, w.Code, tt.status)
+		}
+	}
+
+	// So is managing keys, which the apikey command does through the
+	// server while it runs.
+	server := httptest.NewServer(routes)
+	defer server.Close()
+	if _, err := (&keyClient{url: server.URL + "/api/v1/admin/keys", key: key}).APIKeys(); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("APIKeys() with an editor key error = %v, want a 403", err)
+	}
+	client := &keyClient{url: server.URL + "/api/v1/admin/keys", key: adminKey}
+	tool := &quotes.APIKey{Name: "tool", Rate: 60, Burst: 5}
+	toolKey, err := client.CreateAPIKey(tool)
+	if err != nil || tool.ID != 3 || !strings.HasPrefix(toolKey, tool.Hint) {
+		t.Fatalf("CreateAPIKey() = %q, %+v, %v", toolKey, tool, err)
+	}
+	if w := post("X-API-Key", toolKey); w.Code != http.StatusCreated {
+		t.Errorf("POST with a key created through the server = %d %s", w.Code, w.Body)
+	}
+	if keys, err := client.APIKeys(); err != nil || len(keys) != 3 || keys[2].Name != "tool" {
+		t.Errorf("APIKeys() = %v, %v", keys, err)
+	}
+	if _, err := client.CreateAPIKey(&quotes.APIKey{Name: "red", Tenant: "red"}); err != quotes.ErrTenantNotFound {
+		t.Errorf("CreateAPIKey() for a missing tenant error = %v, want %v", err, quotes.ErrTenantNotFound)
+	}
+	if _, err := client.CreateAPIKey(&quotes.APIKey{}); err == nil || !strings.Contains(err.Error(), "name") {
+		t.Errorf("CreateAPIKey() without a name error = %v", err)
+	}
+	if err := client.RevokeAPIKey(tool.ID); err != nil {
+		t.Errorf("RevokeAPIKey() error = %v", err)
+	}
+	if err := client.RevokeAPIKey(tool.ID); err != quotes.ErrNotFound {
+		t.Errorf("RevokeAPIKey() of a revoked key error = %v, want %v", err, quotes.ErrNotFound)
+	}
+	if w := post("X-API-Key", toolKey); w.Code != http.StatusUnauthorized {
+		t.Errorf("POST with a revoked key = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestFlagsFromEnv(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:8000", "")
+	retention := fs.Duration("trash-retention", time.Hour, "")
+	store := fs.String("store", "bolt", "")
+	os.Setenv("QUOTES_ADDR", ":9000")
+	os.Setenv("QUOTES_TRASH_RETENTION", "2h")
+	os.Setenv("QUOTES_STORE", "memory")
+	defer func() {
+		for _, name := range []string{"QUOTES_ADDR", "QUOTES_TRASH_RETENTION", "QUOTES_STORE"} {
+			os.Unsetenv(name)
+		}
+	}()
+
+	// The command line wins over the environment.
+	err := fs.Parse([]string{"-store", "sqlite"})
+	if err == nil {
+		err = flagsFromEnv(fs)
+	}
+	if err != nil || *addr != ":9000" || *retention != 2*time.Hour || *store != "sqlite" {
+		t.Errorf("flagsFromEnv() = %v, got %s %s %s", err, *addr, *retention, *store)
+	}
+
+	os.Setenv("QUOTES_TRASH_RETENTION", "forever")
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Duration("trash-retention", time.Hour, "")
+	if err := flagsFromEnv(fs); err == nil {
+		t.Error("flagsFromEnv() with an invalid duration succeeded")
+	}
+}
+
+func TestApp_monitoring(t *testing.T) {
+	db, err := quotes.Open("testdb")
+	if err != nil {
+		t.Fatalf("Cannot create test DB")
+	}
+	app := &App{db: db, metrics: newMetrics()}
+	defer os.Remove("testdb")
+	handler := app.handler("/api/v1/")
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"/api/v1/quotes", http.StatusOK},
+		{"/api/v1/quote/7", http.StatusNotFound},
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusOK},
+		{"/hello", http.StatusNotFound},
+	} {
+		if w := get(tt.path); w.Code != tt.status || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("GET %s = %d %s, want %d", tt.path, w.Code, w.Body, tt.status)
+		}
+	}
+
+	w := get("/metrics")
+	for _, want := range []string{
+		`quotes_http_requests_total{route="/api/v1/quotes",method="GET",status="200"} 1`,
+		`quotes_http_requests_total{route="/api/v1/quote/{id}",method="GET",status="404"} 1`,
+		`quotes_http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`quotes_http_request_duration_seconds_count{route="/readyz",method="GET"} 1`,
+		`quotes_http_request_duration_seconds_bucket{route="/healthz",method="GET",le="+Inf"} 1`,
+		"# TYPE quotes_bolt_free_pages gauge",
+		"quotes_bolt_pages ",
+		"# TYPE quotes_cache_hits_total counter",
+		"quotes_cache_misses_total ",
+		"# TYPE quotes_bolt_tx_duration_seconds histogram",
+		`quotes_bolt_tx_duration_seconds_bucket{op="ListPage",le="+Inf"} 1`,
+		`quotes_bolt_tx_duration_seconds_count{op="Modified"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("GET /metrics lacks %s:\n%s", want, w.Body)
+		}
+	}
+
+	db.Close()
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if w := get(path); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET %s on a closed DB = %d, want %d", path, w.Code, http.StatusServiceUnavailable)
+		}
+	}
+
+	// Readiness does not trust reads that a cache may have answered.
+	w = httptest.NewRecorder()
+	(&App{db: unreachable{quotes.NewMemoryStore()}}).handler("/api/v1/").ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz on an unreachable store = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+// unreachable is a store that still answers reads, from a cache say, but
+// fails its Ping.
+type unreachable struct {
+	*quotes.MemoryStore
+}
+
+func (unreachable) Ping() error {
+	return errors.New("database is wedged")
+}
+
+// spanRecorder collects exported spans.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*trace.Span
+}
+
+func (r *spanRecorder) Export(s *trace.Span) {
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+}
+
+func TestApp_observe(t *testing.T) {
+	var logged bytes.Buffer
+	logs.out = &logged
+	defer func() { logs.out = os.Stderr }()
+
+	db, err := quotes.Open("testdb")
+	if err != nil {
+		t.Fatalf("Cannot create test DB")
+	}
+	defer func() {
+		db.Close()
+		os.Remove("testdb")
+	}()
+	rec := &spanRecorder{}
+	app := &App{db: db, tracer: trace.NewTracer(rec)}
+	handler := app.handler("/api/v1/")
+
+	err = db.Create(&quotes.Quote{Author: "Gopher", Text: "Errors are values."})
+	if err != nil {
+		t.Fatalf("Cannot fill test DB: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/api/v1/quote/1", nil)
+	r.Header.Set("X-Request-ID", "req-42")
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("X-Request-ID") != "req-42" {
+		t.Errorf("GET quote = %d, X-Request-ID %q", w.Code, w.Header().Get("X-Request-ID"))
+	}
+
+	var line map[string]interface{}
+	err = json.Unmarshal(logged.Bytes(), &line)
+	if err != nil {
+		t.Fatalf("log line %q is not JSON: %v", logged.String(), err)
+	}
+	for k, want := range map[string]interface{}{
+		"level":      "info",
+		"msg":        "request",
+		"request_id": "req-42",
+		"trace_id":   "4bf92f3577b34da6a3ce929d0e0e4736",
+		"method":     "GET",
+		"path":       "/api/v1/quote/1",
+		"route":      "GET /api/v1/quote/{id}",
+		"status":     float64(200),
+		"bytes":      float64(w.Body.Len()),
+	} {
+		if line[k] != want {
+			t.Errorf("log %s = %v, want %v", k, line[k], want)
+		}
+	}
+	if _, ok := line["duration_ms"].(float64); !ok {
+		t.Errorf("log lacks duration_ms: %s", logged.String())
+	}
+
+	// The store calls are children of the request span.
+	if len(rec.spans) != 3 {
+		t.Fatalf("exported %d spans, want 3", len(rec.spans))
+	}
+	req := rec.spans[2]
+	for i, name := range []string{"quotes.Modified", "quotes.Get"} {
+		s := rec.spans[i]
+		if s.Name() != name || s.ParentID != req.SpanID || s.TraceID != req.TraceID {
+			t.Errorf("store span %s is not a %s child of %s", s.Name(), name, req.Name())
+		}
+	}
+	if req.Name() != "GET /api/v1/quote/{id}" || req.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("request span = %s with parent %s", req.Name(), req.ParentID)
+	}
+
+	// Unusable request IDs are replaced.
+	r = httptest.NewRequest("GET", "/healthz", nil)
+	r.Header.Set("X-Request-ID", "no spaces allowed")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if id := w.Header().Get("X-Request-ID"); id == "" || id == "no spaces allowed" {
+		t.Errorf("X-Request-ID = %q, want a new ID", id)
+	}
+}
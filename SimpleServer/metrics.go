@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"test/quotes"
+)
+
+// durationBuckets are the upper bounds of the request latency histogram
+// in seconds.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metrics counts requests per route, method and status and records their
+// latency per route and method.
+type metrics struct {
+	mu        sync.Mutex
+	requests  map[statusKey]uint64
+	durations map[routeKey]*histogram
+}
+
+type routeKey struct {
+	route, method string
+}
+
+type statusKey struct {
+	routeKey
+	status int
+}
+
+type histogram struct {
+	// counts[i] is the number of observations up to durationBuckets[i];
+	// the last element counts those above all bounds.
+	counts []uint64
+	sum    float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:  map[statusKey]uint64{},
+		durations: map[routeKey]*histogram{},
+	}
+}
+
+// observe records a request to route that was answered with status
+// after d.
+func (m *metrics) observe(route, method string, status int, d time.Duration) {
+	rk := routeKey{route, method}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[statusKey{rk, status}]++
+	h, ok := m.durations[rk]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets)+1)}
+		m.durations[rk] = h
+	}
+	i := sort.SearchFloat64s(durationBuckets, d.Seconds())
+	h.counts[i]++
+	h.sum += d.Seconds()
+}
+
+// writeTo writes the request metrics in the Prometheus text format.
+func (m *metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	requests := make([]statusKey, 0, len(m.requests))
+	for k := range m.requests {
+		requests = append(requests, k)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.routeKey != b.routeKey {
+			return a.routeKey.less(b.routeKey)
+		}
+		return a.status < b.status
+	})
+	fmt.Fprintln(w, "# HELP quotes_http_requests_total Requests by route, method and status.")
+	fmt.Fprintln(w, "# TYPE quotes_http_requests_total counter")
+	for _, k := range requests {
+		fmt.Fprintf(w, "quotes_http_requests_total{%s,status=\"%d\"} %d\n", k.labels(), k.status, m.requests[k])
+	}
+
+	routes := make([]routeKey, 0, len(m.durations))
+	for k := range m.durations {
+		routes = append(routes, k)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].less(routes[j]) })
+	fmt.Fprintln(w, "# HELP quotes_http_request_duration_seconds Request latency by route and method.")
+	fmt.Fprintln(w, "# TYPE quotes_http_request_duration_seconds histogram")
+	for _, k := range routes {
+		h := m.durations[k]
+		writeHistogram(w, "quotes_http_request_duration_seconds", k.labels(), durationBuckets, h.counts, h.sum)
+	}
+}
+
+// writeHistogram writes the samples of a histogram with the given labels.
+// counts[i] is the number of observations up to bounds[i]; the last
+// element counts those above all bounds.
+func writeHistogram(w io.Writer, name, labels string, bounds []float64, counts []uint64, sum float64) {
+	var n uint64
+	for i, le := range bounds {
+		n += counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(le), n)
+	}
+	n += counts[len(bounds)]
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, n)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, n)
+}
+
+func (k routeKey) less(o routeKey) bool {
+	if k.route != o.route {
+		return k.route < o.route
+	}
+	return k.method < o.method
+}
+
+func (k routeKey) labels() string {
+	return "route=" + strconv.Quote(k.route) + ",method=" + strconv.Quote(k.method)
+}
+
+// writeBoltStats writes the statistics of a Bolt store in the Prometheus
+// text format.
+func writeBoltStats(w io.Writer, s *quotes.Stats) {
+	metric := func(name, kind, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatFloat(value))
+	}
+	metric("quotes_bolt_pages", "gauge", "Pages of the database file.", float64(s.Pages))
+	metric("quotes_bolt_page_size_bytes", "gauge", "Size of a page.", float64(s.PageSize))
+	metric("quotes_bolt_free_pages", "gauge", "Free pages on the freelist.", float64(s.FreePageN))
+	metric("quotes_bolt_pending_pages", "gauge", "Pages freed by transactions that are still read.", float64(s.PendingPageN))
+	metric("quotes_bolt_free_alloc_bytes", "gauge", "Bytes allocated in free pages.", float64(s.FreeAlloc))
+	metric("quotes_bolt_freelist_bytes", "gauge", "Bytes used by the freelist.", float64(s.FreelistInuse))
+	metric("quotes_bolt_read_tx_total", "counter", "Started read transactions.", float64(s.TxN))
+	metric("quotes_bolt_open_read_tx", "gauge", "Open read transactions.", float64(s.OpenTxN))
+	metric("quotes_bolt_tx_pages_allocated_total", "counter", "Pages allocated by transactions.", float64(s.TxStats.PageCount))
+	metric("quotes_bolt_tx_page_alloc_bytes_total", "counter", "Bytes allocated for pages by transactions.", float64(s.TxStats.PageAlloc))
+	metric("quotes_bolt_tx_cursors_total", "counter", "Cursors created by transactions.", float64(s.TxStats.CursorCount))
+	metric("quotes_bolt_tx_nodes_total", "counter", "Nodes allocated by transactions.", float64(s.TxStats.NodeCount))
+	metric("quotes_bolt_tx_rebalances_total", "counter", "Node rebalances.", float64(s.TxStats.Rebalance))
+	metric("quotes_bolt_tx_rebalance_seconds_total", "counter", "Time spent rebalancing nodes.", s.TxStats.RebalanceTime.Seconds())
+	metric("quotes_bolt_tx_splits_total", "counter", "Node splits.", float64(s.TxStats.Split))
+	metric("quotes_bolt_tx_spills_total", "counter", "Node spills.", float64(s.TxStats.Spill))
+	metric("quotes_bolt_tx_spill_seconds_total", "counter", "Time spent spilling nodes.", s.TxStats.SpillTime.Seconds())
+	metric("quotes_bolt_tx_writes_total", "counter", "Page writes by committed transactions.", float64(s.TxStats.Write))
+	metric("quotes_bolt_tx_write_seconds_total", "counter", "Time spent writing pages to disk.", s.TxStats.WriteTime.Seconds())
+	metric("quotes_cache_hits_total", "counter", "Reads served from the cache.", float64(s.Cache.Hits))
+	metric("quotes_cache_misses_total", "counter", "Reads not found in the cache.", float64(s.Cache.Misses))
+	metric("quotes_cache_evictions_total", "counter", "Cached reads evicted to make room.", float64(s.Cache.Evictions))
+	metric("quotes_cache_invalidations_total", "counter", "Cached reads dropped by writes.", float64(s.Cache.Invalidations))
+	metric("quotes_cache_entries", "gauge", "Cached reads.", float64(s.Cache.Entries))
+
+	fmt.Fprintln(w, "# HELP quotes_bolt_tx_duration_seconds Duration of the Bolt transactions by store operation.")
+	fmt.Fprintln(w, "# TYPE quotes_bolt_tx_duration_seconds histogram")
+	for _, h := range s.Tx {
+		writeHistogram(w, "quotes_bolt_tx_duration_seconds", "op="+strconv.Quote(h.Op), quotes.TxDurationBuckets, h.Counts, h.Sum)
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// GET metrics handler, in the Prometheus text format.
+func (app *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var stats *quotes.Stats
+	if d, ok := app.db.(*quotes.DB); ok {
+		var err error
+		stats, err = d.Stats()
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	if app.metrics != nil {
+		app.metrics.writeTo(bw)
+	}
+	if stats != nil {
+		writeBoltStats(bw, stats)
+	}
+	bw.Flush()
+}
+
+// GET liveness probe handler. Answers 200 while the store is open.
+func (app *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if p, ok := app.db.(quotes.Pinger); ok {
+		err := p.Ping()
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, "unavailable", err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// GET readiness probe handler. Answers 200 if quotes can be read. Stores
+// that are a Pinger are probed with a read transaction of their own, which
+// no cache can answer in their place.
+func (app *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if p, ok := app.db.(quotes.Pinger); ok {
+		err = p.Ping()
+	} else {
+		_, err = quotes.ListPage(app.store(r), quotes.ListOptions{Limit: 1})
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "unavailable", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// statusRecorder remembers the status and size of a response for the
+// metrics and the log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming handlers working.
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,209 @@
+package quotes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// apiKeyBucket maps the SHA-256 hash of an API key to its APIKey record.
+// The key itself is never stored.
+const apiKeyBucket = "apikeys"
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to grep
+// for.
+const apiKeyPrefix = "qk_"
+
+// APIKey describes an API key. Rate and Burst configure its token-bucket
+// rate limit.
+type APIKey struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+	// Hint is the start of the key, enough to tell keys apart.
+	Hint string `json:"hint"`
+	// Rate is the number of requests per minute the key is allowed.
+	Rate float64 `json:"rate"`
+	// Burst is the number of requests the key may make at once.
+	Burst int `json:"burst"`
+	// Tenant is the tenant whose quotes the key is for, "" for the
+	// default tenant.
+	Tenant string `json:"tenant,omitempty"`
+	// Admin keys may also back up the database and manage tenants and
+	// keys. They are for the default tenant.
+	Admin     bool      `json:"admin"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateAPIKey creates a new API key with the name, limits and scope of k
+// and returns it. The ID, Hint and CreatedAt of k are filled in. The key
+// is only returned here; the database keeps its hash. It fails with
+// ErrTenantNotFound if there is no tenant k.Tenant, and with a
+// ValidationError for a key without a name, with negative limits or for
+// an admin key of a tenant.
+func (d *DB) CreateAPIKey(k *APIKey) (string, error) {
+	err := checkAPIKey(k)
+	if err != nil {
+		return "", err
+	}
+	secret := make([]byte, 24)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", errors.Wrap(err, "CreateAPIKey: cannot generate key")
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	k.Hint = key[:len(apiKeyPrefix)+4]
+	k.CreatedAt = time.Now().UTC()
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		if k.Tenant != "" && tx.Bucket([]byte(tenantPrefix+k.Tenant)) == nil {
+			return ErrTenantNotFound
+		}
+		b := tx.Bucket([]byte(apiKeyBucket))
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		k.ID = id
+		v, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+		return b.Put(hashAPIKey(key), v)
+	})
+	if err == ErrTenantNotFound {
+		return "", err
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "CreateAPIKey: DB.Update() failed")
+	}
+	return key, nil
+}
+
+func checkAPIKey(k *APIKey) error {
+	fields := []FieldError{}
+	if k.Name == "" {
+		fields = append(fields, FieldError{"name", "required", "must not be empty"})
+	}
+	if k.Rate < 0 {
+		fields = append(fields, FieldError{"rate", "invalid", "must not be negative"})
+	}
+	if k.Burst < 0 {
+		fields = append(fields, FieldError{"burst", "invalid", "must not be negative"})
+	}
+	if k.Admin && k.Tenant != "" {
+		fields = append(fields, FieldError{"tenant", "invalid", "admin keys are for the default tenant"})
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// LookupAPIKey returns the record of key, or fails with ErrNotFound if
+// there is no such key or it has been revoked.
+func (d *DB) LookupAPIKey(key string) (*APIKey, error) {
+	var k *APIKey
+	err := d.view("LookupAPIKey", func(tx namespace) error {
+		v := tx.Bucket([]byte(apiKeyBucket)).Get(hashAPIKey(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		k = &APIKey{}
+		return json.Unmarshal(v, k)
+	})
+	if err == ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "LookupAPIKey: DB.View() failed")
+	}
+	return k, nil
+}
+
+// APIKeys lists the records of all API keys, ordered by ID.
+func (d *DB) APIKeys() ([]*APIKey, error) {
+	keys := []*APIKey{}
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(apiKeyBucket)).ForEach(func(_, v []byte) error {
+			k := &APIKey{}
+			err := json.Unmarshal(v, k)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, k)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "APIKeys: DB.View() failed")
+	}
+	// The bucket is ordered by hash.
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+// RevokeAPIKey deletes the API key with the given ID, or fails with
+// ErrNotFound.
+func (d *DB) RevokeAPIKey(id uint64) error {
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(apiKeyBucket)).Cursor()
+		for h, v := c.First(); h != nil; h, v = c.Next() {
+			k := &APIKey{}
+			err := json.Unmarshal(v, k)
+			if err != nil {
+				return err
+			}
+			if k.ID == id {
+				return c.Delete()
+			}
+		}
+		return ErrNotFound
+	})
+	if err == ErrNotFound {
+		return err
+	}
+	if err != nil {
+		return errors.Wrap(err, "RevokeAPIKey: DB.Update() failed")
+	}
+	return nil
+}
+
+// revokeTenantKeys deletes the API keys of the tenant called name within
+// tx.
+func revokeTenantKeys(tx *bolt.Tx, name string) error {
+	b := tx.Bucket([]byte(apiKeyBucket))
+	hashes := [][]byte{}
+	err := b.ForEach(func(h, v []byte) error {
+		k := &APIKey{}
+		err := json.Unmarshal(v, k)
+		if err != nil {
+			return err
+		}
+		if k.Tenant == name {
+			hashes = append(hashes, h)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		err := b.Delete(h)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashAPIKey returns the bucket key of an API key.
+func hashAPIKey(key string) []byte {
+	h := sha256.Sum256([]byte(key))
+	return h[:]
+}
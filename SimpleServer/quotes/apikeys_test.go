@@ -0,0 +1,112 @@
+package quotes
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDB_APIKeys(t *testing.T) {
+	path := "testdata/apikeysdb"
+
+	// Setup
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer func() {
+		// Teardown
+		d.Close()
+		os.Remove(path)
+	}()
+
+	editorKey := &APIKey{Name: "editor", Rate: 60, Burst: 10}
+	editor, err := d.CreateAPIKey(editorKey)
+	if err != nil {
+		t.Fatalf("DB.CreateAPIKey() error = %v", err)
+	}
+	if !strings.HasPrefix(editor, apiKeyPrefix) || editorKey.ID != 1 || editorKey.Hint != editor[:len(editorKey.Hint)] {
+		t.Errorf("DB.CreateAPIKey() = %q, %+v", editor, editorKey)
+	}
+	toolKey := &APIKey{Name: "tool", Rate: 1, Burst: 1, Admin: true}
+	tool, err := d.CreateAPIKey(toolKey)
+	if err != nil {
+		t.Fatalf("DB.CreateAPIKey() error = %v", err)
+	}
+	if tool == editor {
+		t.Errorf("DB.CreateAPIKey() returned the same key twice")
+	}
+
+	got, err := d.LookupAPIKey(editor)
+	if err != nil || !reflect.DeepEqual(got, editorKey) {
+		t.Errorf("DB.LookupAPIKey() = %+v, %v, want %+v", got, err, editorKey)
+	}
+	_, err = d.LookupAPIKey(editor + "x")
+	if err != ErrNotFound {
+		t.Errorf("DB.LookupAPIKey() of an unknown key error = %v, want %v", err, ErrNotFound)
+	}
+
+	// The database must not contain the key itself.
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Cannot read %s: %v", path, err)
+	}
+	if strings.Contains(string(raw), editor) {
+		t.Errorf("%s contains the API key in clear text", path)
+	}
+
+	keys, err := d.APIKeys()
+	if err != nil || len(keys) != 2 || keys[0].ID != editorKey.ID || keys[1].ID != toolKey.ID || keys[0].Admin || !keys[1].Admin {
+		t.Errorf("DB.APIKeys() = %+v, %v", keys, err)
+	}
+
+	err = d.RevokeAPIKey(editorKey.ID)
+	if err != nil {
+		t.Fatalf("DB.RevokeAPIKey() error = %v", err)
+	}
+	_, err = d.LookupAPIKey(editor)
+	if err != ErrNotFound {
+		t.Errorf("DB.LookupAPIKey() of a revoked key error = %v, want %v", err, ErrNotFound)
+	}
+	err = d.RevokeAPIKey(editorKey.ID)
+	if err != ErrNotFound {
+		t.Errorf("DB.RevokeAPIKey() of a revoked key error = %v, want %v", err, ErrNotFound)
+	}
+	_, err = d.LookupAPIKey(tool)
+	if err != nil {
+		t.Errorf("DB.LookupAPIKey() of the remaining key error = %v", err)
+	}
+
+	// Keys of a tenant go with it.
+	if _, err := d.CreateAPIKey(&APIKey{Name: "red", Tenant: "red"}); err != ErrTenantNotFound {
+		t.Errorf("DB.CreateAPIKey() for a missing tenant error = %v, want %v", err, ErrTenantNotFound)
+	}
+	err = d.CreateTenant(&Tenant{Name: "red"})
+	if err != nil {
+		t.Fatalf("DB.CreateTenant() error = %v", err)
+	}
+	if _, err := d.CreateAPIKey(&APIKey{Rate: -1}); !isValidationError(err) {
+		t.Errorf("DB.CreateAPIKey() without a name error = %v, want a ValidationError", err)
+	}
+	if _, err := d.CreateAPIKey(&APIKey{Name: "red admin", Tenant: "red", Admin: true}); !isValidationError(err) {
+		t.Errorf("DB.CreateAPIKey() of an admin key for a tenant error = %v, want a ValidationError", err)
+	}
+	red, err := d.CreateAPIKey(&APIKey{Name: "red", Tenant: "red"})
+	if err != nil {
+		t.Fatalf("DB.CreateAPIKey() for a tenant error = %v", err)
+	}
+	if k, err := d.LookupAPIKey(red); err != nil || k.Tenant != "red" {
+		t.Errorf("DB.LookupAPIKey() of a tenant key = %+v, %v", k, err)
+	}
+	err = d.DropTenant("red")
+	if err != nil {
+		t.Fatalf("DB.DropTenant() error = %v", err)
+	}
+	if _, err := d.LookupAPIKey(red); err != ErrNotFound {
+		t.Errorf("DB.LookupAPIKey() of a key of a dropped tenant error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := d.LookupAPIKey(tool); err != nil {
+		t.Errorf("DB.LookupAPIKey() after DropTenant() error = %v", err)
+	}
+}
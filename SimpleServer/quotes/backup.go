@@ -0,0 +1,176 @@
+package quotes
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// ErrLocked is returned when another process holds the database file,
+// typically a running server.
+var ErrLocked = errors.New("database is in use by another process")
+
+// lockTimeout is how long Open, OpenReadOnly and Restore wait for the file
+// lock.
+var lockTimeout = time.Second
+
+// Backuper is implemented by stores that can stream a snapshot of
+// themselves.
+type Backuper interface {
+	Backup(w io.Writer) (int64, error)
+}
+
+// Backup writes a consistent snapshot of the database to w and returns the
+// number of bytes written. It runs in a read transaction, so writers are
+// not blocked while the snapshot streams.
+func (d *DB) Backup(w io.Writer) (int64, error) {
+	var n int64
+	// The snapshot holds all tenants.
+	span := d.startSpan("Backup", false)
+	err := d.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	endSpan(span, err)
+	if err != nil {
+		return n, errors.Wrap(err, "Backup: cannot write snapshot")
+	}
+	return n, nil
+}
+
+// OpenReadOnly opens the database file at path for reading only, without
+// migrating it. It fails with ErrLocked if a writer has the file open.
+func OpenReadOnly(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: lockTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenReadOnly: cannot open DB file "+path)
+	}
+	return newDB(db), nil
+}
+
+// ValidateSnapshot checks that the file at path is a consistent Bolt
+// database with a schema this version can migrate and readable quotes.
+func ValidateSnapshot(path string) error {
+	d, err := OpenReadOnly(path)
+	if err != nil {
+		return errors.Wrap(err, "ValidateSnapshot")
+	}
+	defer d.Close()
+
+	err = d.db.View(func(tx *bolt.Tx) error {
+		// Drain the channel completely, the check runs in a goroutine
+		// that must finish before the transaction ends.
+		var checkErr error
+		for err := range tx.Check() {
+			if checkErr == nil {
+				checkErr = err
+			}
+		}
+		if checkErr != nil {
+			return errors.Wrap(checkErr, "consistency check failed")
+		}
+
+		version := schemaVersion(tx)
+		latest := migrations[len(migrations)-1].Version
+		if version > latest {
+			return errors.Errorf("schema version %d is newer than supported version %d", version, latest)
+		}
+
+		return namespaces(tx, func(_ string, tx namespace) error {
+			b := tx.Bucket([]byte(quoteBucket))
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(k, v []byte) error {
+				q := &Quote{}
+				return errors.Wrapf(q.Deserialize(v), "record %x", k)
+			})
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "ValidateSnapshot: "+path)
+	}
+	return nil
+}
+
+// Restore validates the snapshot at snapshotPath and atomically replaces
+// the database file at path with it. It fails with ErrLocked while a
+// server has the database open.
+func Restore(snapshotPath, path string) error {
+	err := ValidateSnapshot(snapshotPath)
+	if err != nil {
+		return err
+	}
+
+	// Hold the lock on the current file while it is being replaced,
+	// so that no server opens it in between.
+	if _, err := os.Stat(path); err == nil {
+		current, err := bolt.Open(path, 0600, &bolt.Options{Timeout: lockTimeout})
+		if err == bolt.ErrTimeout {
+			return ErrLocked
+		}
+		if err != nil {
+			return errors.Wrap(err, "Restore: cannot open DB file "+path)
+		}
+		defer current.Close()
+	}
+
+	tmp, err := copyToTemp(snapshotPath, filepath.Dir(path))
+	if err != nil {
+		return errors.Wrap(err, "Restore")
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "Restore: cannot replace "+path)
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// copyToTemp copies the file src to a new file in dir, syncs it to disk
+// and returns its name.
+func copyToTemp(src, dir string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(dir, ".restore-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Chmod(0600)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
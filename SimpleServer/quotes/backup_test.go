@@ -0,0 +1,94 @@
+package quotes
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDB_BackupRestore(t *testing.T) {
+	path := "testdata/backupdb"
+	snapshot := "testdata/backupdb.snapshot"
+	restored := "testdata/restoredb"
+
+	// Setup
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer func() {
+		// Teardown
+		d.Close()
+		for _, p := range []string{path, snapshot, restored} {
+			os.Remove(p)
+		}
+	}()
+
+	for _, q := range []*Quote{
+		{Author: "Gopher", Text: "Errors are values."},
+		{Author: "Rob Pike", Text: "Clear is better than clever."},
+	} {
+		err := d.Create(q)
+		if err != nil {
+			t.Fatalf("Cannot fill test database: " + err.Error())
+		}
+	}
+	want, err := d.List()
+	if err != nil {
+		t.Fatalf("DB.List() error = %v", err)
+	}
+
+	// Back up while the database is open.
+	var buf bytes.Buffer
+	n, err := d.Backup(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("DB.Backup() = %d, %v, wrote %d bytes", n, err, buf.Len())
+	}
+	err = os.WriteFile(snapshot, buf.Bytes(), 0600)
+	if err != nil {
+		t.Fatalf("Cannot write snapshot: %v", err)
+	}
+
+	// The live database is locked.
+	err = Restore(snapshot, path)
+	if err != ErrLocked {
+		t.Errorf("Restore() over an open database error = %v, want %v", err, ErrLocked)
+	}
+
+	err = Restore(snapshot, restored)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	r, err := Open(restored)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open restored %s: %v", restored, err)
+	}
+	got, err := r.List()
+	r.Close()
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("restored List() = %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestValidateSnapshot(t *testing.T) {
+	path := "testdata/garbage.snapshot"
+	err := os.WriteFile(path, bytes.Repeat([]byte("not a database"), 1024), 0600)
+	if err != nil {
+		t.Fatalf("Cannot write %s: %v", path, err)
+	}
+	defer os.Remove(path)
+
+	err = ValidateSnapshot(path)
+	if err == nil {
+		t.Error("ValidateSnapshot() of garbage succeeded")
+	}
+	err = Restore(path, "testdata/neverdb")
+	if err == nil {
+		t.Error("Restore() of garbage succeeded")
+	}
+	if _, err := os.Stat("testdata/neverdb"); !os.IsNotExist(err) {
+		t.Error("Restore() of garbage created the target file")
+		os.Remove("testdata/neverdb")
+	}
+}
@@ -0,0 +1,36 @@
+package quotes
+
+import (
+	"time"
+)
+
+// Limits of batched writes that suit bulk ingestion, see SetBatch. They
+// are the defaults of Bolt.
+const (
+	DefaultBatchSize  = 1000
+	DefaultBatchDelay = 10 * time.Millisecond
+)
+
+// SetBatch makes concurrent calls of Create and Import share read-write
+// transactions, and so the fsync of the commit: a call waits at most delay
+// for others to join it, and at most size calls are committed together.
+// Each call still returns only once its quotes are committed, with its own
+// results. A size of 0, the default, turns batching off and commits every
+// call on its own, without waiting. SetBatch must be called before d is
+// written to.
+func (d *DB) SetBatch(size int, delay time.Duration) {
+	d.db.MaxBatchSize = size
+	d.db.MaxBatchDelay = delay
+}
+
+// batch is update with the transaction shared with concurrent calls of
+// batch, see SetBatch. If fn fails, the transaction is rolled back and the
+// other calls run again without it; the failed call runs again on its
+// own, which fails it with its own error. fn must therefore not depend on
+// what an earlier run of it did.
+func (d *DB) batch(op string, fn func(tx namespace) error) error {
+	if d.db.MaxBatchSize <= 0 {
+		return d.update(op, fn)
+	}
+	return d.write(op, d.db.Batch, fn)
+}
@@ -0,0 +1,288 @@
+package quotes
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// Default limits of the read cache of a DB, see SetCache.
+const (
+	DefaultCacheSize = 1000
+	DefaultCacheTTL  = time.Minute
+)
+
+// CacheStats are the counters of the read cache of a DB.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Invalidations counts the entries dropped because a write changed
+	// what they hold.
+	Invalidations uint64
+	// Entries is the number of cached results.
+	Entries int
+}
+
+// Modifier is implemented by stores that know when quotes were written,
+// for HTTP Last-Modified headers.
+type Modifier interface {
+	// Modified returns when the quote with the given ID was last
+	// written, or the zero time if that is unknown. It fails with
+	// ErrNotFound if there is no such quote.
+	Modified(id uint64) (time.Time, error)
+	// LastModified returns when any quote was last created, updated or
+	// deleted, or the zero time if that is unknown.
+	LastModified() (time.Time, error)
+}
+
+// cache is a least-recently-used cache of read results. Results about one
+// quote are kept under its ID so that a write drops just them; results
+// about many quotes, like lists, are dropped by every write.
+//
+// A result is only stored if no write committed while it was read, which
+// gen tells: every invalidation bumps it.
+type cache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	lru   *list.List
+	items map[cacheKey]*list.Element
+	gen   uint64
+	stats CacheStats
+	now   func() time.Time
+}
+
+// cacheKey names a cached result of a tenant. id is 0 for results about
+// many quotes.
+type cacheKey struct {
+	tenant string
+	kind   string
+	id     uint64
+	args   string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	value   interface{}
+	expires time.Time
+}
+
+// newCache returns a cache of at most size entries that expire after ttl.
+// A size of 0 caches nothing.
+func newCache(size int, ttl time.Duration) *cache {
+	return &cache{
+		size:  size,
+		ttl:   ttl,
+		lru:   list.New(),
+		items: map[cacheKey]*list.Element{},
+		now:   time.Now,
+	}
+}
+
+// get returns the value under key and the current generation, to be
+// passed to put along with a value read on a miss.
+func (c *cache) get(key cacheKey) (value interface{}, ok bool, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, found := c.items[key]; found {
+		e := el.Value.(*cacheEntry)
+		if c.now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			return e.value, true, c.gen
+		}
+		c.remove(el)
+	}
+	c.stats.Misses++
+	return nil, false, c.gen
+}
+
+// put stores value under key unless a write committed since gen.
+func (c *cache) put(key cacheKey, value interface{}, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size <= 0 || gen != c.gen {
+		return
+	}
+	if el, found := c.items[key]; found {
+		c.remove(el)
+	}
+	c.items[key] = c.lru.PushFront(&cacheEntry{key, value, c.now().Add(c.ttl)})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops the results of tenant about the quotes with the given
+// IDs and all its results about many quotes. Without IDs it drops all
+// results of tenant.
+func (c *cache) invalidate(tenant string, ids map[uint64]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		key := el.Value.(*cacheEntry).key
+		if key.tenant == tenant && (ids == nil || key.id == 0 || ids[key.id]) {
+			c.remove(el)
+			c.stats.Invalidations++
+		}
+		el = next
+	}
+}
+
+func (c *cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).key)
+}
+
+func (c *cache) statistics() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.lru.Len()
+	return s
+}
+
+// SetCache limits the read cache of d to at most size results
+// that are kept for at most ttl. A size of 0 turns caching off.
+func (d *DB) SetCache(size int, ttl time.Duration) {
+	c := d.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size, c.ttl = size, ttl
+	for c.lru.Len() > 0 && c.lru.Len() > size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// cached returns the result under key for the tenant of d, or reads it
+// with load and caches it. Cached values are shared; callers must copy
+// them before handing them out.
+func (d *DB) cached(key cacheKey, load func() (interface{}, error)) (interface{}, error) {
+	key.tenant = d.tenant
+	v, ok, gen := d.cache.get(key)
+	if ok {
+		return v, nil
+	}
+	v, err := load()
+	if err != nil {
+		return nil, err
+	}
+	d.cache.put(key, v, gen)
+	return v, nil
+}
+
+// invalidateOnCommit makes tx drop the cached results about the quotes
+// changed by the events logged to the namespace ns after the change log
+// sequence before.
+func (d *DB) invalidateOnCommit(tx *bolt.Tx, ns namespace, before uint64) error {
+	changes := ns.Bucket([]byte(changeBucket))
+	if changes.Sequence() == before {
+		return nil
+	}
+	ids := map[uint64]bool{}
+	c := changes.Cursor()
+	for k, v := c.Seek(itob(before + 1)); k != nil; k, v = c.Next() {
+		e, err := decodeEvent(v)
+		if err != nil {
+			return errors.Wrapf(err, "cannot decode event %d", btoi(k))
+		}
+		ids[e.Quote.ID] = true
+	}
+	tx.OnCommit(func() { d.cache.invalidate(d.tenant, ids) })
+	return nil
+}
+
+// CacheStats returns the counters of the read cache.
+func (d *DB) CacheStats() CacheStats {
+	return d.cache.statistics()
+}
+
+// Modified implements Modifier: it is the time of the latest revision of
+// the quote.
+func (d *DB) Modified(id uint64) (time.Time, error) {
+	v, err := d.cached(cacheKey{kind: "modified", id: id}, func() (interface{}, error) {
+		var t time.Time
+		err := d.view("Modified", func(tx namespace) error {
+			if tx.Bucket([]byte(quoteBucket)).Get(itob(id)) == nil {
+				return ErrNotFound
+			}
+			revs := tx.Bucket([]byte(historyBucket)).Bucket(itob(id))
+			if revs == nil {
+				return nil
+			}
+			_, v := revs.Cursor().Last()
+			r, err := decodeRevision(v)
+			if err != nil {
+				return errors.Wrapf(err, "cannot decode revision of record %d", id)
+			}
+			t = r.Time
+			return nil
+		})
+		return t, err
+	})
+	if err == ErrNotFound {
+		return time.Time{}, err
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "Modified: DB.View() failed")
+	}
+	return v.(time.Time), nil
+}
+
+// LastModified implements Modifier: it is the time of the latest event in
+// the change log.
+func (d *DB) LastModified() (time.Time, error) {
+	v, err := d.cached(cacheKey{kind: "last-modified"}, func() (interface{}, error) {
+		var t time.Time
+		err := d.view("LastModified", func(tx namespace) error {
+			k, v := tx.Bucket([]byte(changeBucket)).Cursor().Last()
+			if k == nil {
+				return nil
+			}
+			e, err := decodeEvent(v)
+			if err != nil {
+				return errors.Wrapf(err, "cannot decode event %d", btoi(k))
+			}
+			t = e.Time
+			return nil
+		})
+		return t, err
+	})
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "LastModified: DB.View() failed")
+	}
+	return v.(time.Time), nil
+}
+
+// pageCacheArgs identifies the options of a ListPage call in its cache
+// key.
+func pageCacheArgs(opts ListOptions) string {
+	args := []string{strconv.Itoa(opts.Limit), opts.Cursor, opts.Author, opts.AuthorPrefix, opts.Source, strconv.FormatBool(opts.AnyTag)}
+	args = append(args, opts.Tags...)
+	b := []byte{}
+	for _, a := range args {
+		b = strconv.AppendQuote(b, a)
+	}
+	return string(b)
+}
+
+// cloneQuotes returns deep copies of qs.
+func cloneQuotes(qs []*Quote) []*Quote {
+	c := make([]*Quote, len(qs))
+	for i, q := range qs {
+		q := clone(*q)
+		c[i] = &q
+	}
+	return c
+}
@@ -0,0 +1,128 @@
+package quotes
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_Cache(t *testing.T) {
+	path := "testdata/cachedb"
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer func() {
+		d.Close()
+		os.Remove(path)
+	}()
+
+	for _, q := range []*Quote{
+		{Author: "Gopher", Text: "Errors are values."},
+		{Author: "Rob Pike", Text: "Clear is better than clever."},
+	} {
+		err := d.Create(q)
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	read := func() {
+		for _, id := range []uint64{1, 2} {
+			_, err := d.Get(id)
+			if err != nil {
+				t.Fatalf("Get(%d) error = %v", id, err)
+			}
+		}
+		_, err := d.List()
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+	}
+	stats := func(hits, misses, invalidations uint64, entries int) {
+		t.Helper()
+		s := d.CacheStats()
+		if s.Hits != hits || s.Misses != misses || s.Invalidations != invalidations || s.Entries != entries {
+			t.Errorf("CacheStats() = %+v, want %d hits, %d misses, %d invalidations, %d entries", s, hits, misses, invalidations, entries)
+		}
+	}
+
+	read()
+	read()
+	stats(3, 3, 0, 3)
+
+	// Callers get copies.
+	q, _ := d.Get(1)
+	q.Text = "Changed behind the back of the cache."
+	if q, _ := d.Get(1); q.Text != "Errors are values." {
+		t.Errorf("Get() = %q after changing a returned quote", q.Text)
+	}
+
+	// Updating quote 2 keeps quote 1 cached.
+	err = d.Update(&Quote{ID: 2, Author: "Rob Pike", Text: "Clear is better than clever!"})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	stats(5, 3, 2, 1)
+	q, err = d.Get(2)
+	if err != nil || q.Rev != 2 || q.Text != "Clear is better than clever!" {
+		t.Errorf("Get() after Update() = %v, %v", q, err)
+	}
+	list, err := d.List()
+	if err != nil || len(list) != 2 || list[1].Rev != 2 {
+		t.Errorf("List() after Update() = %v, %v", list, err)
+	}
+
+	// Failed writes invalidate nothing.
+	err = d.Update(&Quote{ID: 1, Rev: 7, Author: "Gopher", Text: "Stale."})
+	if err != ErrRevisionMismatch {
+		t.Fatalf("Update() error = %v, want ErrRevisionMismatch", err)
+	}
+	stats(5, 5, 2, 3)
+
+	err = d.Delete(1)
+	if err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := d.Get(1); err != ErrNotFound {
+		t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+
+	d.SetCache(0, time.Minute)
+	d.List()
+	if n := d.CacheStats().Entries; n != 0 {
+		t.Errorf("SetCache(0) kept %d entries", n)
+	}
+}
+
+func TestCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	get := func(key cacheKey) interface{} {
+		v, _, _ := c.get(key)
+		return v
+	}
+	a, b, list := cacheKey{kind: "get", id: 1}, cacheKey{kind: "get", id: 2}, cacheKey{kind: "list"}
+	_, _, gen := c.get(a)
+	c.put(a, "a", gen)
+	c.put(b, "b", gen)
+	get(a)
+	c.put(list, "list", gen)
+	if get(b) != nil || get(a) != "a" || get(list) != "list" {
+		t.Errorf("the least recently used entry was not evicted")
+	}
+
+	// A value read before a write is not cached after it.
+	_, _, gen = c.get(b)
+	c.invalidate("", map[uint64]bool{2: true})
+	c.put(b, "stale b", gen)
+	if get(b) != nil || get(a) != "a" || get(list) != nil {
+		t.Errorf("invalidate() left the wrong entries")
+	}
+
+	now = now.Add(time.Minute)
+	if get(a) != nil {
+		t.Errorf("an expired entry was served")
+	}
+}
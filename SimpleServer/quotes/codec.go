@@ -0,0 +1,362 @@
+package quotes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// Stored quotes start with a marker byte that names the codec of the
+// rest of the value. The markers are taken from 0x80-0xF7, which never
+// starts a gob stream: gob opens with the length of its first message,
+// a byte below 0x80 or a negated byte count from 0xF8 up. Values without
+// a marker are therefore gob records written by older versions.
+//
+// The formats are meant to be read by other languages as well:
+//
+//	0x81  JSON, the object Quote marshals to
+//	0x82  protobuf-style binary, the message Quote of quote.proto
+//
+// The quotes are in the bucket quoteBucket ("shit"), keyed by ID as 8-byte
+// big endian. Deleted quotes are in "trash" under the same keys, and the
+// revisions in "history", in a bucket per ID keyed by revision alike. Values
+// of the trash and the history are prefixed with an 8-byte big endian
+// Unix time in nanoseconds, and revisions may have their actor behind it,
+// see actorMarker.
+const (
+	minMarker = 0x80
+	maxMarker = 0xF7
+)
+
+// Codec encodes quotes for storage.
+type Codec interface {
+	// Name identifies the codec on the command line.
+	Name() string
+	// Marker is the byte that marks values encoded by the codec, from
+	// 0x80 to 0xF7. Once values are stored, it must never change.
+	Marker() byte
+	Marshal(q *Quote) ([]byte, error)
+	Unmarshal(b []byte, q *Quote) error
+}
+
+// The codecs that come with the package.
+var (
+	JSONCodec   Codec = jsonCodec{}
+	BinaryCodec Codec = binaryCodec{}
+)
+
+// DefaultCodec encodes the quotes the Bolt store writes. Records in other
+// formats stay readable, see Reencode to convert them. Set it before
+// opening a database.
+var DefaultCodec = BinaryCodec
+
+var codecs = map[byte]Codec{}
+
+func init() {
+	RegisterCodec(JSONCodec)
+	RegisterCodec(BinaryCodec)
+}
+
+// RegisterCodec makes c available for reading and by name. It panics if
+// the marker of c is out of range or taken.
+func RegisterCodec(c Codec) {
+	m := c.Marker()
+	if m < minMarker || m > maxMarker {
+		panic(fmt.Sprintf("quotes: codec %s has marker %#x outside %#x-%#x", c.Name(), m, minMarker, maxMarker))
+	}
+	if other, ok := codecs[m]; ok {
+		panic(fmt.Sprintf("quotes: codecs %s and %s share marker %#x", other.Name(), c.Name(), m))
+	}
+	codecs[m] = c
+}
+
+// CodecByName returns the registered codec called name.
+func CodecByName(name string) (Codec, error) {
+	names := []string{}
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+		names = append(names, c.Name())
+	}
+	sort.Strings(names)
+	return nil, errors.Errorf("unknown codec %q, use one of %v", name, names)
+}
+
+// Encode returns q encoded by c behind the marker of c.
+func Encode(c Codec, q *Quote) ([]byte, error) {
+	v, err := c.Marshal(q)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s codec", c.Name())
+	}
+	return append([]byte{c.Marker()}, v...), nil
+}
+
+// Decode decodes a value written by Encode, or a gob record without a
+// marker, into q.
+func Decode(b []byte, q *Quote) error {
+	*q = Quote{}
+	if len(b) == 0 || b[0] < minMarker || b[0] > maxMarker {
+		return gob.NewDecoder(bytes.NewReader(b)).Decode(q)
+	}
+	c, ok := codecs[b[0]]
+	if !ok {
+		return errors.Errorf("unknown codec marker %#x", b[0])
+	}
+	return errors.Wrapf(c.Unmarshal(b[1:], q), "%s codec", c.Name())
+}
+
+// codecOf returns the name of the codec of the stored value b.
+func codecOf(b []byte) string {
+	if len(b) == 0 || b[0] < minMarker || b[0] > maxMarker {
+		return "gob"
+	}
+	if c, ok := codecs[b[0]]; ok {
+		return c.Name()
+	}
+	return fmt.Sprintf("%#x", b[0])
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+func (jsonCodec) Marker() byte { return 0x81 }
+
+func (jsonCodec) Marshal(q *Quote) ([]byte, error) {
+	return json.Marshal(q)
+}
+
+func (jsonCodec) Unmarshal(b []byte, q *Quote) error {
+	return json.Unmarshal(b, q)
+}
+
+// binaryCodec writes the protobuf wire format of quote.proto: every field
+// is a key, (number << 3) | wire type, followed by a varint or a length
+// and bytes. Empty fields are left out and unknown fields are skipped, so
+// fields can be added without a new marker.
+type binaryCodec struct{}
+
+// Field numbers of quote.proto.
+const (
+	fieldID     = 1
+	fieldRev    = 2
+	fieldAuthor = 3
+	fieldText   = 4
+	fieldSource = 5
+	fieldTags   = 6
+)
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func (binaryCodec) Name() string { return "binary" }
+func (binaryCodec) Marker() byte { return 0x82 }
+
+func (binaryCodec) Marshal(q *Quote) ([]byte, error) {
+	b := make([]byte, 0, 32+len(q.Author)+len(q.Text)+len(q.Source))
+	var tmp [binary.MaxVarintLen64]byte
+	varint := func(v uint64) {
+		n := binary.PutUvarint(tmp[:], v)
+		b = append(b, tmp[:n]...)
+	}
+	uintField := func(field int, v uint64) {
+		if v != 0 {
+			varint(uint64(field<<3 | wireVarint))
+			varint(v)
+		}
+	}
+	stringField := func(field int, s string) {
+		varint(uint64(field<<3 | wireBytes))
+		varint(uint64(len(s)))
+		b = append(b, s...)
+	}
+
+	uintField(fieldID, q.ID)
+	uintField(fieldRev, q.Rev)
+	for _, f := range []struct {
+		field int
+		s     string
+	}{{fieldAuthor, q.Author}, {fieldText, q.Text}, {fieldSource, q.Source}} {
+		if f.s != "" {
+			stringField(f.field, f.s)
+		}
+	}
+	for _, t := range q.Tags {
+		stringField(fieldTags, t)
+	}
+	return b, nil
+}
+
+func (binaryCodec) Unmarshal(b []byte, q *Quote) error {
+	varint := func() (uint64, error) {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, errors.New("malformed varint")
+		}
+		b = b[n:]
+		return v, nil
+	}
+	for len(b) > 0 {
+		key, err := varint()
+		if err != nil {
+			return err
+		}
+		field, wire := int(key>>3), int(key&7)
+		switch wire {
+		case wireVarint:
+			v, err := varint()
+			if err != nil {
+				return err
+			}
+			switch field {
+			case fieldID:
+				q.ID = v
+			case fieldRev:
+				q.Rev = v
+			}
+		case wireBytes:
+			n, err := varint()
+			if err != nil {
+				return err
+			}
+			if n > uint64(len(b)) {
+				return errors.Errorf("field %d is truncated", field)
+			}
+			s := string(b[:n])
+			b = b[n:]
+			switch field {
+			case fieldAuthor:
+				q.Author = s
+			case fieldText:
+				q.Text = s
+			case fieldSource:
+				q.Source = s
+			case fieldTags:
+				q.Tags = append(q.Tags, s)
+			}
+		case wireFixed64, wireFixed32:
+			n := 8
+			if wire == wireFixed32 {
+				n = 4
+			}
+			if len(b) < n {
+				return errors.Errorf("field %d is truncated", field)
+			}
+			b = b[n:]
+		default:
+			return errors.Errorf("field %d has unsupported wire type %d", field, wire)
+		}
+	}
+	return nil
+}
+
+// ReencodeReport counts the stored quotes of all tenants by bucket and
+// codec before a re-encoding, and how many were rewritten.
+type ReencodeReport struct {
+	Codec     string                    `json:"codec"`
+	Found     map[string]map[string]int `json:"found"`
+	Rewritten int                       `json:"rewritten"`
+}
+
+// Reencode opens the database at path and rewrites every stored quote of
+// every tenant that is not encoded by c yet, in one transaction. With dryRun set it
+// only counts them. It fails with ErrLocked while a server has the
+// database open.
+func Reencode(path string, c Codec, dryRun bool) (*ReencodeReport, error) {
+	d, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	report := &ReencodeReport{Codec: c.Name(), Found: map[string]map[string]int{}}
+	for _, name := range []string{quoteBucket, trashBucket, historyBucket} {
+		report.Found[name] = map[string]int{}
+	}
+	re := func(b *bolt.Bucket, name string, stamped bool) error {
+		n, err := reencodeBucket(b, stamped, c, report.Found[name])
+		report.Rewritten += n
+		return errors.Wrapf(err, "%s bucket", name)
+	}
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		err := namespaces(tx, func(_ string, tx namespace) error {
+			err := re(tx.Bucket([]byte(quoteBucket)), quoteBucket, false)
+			if err != nil {
+				return err
+			}
+			err = re(tx.Bucket([]byte(trashBucket)), trashBucket, true)
+			if err != nil {
+				return err
+			}
+			// The history holds a bucket of revisions per quote.
+			history := tx.Bucket([]byte(historyBucket))
+			return history.ForEach(func(k, _ []byte) error {
+				return re(history.Bucket(k), historyBucket, true)
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return nil, errors.Wrap(err, "Reencode")
+	}
+	return report, nil
+}
+
+// reencodeBucket rewrites the quotes in b that are not encoded by c, and
+// returns how many there were. Values of stamped buckets start with a
+// stamp that is kept, see stampLen. found counts the values by codec.
+func reencodeBucket(b *bolt.Bucket, stamped bool, c Codec, found map[string]int) (int, error) {
+	// Putting while iterating is not allowed, so collect first.
+	rewrites := map[string][]byte{}
+	err := b.ForEach(func(k, v []byte) error {
+		prefix := 0
+		if stamped {
+			if len(v) < 8 {
+				return errors.Errorf("record %x is too short", k)
+			}
+			prefix = stampLen(v)
+		}
+		found[codecOf(v[prefix:])]++
+		if len(v) > prefix && v[prefix] == c.Marker() {
+			return nil
+		}
+		q := &Quote{}
+		err := Decode(v[prefix:], q)
+		if err != nil {
+			return errors.Wrapf(err, "cannot decode record %x", k)
+		}
+		encoded, err := Encode(c, q)
+		if err != nil {
+			return err
+		}
+		rewrites[string(k)] = append(append([]byte{}, v[:prefix]...), encoded...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range rewrites {
+		err := b.Put([]byte(k), v)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(rewrites), nil
+}
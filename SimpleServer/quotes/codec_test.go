@@ -0,0 +1,170 @@
+package quotes
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func gobEncode(t *testing.T, q *Quote) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(q)
+	if err != nil {
+		t.Fatalf("gob encoding failed: %v", err)
+	}
+	return b.Bytes()
+}
+
+func TestCodecs(t *testing.T) {
+	quotes := []Quote{
+		{ID: 1, Rev: 3, Author: "Gopher", Text: "Errors are values.", Source: "Go Proverbs", Tags: []string{"errors", "go"}},
+		{Author: "Rob Pike", Text: "Clear is better than clever.\nAlways."},
+		{ID: 1 << 40, Author: "Ünïcödé", Text: string(make([]byte, 300))},
+	}
+	for _, c := range []Codec{JSONCodec, BinaryCodec} {
+		for _, q := range quotes {
+			v, err := Encode(c, &q)
+			if err != nil {
+				t.Fatalf("Encode(%s) error = %v", c.Name(), err)
+			}
+			if v[0] != c.Marker() || codecOf(v) != c.Name() {
+				t.Errorf("Encode(%s) = %x, not marked", c.Name(), v)
+			}
+			restored := Quote{Source: "left over"}
+			err = Decode(v, &restored)
+			if err != nil || !reflect.DeepEqual(restored, q) {
+				t.Errorf("Decode(Encode(%s)) = %#v, %v, want %#v", c.Name(), restored, err, q)
+			}
+		}
+	}
+
+	// Gob records of older versions are read as they are.
+	legacy := gobEncode(t, &quotes[0])
+	restored := Quote{}
+	if err := Decode(legacy, &restored); err != nil || !reflect.DeepEqual(restored, quotes[0]) || codecOf(legacy) != "gob" {
+		t.Errorf("Decode(gob) = %#v, %v", restored, err)
+	}
+
+	// The binary format is the protobuf wire format: this is the message
+	// {id: 7, author: "A", tags: ["x"]} with the unknown fields 9 (varint),
+	// 10 (fixed64), 11 (bytes) and 12 (fixed32) mixed in.
+	v := []byte{0x82,
+		0x08, 0x07,
+		0x48, 0x96, 0x01,
+		0x1a, 0x01, 'A',
+		0x51, 1, 2, 3, 4, 5, 6, 7, 8,
+		0x5a, 0x02, 'z', 'z',
+		0x65, 1, 2, 3, 4,
+		0x32, 0x01, 'x',
+	}
+	restored = Quote{}
+	err := Decode(v, &restored)
+	if want := (Quote{ID: 7, Author: "A", Tags: []string{"x"}}); err != nil || !reflect.DeepEqual(restored, want) {
+		t.Errorf("Decode(protobuf) = %#v, %v, want %#v", restored, err, want)
+	}
+
+	for _, v := range [][]byte{
+		{0x82, 0x1a, 0x05, 'A'},
+		{0x82, 0x08},
+		{0x82, 0x0b},
+		{0xf0, 0x01},
+	} {
+		if err := Decode(v, &Quote{}); err == nil {
+			t.Errorf("Decode(%x) did not fail", v)
+		}
+	}
+
+	if c, err := CodecByName("json"); err != nil || c != JSONCodec {
+		t.Errorf("CodecByName(json) = %v, %v", c, err)
+	}
+	if _, err := CodecByName("gob"); err == nil {
+		t.Errorf("CodecByName(gob) did not fail")
+	}
+}
+
+func TestReencode(t *testing.T) {
+	path := "testdata/reencodedb"
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer os.Remove(path)
+
+	// Quote 2 is written with an API key, whose ID is kept in its revision.
+	withKey := WithContext(d, WithActor(context.Background(), 7))
+	for i, q := range []*Quote{
+		{Author: "Gopher", Text: "Errors are values."},
+		{Author: "Rob Pike", Text: "Clear is better than clever."},
+		{Author: "Gopher", Text: "Don't panic."},
+	} {
+		store := QuoteStore(d)
+		if i == 1 {
+			store = withKey
+		}
+		err := store.Create(q)
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	err = d.Delete(3)
+	if err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	// Turn quote 1 into a gob record of an older version.
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(quoteBucket)).Put(itob(1), gobEncode(t, &Quote{ID: 1, Rev: 1, Author: "Gopher", Text: "Errors are values."}))
+	})
+	if err != nil {
+		t.Fatalf("cannot write gob record: %v", err)
+	}
+	d.Close()
+
+	report, err := Reencode(path, JSONCodec, true)
+	if err != nil || report.Rewritten != 6 || report.Found[quoteBucket]["gob"] != 1 || report.Found[quoteBucket]["binary"] != 1 || report.Found[historyBucket]["binary"] != 3 {
+		t.Errorf("Reencode() dry run = %+v, %v", report, err)
+	}
+	report, err = Reencode(path, JSONCodec, false)
+	if err != nil || report.Rewritten != 6 {
+		t.Errorf("Reencode() = %+v, %v", report, err)
+	}
+	report, err = Reencode(path, JSONCodec, true)
+	if err != nil || report.Rewritten != 0 || report.Found[trashBucket]["json"] != 1 {
+		t.Errorf("Reencode() after Reencode() = %+v, %v", report, err)
+	}
+
+	d, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer d.Close()
+	q, err := d.Get(1)
+	if err != nil || q.Text != "Errors are values." {
+		t.Errorf("Get() after Reencode() = %v, %v", q, err)
+	}
+	revs, err := d.History(2)
+	if err != nil || len(revs) != 1 || revs[0].Time.IsZero() || revs[0].Author != "Rob Pike" || revs[0].Actor != 7 {
+		t.Errorf("History() after Reencode() = %v, %v", revs, err)
+	}
+	// Undeleting with another key keeps the revision of the first.
+	err = d.Delete(2)
+	if err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	_, err = WithContext(d, WithActor(context.Background(), 8)).(Trasher).Undelete(2)
+	if err != nil {
+		t.Fatalf("Undelete() error = %v", err)
+	}
+	if r, err := d.Revision(2, 1); err != nil || r.Actor != 7 {
+		t.Errorf("Revision() after Undelete() = %+v, %v, want actor 7", r, err)
+	}
+	trash, err := d.Trash()
+	if err != nil || len(trash) != 1 || trash[0].ID != 3 || trash[0].DeletedAt.IsZero() {
+		t.Errorf("Trash() after Reencode() = %v, %v", trash, err)
+	}
+}
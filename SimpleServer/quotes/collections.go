@@ -0,0 +1,477 @@
+package quotes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// collectionBucket holds a nested bucket per collection, keyed by its ID.
+// The nested bucket keeps the collection record under collectionInfoKey
+// and its quote IDs in order in the collectionItemsBucket, keyed by
+// position.
+const collectionBucket = "collections"
+
+// membershipBucket indexes collections by the quotes they contain: its
+// keys are a quote ID followed by a collection ID.
+const membershipBucket = "memberships"
+
+var (
+	collectionInfoKey     = []byte("info")
+	collectionItemsBucket = []byte("quotes")
+)
+
+// Length limits of collection fields, in characters.
+const (
+	MaxCollectionNameLen        = 100
+	MaxCollectionDescriptionLen = 1000
+)
+
+// Collection is a named, ordered list of quotes. A quote is in a
+// collection at most once.
+type Collection struct {
+	ID          uint64    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	QuoteIDs    []uint64  `json:"quote_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Collector is implemented by stores that keep collections. Invalid names
+// and references to quotes that do not exist fail with a
+// *ValidationError; missing collections with ErrNotFound. Deleting a quote
+// takes it out of every collection, and restoring it does not put it back.
+type Collector interface {
+	// CreateCollection assigns c a new ID and stores it, with the quotes
+	// in c.QuoteIDs.
+	CreateCollection(c *Collection) error
+	// Collection returns the collection with the given ID.
+	Collection(id uint64) (*Collection, error)
+	// Collections returns all collections, ordered by ID.
+	Collections() ([]*Collection, error)
+	// UpdateCollection changes the name and description of the
+	// collection with ID c.ID and fills in the rest of c.
+	UpdateCollection(c *Collection) error
+	// DeleteCollection deletes a collection. Its quotes are kept.
+	DeleteCollection(id uint64) error
+	// AddToCollection inserts a quote into a collection at position pos,
+	// counted from 0, or appends it if pos is negative or past the end.
+	// It fails with ErrExists if the quote is in the collection already.
+	AddToCollection(id, quoteID uint64, pos int) (*Collection, error)
+	// RemoveFromCollection takes a quote out of a collection, or fails
+	// with ErrNotFound if it is not in there.
+	RemoveFromCollection(id, quoteID uint64) (*Collection, error)
+	// ReorderCollection puts the quotes of a collection into the order
+	// of quoteIDs, which must list each of them once.
+	ReorderCollection(id uint64, quoteIDs []uint64) (*Collection, error)
+	// CollectionsOf returns the collections a quote is in, ordered by ID.
+	CollectionsOf(quoteID uint64) ([]*Collection, error)
+}
+
+// collectionInfo is the stored record of a collection; the quote IDs are
+// kept apart.
+type collectionInfo struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// prepareCollection normalizes the name and description of c like the
+// fields of a quote and validates them.
+func prepareCollection(c *Collection) error {
+	c.Name = strings.Join(strings.Fields(canonicalize(c.Name)), " ")
+	c.Description = strings.TrimSpace(canonicalize(c.Description))
+	e := &ValidationError{}
+	if n := utf8.RuneCountInString(c.Name); n == 0 {
+		e.add("name", "required", "must not be empty")
+	} else if n > MaxCollectionNameLen {
+		e.add("name", "too_long", fmt.Sprintf("must be at most %d characters long, not %d", MaxCollectionNameLen, n))
+	}
+	if n := utf8.RuneCountInString(c.Description); n > MaxCollectionDescriptionLen {
+		e.add("description", "too_long", fmt.Sprintf("must be at most %d characters long, not %d", MaxCollectionDescriptionLen, n))
+	}
+	for _, f := range []struct{ name, value string }{{"name", c.Name}, {"description", c.Description}} {
+		if !utf8.ValidString(f.value) {
+			e.add(f.name, "invalid", "must be valid UTF-8")
+		} else if strings.IndexFunc(f.value, func(r rune) bool { return isForbidden(r, f.name == "description") }) >= 0 {
+			e.add(f.name, "invalid", "must not contain control characters")
+		}
+	}
+	if len(e.Fields) > 0 {
+		return e
+	}
+	return nil
+}
+
+// CreateCollection implements Collector. Names are unique, ignoring case.
+func (d *DB) CreateCollection(c *Collection) error {
+	err := prepareCollection(c)
+	if err != nil {
+		return err
+	}
+	ids := c.QuoteIDs
+	err = d.update("CreateCollection", func(tx namespace) error {
+		err := checkCollectionName(tx, c)
+		if err != nil {
+			return err
+		}
+		err = checkQuota(tx, true)
+		if err != nil {
+			return err
+		}
+		err = checkQuoteIDs(tx, ids)
+		if err != nil {
+			return err
+		}
+		collections := tx.Bucket([]byte(collectionBucket))
+		id, err := collections.NextSequence()
+		if err != nil {
+			return fmt.Errorf("next sequence: %s", err)
+		}
+		b, err := collections.CreateBucket(itob(id))
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		*c = Collection{ID: id, Name: c.Name, Description: c.Description, CreatedAt: now, UpdatedAt: now}
+		err = putCollectionInfo(b, c)
+		if err != nil {
+			return err
+		}
+		return setCollectionItems(tx, b, c, ids)
+	})
+	return err
+}
+
+// Collection implements Collector.
+func (d *DB) Collection(id uint64) (*Collection, error) {
+	var c *Collection
+	err := d.view("Collection", func(tx namespace) error {
+		var err error
+		c, _, err = getCollection(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Collections implements Collector.
+func (d *DB) Collections() ([]*Collection, error) {
+	collections := []*Collection{}
+	err := d.view("Collections", func(tx namespace) error {
+		return tx.Bucket([]byte(collectionBucket)).ForEach(func(k, v []byte) error {
+			c, _, err := getCollection(tx, btoi(k))
+			if err != nil {
+				return err
+			}
+			collections = append(collections, c)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+// UpdateCollection implements Collector.
+func (d *DB) UpdateCollection(c *Collection) error {
+	err := prepareCollection(c)
+	if err != nil {
+		return err
+	}
+	err = d.update("UpdateCollection", func(tx namespace) error {
+		old, b, err := getCollection(tx, c.ID)
+		if err != nil {
+			return err
+		}
+		err = checkCollectionName(tx, c)
+		if err != nil {
+			return err
+		}
+		old.Name, old.Description, old.UpdatedAt = c.Name, c.Description, time.Now().UTC()
+		*c = *old
+		return putCollectionInfo(b, c)
+	})
+	return err
+}
+
+// DeleteCollection implements Collector.
+func (d *DB) DeleteCollection(id uint64) error {
+	err := d.update("DeleteCollection", func(tx namespace) error {
+		c, _, err := getCollection(tx, id)
+		if err != nil {
+			return err
+		}
+		memberships := tx.Bucket([]byte(membershipBucket))
+		for _, quoteID := range c.QuoteIDs {
+			err := memberships.Delete(append(itob(quoteID), itob(id)...))
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Bucket([]byte(collectionBucket)).DeleteBucket(itob(id))
+	})
+	return err
+}
+
+// AddToCollection implements Collector.
+func (d *DB) AddToCollection(id, quoteID uint64, pos int) (*Collection, error) {
+	return d.changeCollection("AddToCollection", id, func(tx namespace, ids []uint64) ([]uint64, error) {
+		for _, other := range ids {
+			if other == quoteID {
+				return nil, ErrExists
+			}
+		}
+		err := checkQuoteIDs(tx, []uint64{quoteID})
+		if err != nil {
+			return nil, err
+		}
+		if pos < 0 || pos > len(ids) {
+			pos = len(ids)
+		}
+		ids = append(ids, 0)
+		copy(ids[pos+1:], ids[pos:])
+		ids[pos] = quoteID
+		return ids, nil
+	})
+}
+
+// RemoveFromCollection implements Collector.
+func (d *DB) RemoveFromCollection(id, quoteID uint64) (*Collection, error) {
+	return d.changeCollection("RemoveFromCollection", id, func(tx namespace, ids []uint64) ([]uint64, error) {
+		for i, other := range ids {
+			if other == quoteID {
+				return append(ids[:i], ids[i+1:]...), nil
+			}
+		}
+		return nil, ErrNotFound
+	})
+}
+
+// ReorderCollection implements Collector.
+func (d *DB) ReorderCollection(id uint64, quoteIDs []uint64) (*Collection, error) {
+	return d.changeCollection("ReorderCollection", id, func(tx namespace, ids []uint64) ([]uint64, error) {
+		missing := map[uint64]bool{}
+		for _, quoteID := range ids {
+			missing[quoteID] = true
+		}
+		for _, quoteID := range quoteIDs {
+			if !missing[quoteID] {
+				return nil, collectionItemsError(fmt.Sprintf("quote %d is not in the collection or listed twice", quoteID))
+			}
+			delete(missing, quoteID)
+		}
+		if len(missing) > 0 {
+			return nil, collectionItemsError(fmt.Sprintf("must list all %d quotes of the collection", len(ids)))
+		}
+		return quoteIDs, nil
+	})
+}
+
+// changeCollection replaces the quote IDs of the collection with the given
+// ID by what change makes of them.
+func (d *DB) changeCollection(op string, id uint64, change func(tx namespace, ids []uint64) ([]uint64, error)) (*Collection, error) {
+	var c *Collection
+	err := d.update(op, func(tx namespace) error {
+		var b *bolt.Bucket
+		var err error
+		c, b, err = getCollection(tx, id)
+		if err != nil {
+			return err
+		}
+		ids, err := change(tx, append([]uint64(nil), c.QuoteIDs...))
+		if err != nil {
+			return err
+		}
+		c.UpdatedAt = time.Now().UTC()
+		err = putCollectionInfo(b, c)
+		if err != nil {
+			return err
+		}
+		return setCollectionItems(tx, b, c, ids)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CollectionsOf implements Collector.
+func (d *DB) CollectionsOf(quoteID uint64) ([]*Collection, error) {
+	collections := []*Collection{}
+	err := d.view("CollectionsOf", func(tx namespace) error {
+		prefix := itob(quoteID)
+		c := tx.Bucket([]byte(membershipBucket)).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && len(k) == 16 && btoi(k[:8]) == quoteID; k, _ = c.Next() {
+			collection, _, err := getCollection(tx, btoi(k[8:]))
+			if err != nil {
+				return err
+			}
+			collections = append(collections, collection)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+// getCollection reads the collection with the given ID within tx and
+// returns it with its bucket, or fails with ErrNotFound.
+func getCollection(tx namespace, id uint64) (*Collection, *bolt.Bucket, error) {
+	b := tx.Bucket([]byte(collectionBucket)).Bucket(itob(id))
+	if b == nil {
+		return nil, nil, ErrNotFound
+	}
+	var info collectionInfo
+	err := json.Unmarshal(b.Get(collectionInfoKey), &info)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot decode collection %d", id)
+	}
+	c := &Collection{
+		ID:          id,
+		Name:        info.Name,
+		Description: info.Description,
+		QuoteIDs:    []uint64{},
+		CreatedAt:   info.CreatedAt,
+		UpdatedAt:   info.UpdatedAt,
+	}
+	err = b.Bucket(collectionItemsBucket).ForEach(func(k, v []byte) error {
+		c.QuoteIDs = append(c.QuoteIDs, btoi(v))
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, b, nil
+}
+
+func putCollectionInfo(b *bolt.Bucket, c *Collection) error {
+	v, err := json.Marshal(collectionInfo{c.Name, c.Description, c.CreatedAt, c.UpdatedAt})
+	if err != nil {
+		return err
+	}
+	return b.Put(collectionInfoKey, v)
+}
+
+// setCollectionItems replaces the quotes of collection c, whose bucket is
+// b, with ids and updates the membership index and c.QuoteIDs.
+func setCollectionItems(tx namespace, b *bolt.Bucket, c *Collection, ids []uint64) error {
+	memberships := tx.Bucket([]byte(membershipBucket))
+	for _, quoteID := range c.QuoteIDs {
+		err := memberships.Delete(append(itob(quoteID), itob(c.ID)...))
+		if err != nil {
+			return err
+		}
+	}
+	if b.Bucket(collectionItemsBucket) != nil {
+		err := b.DeleteBucket(collectionItemsBucket)
+		if err != nil {
+			return err
+		}
+	}
+	items, err := b.CreateBucket(collectionItemsBucket)
+	if err != nil {
+		return err
+	}
+	for i, quoteID := range ids {
+		err := items.Put(itob(uint64(i)), itob(quoteID))
+		if err != nil {
+			return err
+		}
+		err = memberships.Put(append(itob(quoteID), itob(c.ID)...), nil)
+		if err != nil {
+			return err
+		}
+	}
+	c.QuoteIDs = append([]uint64{}, ids...)
+	return nil
+}
+
+// removeFromCollections takes the quote with the given ID out of every
+// collection within tx, when it is deleted.
+func removeFromCollections(tx namespace, quoteID uint64) error {
+	prefix := itob(quoteID)
+	ids := []uint64{}
+	cur := tx.Bucket([]byte(membershipBucket)).Cursor()
+	for k, _ := cur.Seek(prefix); k != nil && len(k) == 16 && btoi(k[:8]) == quoteID; k, _ = cur.Next() {
+		ids = append(ids, btoi(k[8:]))
+	}
+	for _, id := range ids {
+		c, b, err := getCollection(tx, id)
+		if err != nil {
+			return err
+		}
+		kept := []uint64{}
+		for _, other := range c.QuoteIDs {
+			if other != quoteID {
+				kept = append(kept, other)
+			}
+		}
+		c.UpdatedAt = time.Now().UTC()
+		err = putCollectionInfo(b, c)
+		if err != nil {
+			return err
+		}
+		err = setCollectionItems(tx, b, c, kept)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCollectionName fails with a *ValidationError if another collection
+// than c has its name.
+func checkCollectionName(tx namespace, c *Collection) error {
+	return tx.Bucket([]byte(collectionBucket)).ForEach(func(k, v []byte) error {
+		id := btoi(k)
+		if id == c.ID {
+			return nil
+		}
+		other, _, err := getCollection(tx, id)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(other.Name, c.Name) {
+			e := &ValidationError{}
+			e.add("name", "duplicate", fmt.Sprintf("is the name of collection %d", id))
+			return e
+		}
+		return nil
+	})
+}
+
+// checkQuoteIDs fails with a *ValidationError unless the quotes exist and
+// are listed once.
+func checkQuoteIDs(tx namespace, ids []uint64) error {
+	quotes := tx.Bucket([]byte(quoteBucket))
+	seen := map[uint64]bool{}
+	for _, id := range ids {
+		if quotes.Get(itob(id)) == nil {
+			return collectionItemsError(fmt.Sprintf("quote %d does not exist", id))
+		}
+		if seen[id] {
+			return collectionItemsError(fmt.Sprintf("quote %d is listed twice", id))
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+func collectionItemsError(message string) *ValidationError {
+	e := &ValidationError{}
+	e.add("quote_ids", "invalid", message)
+	return e
+}
@@ -0,0 +1,130 @@
+package quotes
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDB_Collections(t *testing.T) {
+	path := "testdata/collectionsdb"
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer func() {
+		d.Close()
+		os.Remove(path)
+	}()
+
+	for _, q := range []*Quote{
+		{Author: "Gopher", Text: "Errors are values."},
+		{Author: "Rob Pike", Text: "Clear is better than clever."},
+		{Author: "Gopher", Text: "Don't panic."},
+	} {
+		err := d.Create(q)
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	proverbs := &Collection{Name: "  Go   proverbs ", QuoteIDs: []uint64{3, 1}}
+	err = d.CreateCollection(proverbs)
+	if err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	if proverbs.ID != 1 || proverbs.Name != "Go proverbs" || !reflect.DeepEqual(proverbs.QuoteIDs, []uint64{3, 1}) {
+		t.Errorf("CreateCollection() = %+v", proverbs)
+	}
+	monday := &Collection{Name: "Monday motivation", Description: "For the start of the week."}
+	err = d.CreateCollection(monday)
+	if err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+
+	for _, c := range []*Collection{
+		{Name: ""},
+		{Name: "go PROVERBS"},
+		{Name: "Missing quotes", QuoteIDs: []uint64{1, 42}},
+		{Name: "Repeated quotes", QuoteIDs: []uint64{1, 1}},
+	} {
+		if err := d.CreateCollection(c); !isValidationError(err) {
+			t.Errorf("CreateCollection(%q) did not fail validation", c.Name)
+		}
+	}
+
+	ids := func(c *Collection, err error) []uint64 {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("error = %v", err)
+		}
+		return c.QuoteIDs
+	}
+	if got := ids(d.AddToCollection(1, 2, 1)); !reflect.DeepEqual(got, []uint64{3, 2, 1}) {
+		t.Errorf("AddToCollection() at 1 = %v", got)
+	}
+	if got := ids(d.AddToCollection(2, 2, -1)); !reflect.DeepEqual(got, []uint64{2}) {
+		t.Errorf("AddToCollection() at the end = %v", got)
+	}
+	if _, err := d.AddToCollection(1, 2, 0); err != ErrExists {
+		t.Errorf("AddToCollection() twice error = %v, want ErrExists", err)
+	}
+	if got := ids(d.ReorderCollection(1, []uint64{1, 2, 3})); !reflect.DeepEqual(got, []uint64{1, 2, 3}) {
+		t.Errorf("ReorderCollection() = %v", got)
+	}
+	if _, err := d.ReorderCollection(1, []uint64{1, 2}); !isValidationError(err) {
+		t.Errorf("ReorderCollection() without quote 3 did not fail validation")
+	}
+	if got := ids(d.RemoveFromCollection(1, 1)); !reflect.DeepEqual(got, []uint64{2, 3}) {
+		t.Errorf("RemoveFromCollection() = %v", got)
+	}
+	if _, err := d.RemoveFromCollection(1, 1); err != ErrNotFound {
+		t.Errorf("RemoveFromCollection() twice error = %v, want ErrNotFound", err)
+	}
+
+	of, err := d.CollectionsOf(2)
+	if err != nil || len(of) != 2 || of[0].ID != 1 || of[1].ID != 2 {
+		t.Errorf("CollectionsOf(2) = %v, %v", of, err)
+	}
+
+	// Deleting a quote takes it out of its collections.
+	err = d.Delete(2)
+	if err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	all, err := d.Collections()
+	if err != nil || len(all) != 2 || !reflect.DeepEqual(all[0].QuoteIDs, []uint64{3}) || len(all[1].QuoteIDs) != 0 {
+		t.Errorf("Collections() after Delete() = %v, %v", all, err)
+	}
+	if of, _ := d.CollectionsOf(2); len(of) != 0 {
+		t.Errorf("CollectionsOf() a deleted quote = %v", of)
+	}
+
+	renamed := &Collection{ID: 2, Name: "Tuesday motivation"}
+	err = d.UpdateCollection(renamed)
+	if err != nil || renamed.Description != "" || renamed.CreatedAt.IsZero() {
+		t.Errorf("UpdateCollection() = %+v, %v", renamed, err)
+	}
+	if err := d.UpdateCollection(&Collection{ID: 2, Name: "Go proverbs"}); err == nil {
+		t.Errorf("UpdateCollection() to a taken name succeeded")
+	}
+
+	err = d.DeleteCollection(1)
+	if err != nil {
+		t.Fatalf("DeleteCollection() error = %v", err)
+	}
+	if _, err := d.Collection(1); err != ErrNotFound {
+		t.Errorf("Collection() after DeleteCollection() error = %v, want ErrNotFound", err)
+	}
+	if of, _ := d.CollectionsOf(3); len(of) != 0 {
+		t.Errorf("CollectionsOf() after DeleteCollection() = %v", of)
+	}
+	if _, err := d.Get(3); err != nil {
+		t.Errorf("Get() after DeleteCollection() error = %v", err)
+	}
+}
+
+func isValidationError(err error) bool {
+	_, ok := err.(*ValidationError)
+	return ok
+}
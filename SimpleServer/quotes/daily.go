@@ -0,0 +1,206 @@
+package quotes
+
+import (
+	"database/sql"
+	"hash/fnv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pinBucket keeps the quotes of the day that PickDaily chose, keyed by the
+// day, a zero byte and the key of the pick, so that the pins of past days
+// come first.
+const pinBucket = "pins"
+
+// maxPins bounds the number of quotes of the day a store keeps pinned, one
+// per day and key.
+const maxPins = 10000
+
+// Pinner is implemented by stores that keep the quotes PickDaily chooses,
+// so that a quote of the day stays the same for everybody using the store
+// all day, whatever is created or deleted meanwhile.
+type Pinner interface {
+	// Pinned returns the ID of the quote pinned under day and key, or 0.
+	Pinned(day, key string) (uint64, error)
+	// Pin pins the quote with the given ID under day and key. It drops the
+	// pins of days that are over everywhere on earth, and pins nothing if
+	// maxPins are left.
+	Pin(day, key string, id uint64) error
+}
+
+// PickDaily returns the quote of the day YYYY-MM-DD of s among those
+// matching filter; key tells apart the filters. It is the quote pinned for
+// day and key if s is a Pinner and the quote still exists. Otherwise it is
+// the matching quote whose ID hashes highest with day and key, which only
+// depends on the IDs of the candidates, not on their order or count, and
+// is pinned if day is today somewhere. It fails with ErrNotFound if no
+// quote matches.
+func PickDaily(s QuoteStore, day, key string, filter PickFilter) (*Quote, error) {
+	p, pins := s.(Pinner)
+	pins = pins && currentDay(day)
+	if pins {
+		id, err := p.Pinned(day, key)
+		if err != nil {
+			return nil, err
+		}
+		if id != 0 {
+			q, err := s.Get(id)
+			if err != ErrNotFound {
+				return q, err
+			}
+			// The quote of the day was deleted, pick another.
+		}
+	}
+	matches, err := matching(s, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, ErrNotFound
+	}
+	best, bestScore := matches[0], dailyScore(day, key, matches[0].ID)
+	for _, q := range matches[1:] {
+		if score := dailyScore(day, key, q.ID); score > bestScore {
+			best, bestScore = q, score
+		}
+	}
+	if pins {
+		err = p.Pin(day, key, best.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return best, nil
+}
+
+// dailyScore returns the score of the quote with the given ID for the
+// quote of day and key.
+func dailyScore(day, key string, id uint64) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(day + "\x00" + key + "\x00"))
+	h.Write(itob(id))
+	return h.Sum64()
+}
+
+// currentDay reports whether the date YYYY-MM-DD is today in some time
+// zone.
+func currentDay(day string) bool {
+	now := time.Now().UTC()
+	for _, d := range []time.Time{now.AddDate(0, 0, -1), now, now.AddDate(0, 0, 1)} {
+		if d.Format("2006-01-02") == day {
+			return true
+		}
+	}
+	return false
+}
+
+// firstCurrentDay returns the earliest date YYYY-MM-DD that is today in
+// some time zone; the pins of earlier days are dropped.
+func firstCurrentDay() string {
+	return time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+// pinKey returns the key of the pin of day and key in the pin bucket.
+func pinKey(day, key string) []byte {
+	return []byte(day + "\x00" + key)
+}
+
+// Pinned implements Pinner.
+func (d *DB) Pinned(day, key string) (uint64, error) {
+	var id uint64
+	err := d.view("Pinned", func(tx namespace) error {
+		if v := tx.Bucket([]byte(pinBucket)).Get(pinKey(day, key)); v != nil {
+			id = btoi(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "Pinned: DB.View() failed")
+	}
+	return id, nil
+}
+
+// Pin implements Pinner.
+func (d *DB) Pin(day, key string, id uint64) error {
+	err := d.update("Pin", func(tx namespace) error {
+		pins := tx.Bucket([]byte(pinBucket))
+		first := pinKey(firstCurrentDay(), "")
+		old := [][]byte{}
+		c := pins.Cursor()
+		for k, _ := c.First(); k != nil && string(k) < string(first); k, _ = c.Next() {
+			old = append(old, k)
+		}
+		for _, k := range old {
+			err := pins.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		k := pinKey(day, key)
+		if pins.Get(k) == nil && pins.Stats().KeyN >= maxPins {
+			return nil
+		}
+		return pins.Put(k, itob(id))
+	})
+	if err != nil {
+		return errors.Wrap(err, "Pin: DB.Update() failed")
+	}
+	return nil
+}
+
+// Pinned implements Pinner.
+func (m *MemoryStore) Pinned(day, key string) (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pins[string(pinKey(day, key))], nil
+}
+
+// Pin implements Pinner.
+func (m *MemoryStore) Pin(day, key string, id uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	first := string(pinKey(firstCurrentDay(), ""))
+	for k := range m.pins {
+		if k < first {
+			delete(m.pins, k)
+		}
+	}
+	k := string(pinKey(day, key))
+	if _, ok := m.pins[k]; ok || len(m.pins) < maxPins {
+		m.pins[k] = id
+	}
+	return nil
+}
+
+// Pinned implements Pinner.
+func (s *SQLiteStore) Pinned(day, key string) (uint64, error) {
+	var id uint64
+	err := s.db.QueryRow("SELECT id FROM pins WHERE day = ? AND key = ?", day, key).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "Pinned: select failed")
+	}
+	return id, nil
+}
+
+// Pin implements Pinner.
+func (s *SQLiteStore) Pin(day, key string, id uint64) error {
+	_, err := s.db.Exec("DELETE FROM pins WHERE day < ?", firstCurrentDay())
+	if err != nil {
+		return errors.Wrap(err, "Pin: delete failed")
+	}
+	_, err = s.db.Exec(`UPDATE pins SET id = ? WHERE day = ? AND key = ?`, id, day, key)
+	if err != nil {
+		return errors.Wrap(err, "Pin: update failed")
+	}
+	_, err = s.db.Exec(`INSERT INTO pins (day, key, id) SELECT ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM pins WHERE day = ? AND key = ?)
+		AND (SELECT COUNT(*) FROM pins) < ?`, day, key, id, day, key, maxPins)
+	if err != nil {
+		return errors.Wrap(err, "Pin: insert failed")
+	}
+	return nil
+}
@@ -1,32 +1,92 @@
 package quotes
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/pkg/errors"
+
+	"test/trace"
 )
 
 type DB struct {
 	db *bolt.DB
+	// hub publishes the changes of the tenant, hubs keeps those of all.
+	hub  *hub
+	hubs *hubs
+	// cache holds the results of reads until a write changes them.
+	cache *cache
+	// txTimes records how long the transactions of view and update take.
+	txTimes *txTimes
+	// ctx is the context of the calls, see WithContext.
+	ctx context.Context
+	// tenant is the tenant whose data the calls see, see ForTenant.
+	tenant string
+	// errorLog reports the errors that no call returns, see SetErrorLog.
+	errorLog func(ctx context.Context, msg string, err error, kv ...interface{})
 }
 
 const (
-	quoteBucket = "shit"
+	quoteBucket  = "shit"
+	authorBucket = "authors"
 )
 
-// Open opens the database file at path and returns a DB or an error.
+// buckets lists the buckets of a tenant, which the migrations make sure
+// exist.
+var buckets = []string{quoteBucket, authorBucket, searchBucket, metaBucket, changeBucket, trashBucket, historyBucket, slotBucket, tagBucket, tagCountBucket, dedupBucket, collectionBucket, membershipBucket, pinBucket}
+
+// globalBuckets lists the buckets that the tenants share, at the top level.
+var globalBuckets = []string{apiKeyBucket}
+
+// Open opens the database file at path, migrates it to the current schema
+// version and returns a DB or an error.
 func Open(path string) (*DB, error) {
-	db, err := bolt.Open(path, 0600, nil)
+	d, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = d.migrate(false)
+	if err != nil {
+		d.db.Close()
+		return nil, errors.Wrap(err, "Open: cannot migrate DB file "+path)
+	}
+	return d, nil
+}
+
+// open opens the database file at path without migrating it. It fails
+// with ErrLocked if another process has the file open.
+func open(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: lockTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, ErrLocked
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "Open: cannot open DB file "+path)
 	}
+	return newDB(db), nil
+}
+
+// newDB returns the DB of the default tenant of db.
+func newDB(db *bolt.DB) *DB {
+	hub := newHub()
+	// Writes are not batched until SetBatch says so.
+	db.MaxBatchSize = 0
 	return &DB{
-		db: db,
-	}, nil
+		db:      db,
+		hub:     hub,
+		hubs:    newHubs(hub),
+		cache:   newCache(DefaultCacheSize, DefaultCacheTTL),
+		txTimes: newTxTimes(),
+	}
 }
 
 func (d *DB) Close() error {
+	d.hubs.closeSubscribers()
+	d.SetCache(0, 0)
 	err := d.db.Close()
 	if err != nil {
 		return errors.Wrap(err, "Close: cannot close database")
@@ -34,120 +94,361 @@ func (d *DB) Close() error {
 	return nil
 }
 
-// Create takes a quote and saves it to the database, using the author name
-// as the key. If the author already exists, Create returns an error.
-func (d *DB) Create(q *Quote) error {
-	err := d.db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte(quoteBucket))
+// WithContext returns a copy of d whose calls belong to ctx: every
+// transaction is traced as a child of the span in ctx. The copy shares the
+// database with d and needs no closing.
+func (d *DB) WithContext(ctx context.Context) QuoteStore {
+	c := *d
+	c.ctx = ctx
+	return &c
+}
+
+// actorKey is the context key of the API key that changes are made with.
+type actorKey struct{}
+
+// WithActor returns a copy of ctx in which changes are made with the API
+// key with the given ID. A store bound to it with WithContext records the
+// key in the revisions it writes.
+func WithActor(ctx context.Context, keyID uint64) context.Context {
+	return context.WithValue(ctx, actorKey{}, keyID)
+}
+
+// actorOf returns the ID of the API key changes are made with in ctx, or
+// 0. ctx may be nil.
+func actorOf(ctx context.Context) uint64 {
+	if ctx == nil {
+		return 0
+	}
+	id, _ := ctx.Value(actorKey{}).(uint64)
+	return id
+}
+
+// actor returns the ID of the API key the calls of d are made with, or 0.
+func (d *DB) actor() uint64 {
+	return actorOf(d.ctx)
+}
 
+// SetErrorLog makes d report the errors that no call returns, such as
+// failures to publish changes after a commit, to fn. Without it they are
+// dropped. Set it before the DB is used.
+func (d *DB) SetErrorLog(fn func(ctx context.Context, msg string, err error, kv ...interface{})) {
+	d.errorLog = fn
+}
+
+// logError reports err to the error log of d, if it has one.
+func (d *DB) logError(msg string, err error, kv ...interface{}) {
+	if d.errorLog == nil {
+		return
+	}
+	ctx := d.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	d.errorLog(ctx, msg, err, kv...)
+}
+
+// view runs fn in a read-only transaction on the namespace of the tenant,
+// traced and timed as operation op, see Stats.
+func (d *DB) view(op string, fn func(tx namespace) error) error {
+	span := d.startSpan(op, false)
+	start := time.Now()
+	err := d.db.View(func(tx *bolt.Tx) error {
+		ns, err := d.namespace(tx)
 		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
+			return err
 		}
-		v := bucket.Get([]byte(q.Author))
+		return fn(ns)
+	})
+	d.txTimes.observe(op, time.Since(start))
+	endSpan(span, err)
+	return err
+}
 
-		if v == nil {
-			buffer, err := q.Serialize()
-			if err != nil {
-				return fmt.Errorf("can`t serialize quote: %s", err)
-			}
-			error := bucket.Put([]byte(q.Author), buffer)
-			if error != nil {
-				return fmt.Errorf("put data to bucket: %s", error)
-			}
-			return nil
+// startSpan starts the span of a transaction for op, if the context of d
+// is traced.
+func (d *DB) startSpan(op string, writable bool) *trace.Span {
+	if d.ctx == nil {
+		return nil
+	}
+	_, span := trace.Start(d.ctx, "quotes."+op)
+	span.SetAttr("db.system", "bolt")
+	span.SetAttr("db.writable", writable)
+	return span
+}
+
+// endSpan ends the span of a transaction that returned err. A missing
+// record is an answer, not a failure.
+func endSpan(span *trace.Span, err error) {
+	if err != ErrNotFound {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+// ErrNotFound is returned when there is no quote with the requested ID.
+var ErrNotFound = errors.New("record not found")
+
+// ErrRevisionMismatch is returned by Update and DeleteIfMatch when the
+// stored quote is not at the expected revision.
+var ErrRevisionMismatch = errors.New("revision mismatch")
+
+// Create takes a quote, normalizes it, assigns it a new ID and revision 1
+// and saves it to the database. The quote is also added to the author
+// index, so an author can have any number of quotes. An invalid quote or
+// one that nearly duplicates another fails with a *ValidationError, and
+// one more than the quotas of the tenant allow with ErrQuotaExceeded.
+// Concurrent calls share transactions if batching is on, see SetBatch.
+func (d *DB) Create(q *Quote) error {
+	err := prepare(q)
+	if err != nil {
+		return err
+	}
+	// What depends on the stored quotes is checked in the transaction,
+	// where the quotes created before in the same batch are seen.
+	err = d.batch("Create", func(tx namespace) error {
+		if id := findDuplicate(tx, q); id != 0 {
+			return duplicateError(id)
+		}
+		err := checkQuota(tx, false)
+		if err != nil {
+			return err
+		}
+		id, err := tx.Bucket([]byte(quoteBucket)).NextSequence()
+		if err != nil {
+			return fmt.Errorf("next sequence: %s", err)
 		}
-		return errors.New("record already exists")
+		q.ID = id
+		q.Rev = 1
+		return putQuote(tx, q, nil, d.actor())
 	})
 	return err
 }
 
-// udate value in DB if it exists
+// Update normalizes q, replaces the quote with ID q.ID and bumps its
+// revision. If q.Rev is not zero, the stored quote must be at that
+// revision, otherwise Update fails with ErrRevisionMismatch. The author
+// and search indexes are updated in the same transaction. Invalid quotes
+// fail as with Create, once the quote and its revision are found.
 func (d *DB) Update(q *Quote) error {
-	err := d.db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte(quoteBucket))
+	err := d.update("Update", func(tx namespace) error {
+		old, err := getQuote(tx, q.ID)
+		if err != nil {
+			return errors.Wrap(err, "Update")
+		}
+		if old == nil {
+			return ErrNotFound
+		}
+		if q.Rev != 0 && q.Rev != old.Rev {
+			return ErrRevisionMismatch
+		}
+		err = prepare(q)
 		if err != nil {
 			return err
 		}
+		if id := findDuplicate(tx, q); id != 0 && !keepsText(q, old) {
+			return duplicateError(id)
+		}
+		q.Rev = old.Rev + 1
+		return putQuote(tx, q, old, d.actor())
+	})
 
-		v := bucket.Get([]byte(q.Author))
-		if len(v) != 0 {
-			buffer, err := q.Serialize()
+	return err
+}
 
+// Get takes a quote ID and retrieves the corresponding quote from the DB.
+// It fails with ErrNotFound if there is no such quote. Quotes are served
+// from the cache while they are unchanged.
+func (d *DB) Get(id uint64) (*Quote, error) {
+	v, err := d.cached(cacheKey{kind: "get", id: id}, func() (interface{}, error) {
+		var q *Quote
+		err := d.view("Get", func(tx namespace) error {
+			var err error
+			q, err = getQuote(tx, id)
 			if err != nil {
-				return fmt.Errorf("can`t serialize quote: %s", err)
+				return errors.Wrap(err, "Get")
 			}
-			error := bucket.Put([]byte(q.Author), buffer)
-
-			if error != nil {
-				return fmt.Errorf("update data to bucket: %s", error)
+			if q == nil {
+				return ErrNotFound
 			}
-		}
-
-		return err
+			return nil
+		})
+		return q, err
 	})
 
-	return err
-}
+	if err == ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Get: DB.View() failed")
+	}
 
-// Get takes an author name and retrieves the corresponding quote from the DB.
-func (d *DB) Get(author string) (*Quote, error) {
-	q := &Quote{}
-	err := d.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(quoteBucket))
+	q := clone(*v.(*Quote))
+	return &q, nil
+}
 
-		if bucket == nil {
-			return errors.Errorf("Cannot get %s - bucket %s not found", author, quoteBucket)
+// Delete moves the quote with the given ID into the trash and removes its
+// index entries. It fails with ErrNotFound if there is no such quote.
+func (d *DB) Delete(id uint64) error {
+	err := d.update("Delete", func(tx namespace) error {
+		q, err := getQuote(tx, id)
+		if err != nil {
+			return errors.Wrap(err, "Delete")
 		}
-		v := bucket.Get([]byte(author))
-		if v == nil {
-			return errors.New("can`t fin record")
+		if q == nil {
+			return ErrNotFound
 		}
-		err := q.Deserialize(v)
+		return trashQuote(tx, q)
+	})
+
+	return err
+}
+
+// DeleteIfMatch moves the quote with the given ID into the trash if it is
+// at revision rev. It returns ErrRevisionMismatch otherwise, also if there is no such
+// quote.
+func (d *DB) DeleteIfMatch(id, rev uint64) error {
+	err := d.update("DeleteIfMatch", func(tx namespace) error {
+		q, err := getQuote(tx, id)
 		if err != nil {
-			return errors.Wrapf(err, "Get: cannot deserialize %s", v)
+			return errors.Wrap(err, "DeleteIfMatch")
 		}
-		return nil
+		if q == nil || q.Rev != rev {
+			return ErrRevisionMismatch
+		}
+		return trashQuote(tx, q)
 	})
 
+	return err
+}
+
+// getQuote reads the quote with the given ID within tx. It returns nil
+// without an error if there is no such quote.
+func getQuote(tx namespace, id uint64) (*Quote, error) {
+	v := tx.Bucket([]byte(quoteBucket)).Get(itob(id))
+	if v == nil {
+		return nil, nil
+	}
+	q := &Quote{}
+	err := q.Deserialize(v)
 	if err != nil {
-		return nil, errors.Wrap(err, "Get: DB.View() failed")
+		return nil, errors.Wrapf(err, "cannot deserialize record %d", id)
 	}
-
 	return q, nil
 }
 
-// delete quote from DB
-func (d *DB) Delete(author string) error {
-	err := d.db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte(quoteBucket))
+// putQuote stores q under its ID, updates the indexes and the history and
+// logs the change. old is the currently stored version of q, or nil if q
+// is new. actor is the ID of the API key making the change, or 0.
+func putQuote(tx namespace, q, old *Quote, actor uint64) error {
+	q.Tags = normalizeTags(q.Tags)
+	buffer, err := q.Serialize()
+	if err != nil {
+		return fmt.Errorf("can`t serialize quote: %s", err)
+	}
+	err = tx.Bucket([]byte(quoteBucket)).Put(itob(q.ID), buffer)
+	if err != nil {
+		return fmt.Errorf("put data to bucket: %s", err)
+	}
+
+	authors := tx.Bucket([]byte(authorBucket))
+	if old != nil {
+		err = authors.Delete(indexKey(old.Author, old.ID))
+		if err != nil {
+			return fmt.Errorf("delete author index: %s", err)
+		}
+		err = unindexQuote(tx, old)
 		if err != nil {
 			return err
 		}
-
-		bucket.Delete([]byte(author))
-
+		err = unindexTags(tx, old)
+		if err != nil {
+			return err
+		}
+		err = unindexText(tx, old)
+		if err != nil {
+			return err
+		}
+	}
+	err = authors.Put(indexKey(q.Author, q.ID), nil)
+	if err != nil {
+		return fmt.Errorf("put author index: %s", err)
+	}
+	err = indexQuote(tx, q)
+	if err != nil {
 		return err
-	})
+	}
+	err = indexTags(tx, q)
+	if err != nil {
+		return err
+	}
+	err = indexText(tx, q)
+	if err != nil {
+		return err
+	}
+	err = saveRevision(tx, q, old, actor)
+	if err != nil {
+		return fmt.Errorf("save revision: %s", err)
+	}
+	if old == nil {
+		err = addSlot(tx, q.ID)
+		if err != nil {
+			return fmt.Errorf("add slot: %s", err)
+		}
+		return logChange(tx, EventCreated, q)
+	}
+	return logChange(tx, EventUpdated, q)
+}
 
-	return err
+// removeQuote deletes q and its index entries and logs the change.
+func removeQuote(tx namespace, q *Quote) error {
+	err := tx.Bucket([]byte(quoteBucket)).Delete(itob(q.ID))
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket([]byte(authorBucket)).Delete(indexKey(q.Author, q.ID))
+	if err != nil {
+		return err
+	}
+	err = unindexQuote(tx, q)
+	if err != nil {
+		return err
+	}
+	err = unindexTags(tx, q)
+	if err != nil {
+		return err
+	}
+	err = unindexText(tx, q)
+	if err != nil {
+		return err
+	}
+	err = removeSlot(tx, q.ID)
+	if err != nil {
+		return err
+	}
+	return logChange(tx, EventDeleted, q)
 }
 
-// List lists all records in the DB.
+// List lists all records in the DB, ordered by ID. The list is served from
+// the cache until any quote changes.
 func (d *DB) List() ([]*Quote, error) {
+	v, err := d.cached(cacheKey{kind: "list"}, d.list)
+	if err != nil {
+		return nil, err
+	}
+	return cloneQuotes(v.([]*Quote)), nil
+}
+
+func (d *DB) list() (interface{}, error) {
 	structList := []*Quote{}
 
-	err := d.db.View(func(tx *bolt.Tx) error {
+	err := d.view("List", func(tx namespace) error {
 		b := tx.Bucket([]byte(quoteBucket))
 
-		if b == nil {
-			return nil
-		}
-
 		err := b.ForEach(func(k, v []byte) error {
 			q := &Quote{}
 			err := q.Deserialize(v)
 			if err != nil {
-				return errors.Wrapf(err, "List: cannot deserialize data of author %s", k)
+				return errors.Wrapf(err, "List: cannot deserialize record %d", btoi(k))
 			}
 			structList = append(structList, q)
 
@@ -161,8 +462,66 @@ func (d *DB) List() ([]*Quote, error) {
 	})
 
 	if err != nil {
-		return nil, errors.Wrap(err, "Get: DB.View() failed")
+		return nil, errors.Wrap(err, "List: DB.View() failed")
 	}
 
 	return structList, nil
 }
+
+// ListByAuthor lists all quotes of one author, ordered by ID.
+func (d *DB) ListByAuthor(author string) ([]*Quote, error) {
+	structList := []*Quote{}
+
+	err := d.view("ListByAuthor", func(tx namespace) error {
+		quotes := tx.Bucket([]byte(quoteBucket))
+		c := tx.Bucket([]byte(authorBucket)).Cursor()
+
+		prefix := indexPrefix(author)
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			id := btoi(k[len(prefix):])
+			v := quotes.Get(itob(id))
+			if v == nil {
+				return errors.Errorf("ListByAuthor: dangling index entry %d for %s", id, author)
+			}
+			q := &Quote{}
+			err := q.Deserialize(v)
+			if err != nil {
+				return errors.Wrapf(err, "ListByAuthor: cannot deserialize record %d", id)
+			}
+			structList = append(structList, q)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "ListByAuthor: DB.View() failed")
+	}
+
+	return structList, nil
+}
+
+// itob returns the 8-byte big endian representation of id, so that
+// keys sort in numeric order.
+func itob(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
+
+// btoi is the inverse of itob.
+func btoi(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
+
+// indexKey builds a secondary index key: the indexed value (an author
+// name, a search term), a zero byte separator and the quote ID.
+func indexKey(value string, id uint64) []byte {
+	return append(indexPrefix(value), itob(id)...)
+}
+
+// indexPrefix returns the common prefix of all index keys for value.
+func indexPrefix(value string) []byte {
+	k := make([]byte, 0, len(value)+9)
+	k = append(k, value...)
+	return append(k, 0)
+}
@@ -1,9 +1,16 @@
 package quotes
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"test/trace"
 )
 
 func TestOpenClose(t *testing.T) {
@@ -55,7 +62,7 @@ func TestDB_CreateAndGet(t *testing.T) {
 		wantErr bool
 	}{
 		{"Create007", Quote{Author: "007", Text: "Shaken, not stirred", Source: "Diamonds Are Forever"}, false},
-		{"Update007", Quote{Author: "007", Text: "Stirred, not shaken", Source: "Graphite is ephemeral"}, true},
+		{"Create007Again", Quote{Author: "007", Text: "Stirred, not shaken", Source: "Graphite is ephemeral"}, false},
 		{"CreateGopher", Quote{Author: "Gopher", Text: "Clear is better than clever.", Source: "Go Proverbs"}, false},
 	}
 	path := "testdata/creategetdb"
@@ -80,9 +87,13 @@ func TestDB_CreateAndGet(t *testing.T) {
 				// and so we can end this loop iteration.
 				return
 			}
-			q, err := d.Get(tt.quote.Author)
+			if tt.quote.ID == 0 {
+				t.Errorf("DB.Create(): no ID assigned to %#v", tt.quote)
+				return
+			}
+			q, err := d.Get(tt.quote.ID)
 			if q == nil || err != nil {
-				t.Errorf("DB.Get(): Cannot get record for %s: error = %v", tt.quote.Author, err)
+				t.Errorf("DB.Get(): Cannot get record %d: error = %v", tt.quote.ID, err)
 				return
 			}
 			if !reflect.DeepEqual(*q, tt.quote) {
@@ -113,9 +124,9 @@ func TestDB_List(t *testing.T) {
 		[]*Quote{
 			// The items in the DB are sorted, due to the
 			// internal B+tree data structure. Hence the output of List()
-			// is expected to be sorted by author.
-			&Quote{Author: "Albert Szent-Györgyi", Text: "Discovery consists of seeing what everybody has seen and thinking what nobody has thought."},
-			&Quote{Author: "Leo Babauta", Text: "The value of doing is so much greater than the value of being safe and doing nothing."},
+			// is expected to be sorted by ID, i.e. in insertion order.
+			&Quote{ID: 1, Rev: 1, Author: "Leo Babauta", Text: "The value of doing is so much greater than the value of being safe and doing nothing."},
+			&Quote{ID: 2, Rev: 1, Author: "Albert Szent-Györgyi", Text: "Discovery consists of seeing what everybody has seen and thinking what nobody has thought."},
 		},
 	}
 	path := "testdata/listdb"
@@ -157,3 +168,251 @@ func TestDB_List(t *testing.T) {
 	}
 
 }
+
+func TestDB_ListByAuthor(t *testing.T) {
+	path := "testdata/listbyauthordb"
+
+	// Setup
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+
+	defer func() {
+		// Teardown
+		err = d.Close()
+		if err != nil {
+			t.Errorf("Cannot close %s", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			t.Errorf("Cannot remove %s", path)
+		}
+	}()
+
+	// Fill the DB. "Rob" is a prefix of "Rob Pike" and must not leak
+	// into the result.
+	data := []*Quote{
+		{Author: "Rob Pike", Text: "Don't communicate by sharing memory, share memory by communicating."},
+		{Author: "Rob", Text: "Hello."},
+		{Author: "Rob Pike", Text: "Concurrency is not parallelism."},
+		{Author: "Rob Pike", Text: "Channels orchestrate; mutexes serialize."},
+	}
+	for _, q := range data {
+		err := d.Create(q)
+		if err != nil {
+			t.Fatalf("Cannot fill test database: " + err.Error())
+		}
+	}
+
+	// Move one quote to another author and delete another one.
+	moved := *data[3]
+	moved.Author = "Gopher"
+	err = d.Update(&moved)
+	if err != nil {
+		t.Fatalf("DB.Update() error = %v", err)
+	}
+	err = d.Delete(data[0].ID)
+	if err != nil {
+		t.Fatalf("DB.Delete() error = %v", err)
+	}
+
+	tests := []struct {
+		author string
+		want   []*Quote
+	}{
+		{"Rob Pike", []*Quote{data[2]}},
+		{"Rob", []*Quote{data[1]}},
+		{"Gopher", []*Quote{&moved}},
+		{"Nobody", []*Quote{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.author, func(t *testing.T) {
+			got, err := d.ListByAuthor(tt.author)
+			if err != nil {
+				t.Errorf("DB.ListByAuthor() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DB.ListByAuthor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// spanRecorder collects the names of exported spans.
+type spanRecorder struct {
+	names []string
+}
+
+func (r *spanRecorder) Export(s *trace.Span) {
+	r.names = append(r.names, s.Name())
+}
+
+func TestDB_WithContext(t *testing.T) {
+	path := "testdata/contextdb"
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer func() {
+		d.Close()
+		os.Remove(path)
+	}()
+
+	rec := &spanRecorder{}
+	ctx, root := trace.NewTracer(rec).Start(context.Background(), "request", "")
+	traced := WithContext(d, ctx)
+	q := &Quote{Author: "Gopher", Text: "Errors are values."}
+	err = traced.Create(q)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	_, err = traced.Get(q.ID + 1)
+	if err != ErrNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrNotFound)
+	}
+	root.End()
+
+	// d itself is not traced.
+	_, err = d.Get(q.ID)
+	if err != nil {
+		t.Errorf("Get() error = %v", err)
+	}
+	want := []string{"quotes.Create", "quotes.Get", "request"}
+	if !reflect.DeepEqual(rec.names, want) {
+		t.Errorf("spans = %v, want %v", rec.names, want)
+	}
+}
+
+func TestDB_CreateBatched(t *testing.T) {
+	path := "testdata/batchdb"
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer func() {
+		d.Close()
+		os.Remove(path)
+	}()
+	d.SetBatch(DefaultBatchSize, time.Second)
+
+	// One batch of quotes of which two are bad: the others are still
+	// committed, and only the bad ones fail.
+	qs := make([]*Quote, 20)
+	for i := range qs {
+		qs[i] = &Quote{Author: "Gopher", Text: fmt.Sprintf("Proverb number %d.", i)}
+	}
+	qs[5].Author = ""
+	qs[12].Text = qs[3].Text
+	errs := make([]error, len(qs))
+	var wg sync.WaitGroup
+	for i := range qs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = d.Create(qs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	if !isValidationError(errs[5]) {
+		t.Errorf("Create() of an invalid quote error = %v, want a ValidationError", errs[5])
+	}
+	// Whichever of the duplicates came first is stored.
+	if (errs[3] == nil) == (errs[12] == nil) || !isValidationError(errs[3]) && !isValidationError(errs[12]) {
+		t.Errorf("Create() of a duplicate errors = %v, %v, want one ValidationError", errs[3], errs[12])
+	}
+	ids := map[uint64]bool{}
+	for i, err := range errs {
+		if i == 5 || err != nil && (i == 3 || i == 12) {
+			continue
+		}
+		if err != nil || ids[qs[i].ID] {
+			t.Errorf("Create(%d) = %d, %v", i, qs[i].ID, err)
+		}
+		ids[qs[i].ID] = true
+	}
+	quotes, err := d.List()
+	if err != nil || len(quotes) != len(qs)-2 {
+		t.Errorf("List() = %d quotes, %v, want %d", len(quotes), err, len(qs)-2)
+	}
+
+	// Near-duplicates and quotas are checked against the quotes created
+	// before in the same batch, by Create and by Import.
+	err = d.CreateTenant(&Tenant{Name: "small", Quotas: Quotas{MaxQuotes: 3}})
+	if err != nil {
+		t.Fatalf("CreateTenant() error = %v", err)
+	}
+	small := d.ForTenant("small").(*DB)
+	var created, duplicates, imported int64
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			err := d.Create(&Quote{Author: "Gopher", Text: "Don't panic!"})
+			if err == nil {
+				atomic.AddInt64(&created, 1)
+			} else if isValidationError(err) {
+				atomic.AddInt64(&duplicates, 1)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			results, err := d.Import([]*Quote{{Author: "Gopher", Text: "DON'T PANIC."}}, ConflictError)
+			if err != nil {
+				t.Errorf("Import() error = %v", err)
+			} else if results[0].Action == ImportCreated {
+				atomic.AddInt64(&imported, 1)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			small.Create(&Quote{Author: "Gopher", Text: fmt.Sprintf("Small proverb number %d.", i)})
+		}(i)
+	}
+	wg.Wait()
+	if created+imported != 1 || created+duplicates != 10 {
+		t.Errorf("concurrent near-duplicates: %d created, %d imported, %d duplicates, want 1 stored", created, imported, duplicates)
+	}
+	if tenant, err := d.Tenant("small"); err != nil || tenant.Quotes != 3 {
+		t.Errorf("Tenant() after concurrent creates over the quota = %+v, %v, want 3 quotes", tenant, err)
+	}
+}
+
+// The Create benchmarks write from many goroutines, like the import
+// pipeline does.
+func benchmarkCreate(b *testing.B, name string, batch int) {
+	path := "testdata/" + name + "db"
+	d, err := Open(path)
+	if err != nil {
+		b.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer func() {
+		d.Close()
+		os.Remove(path)
+	}()
+	d.SetBatch(batch, DefaultBatchDelay)
+
+	var n int64
+	b.SetParallelism(32)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&n, 1)
+			err := d.Create(&Quote{Author: "Gopher", Text: fmt.Sprintf("Benchmark proverb number %d.", i)})
+			if err != nil {
+				b.Errorf("Create() error = %v", err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkDB_Create(b *testing.B) {
+	benchmarkCreate(b, "benchcreate", 0)
+}
+
+func BenchmarkDB_CreateBatched(b *testing.B) {
+	benchmarkCreate(b, "benchbatch", DefaultBatchSize)
+}
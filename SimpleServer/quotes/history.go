@@ -0,0 +1,263 @@
+package quotes
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// historyBucket holds a nested bucket per quote ID with every revision of
+// the quote, keyed by revision.
+const historyBucket = "history"
+
+// Revision is one stored version of a quote.
+type Revision struct {
+	Quote
+	// Time is when the revision was written. It is zero for revisions
+	// written before history was kept.
+	Time time.Time `json:"time"`
+	// Actor is the ID of the API key the revision was written with. It
+	// is zero if that is not known.
+	Actor uint64 `json:"actor,omitempty"`
+}
+
+// FieldChange is a field that differs between two versions of a quote.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// Historian is implemented by stores that keep every revision of a quote.
+type Historian interface {
+	// History returns the revisions of the quote with the given ID,
+	// oldest first, or fails with ErrNotFound if there are none.
+	History(id uint64) ([]*Revision, error)
+	// Revision returns revision rev of the quote with the given ID, or
+	// fails with ErrNotFound.
+	Revision(id, rev uint64) (*Revision, error)
+}
+
+// Diff returns the fields that differ between a and b. Tags are compared
+// as a comma-separated list.
+func Diff(a, b *Quote) []FieldChange {
+	changes := []FieldChange{}
+	for _, f := range []struct{ name, a, b string }{
+		{"author", a.Author, b.Author},
+		{"text", a.Text, b.Text},
+		{"source", a.Source, b.Source},
+		{"tags", strings.Join(a.Tags, ", "), strings.Join(b.Tags, ", ")},
+	} {
+		if f.a != f.b {
+			changes = append(changes, FieldChange{f.name, f.a, f.b})
+		}
+	}
+	return changes
+}
+
+// History implements Historian. Revisions stay available while the quote
+// is in the trash.
+func (d *DB) History(id uint64) ([]*Revision, error) {
+	revisions := []*Revision{}
+	err := d.view("History", func(tx namespace) error {
+		revs := tx.Bucket([]byte(historyBucket)).Bucket(itob(id))
+		if revs == nil {
+			return ErrNotFound
+		}
+		return revs.ForEach(func(k, v []byte) error {
+			r, err := decodeRevision(v)
+			if err != nil {
+				return errors.Wrapf(err, "cannot decode revision %d of record %d", btoi(k), id)
+			}
+			revisions = append(revisions, r)
+			return nil
+		})
+	})
+	if err == ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "History: DB.View() failed")
+	}
+	return revisions, nil
+}
+
+// Revision implements Historian.
+func (d *DB) Revision(id, rev uint64) (*Revision, error) {
+	var r *Revision
+	err := d.view("Revision", func(tx namespace) error {
+		revs := tx.Bucket([]byte(historyBucket)).Bucket(itob(id))
+		if revs == nil {
+			return ErrNotFound
+		}
+		v := revs.Get(itob(rev))
+		if v == nil {
+			return ErrNotFound
+		}
+		var err error
+		r, err = decodeRevision(v)
+		if err != nil {
+			return errors.Wrapf(err, "cannot decode revision %d of record %d", rev, id)
+		}
+		return nil
+	})
+	if err == ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Revision: DB.View() failed")
+	}
+	return r, nil
+}
+
+// saveRevision adds q to the history of its quote within tx, written with
+// the API key actor. old is the version q replaces, or nil if q is new or
+// undeleted.
+func saveRevision(tx namespace, q, old *Quote, actor uint64) error {
+	// The history of a quote only goes with the quote, when it is purged
+	// from the trash, so an ID is never reused with the history of another
+	// quote.
+	revs, err := tx.Bucket([]byte(historyBucket)).CreateBucketIfNotExists(itob(q.ID))
+	if err != nil {
+		return err
+	}
+
+	if old != nil && revs.Get(itob(old.Rev)) == nil {
+		// old was written before history was kept.
+		v, err := encodeStamped(time.Time{}, old)
+		if err != nil {
+			return err
+		}
+		err = revs.Put(itob(old.Rev), v)
+		if err != nil {
+			return err
+		}
+	}
+	if old == nil && revs.Get(itob(q.Rev)) != nil {
+		// Undeleted; keep the time the revision was written.
+		return nil
+	}
+	v, err := encodeRevision(time.Now(), actor, q)
+	if err != nil {
+		return err
+	}
+	return revs.Put(itob(q.Rev), v)
+}
+
+// deleteHistory drops the history of the quote with the given ID within tx.
+func deleteHistory(tx namespace, id uint64) error {
+	history := tx.Bucket([]byte(historyBucket))
+	if history.Bucket(itob(id)) == nil {
+		return nil
+	}
+	return history.DeleteBucket(itob(id))
+}
+
+// actorMarker follows the time of a revision that records its actor, as
+// the 8-byte big endian ID of the API key. It is outside the markers of
+// codecs and the first bytes of gob records.
+const actorMarker = maxMarker + 1
+
+// encodeRevision serializes q as a revision written at time t with the API
+// key actor: like encodeStamped, with the actor behind the time if it is
+// known.
+func encodeRevision(t time.Time, actor uint64, q *Quote) ([]byte, error) {
+	v, err := encodeStamped(t, q)
+	if err != nil || actor == 0 {
+		return v, err
+	}
+	stamp := append(append(v[:8:8], actorMarker), itob(actor)...)
+	return append(stamp, v[8:]...), nil
+}
+
+// decodeRevision decodes a value of a history bucket.
+func decodeRevision(v []byte) (*Revision, error) {
+	var actor uint64
+	if n := stampLen(v); n > 8 {
+		actor = btoi(v[9:n])
+		v = append(v[:8:8], v[n:]...)
+	}
+	t, q, err := decodeStamped(v)
+	if err != nil {
+		return nil, err
+	}
+	return &Revision{Quote: *q, Time: t, Actor: actor}, nil
+}
+
+// stampLen returns the length of the time, and the actor of a revision,
+// in front of the quote in v.
+func stampLen(v []byte) int {
+	if len(v) >= 17 && v[8] == actorMarker {
+		return 17
+	}
+	return 8
+}
+
+// encodeStamped serializes q behind the time t, so that t can be read
+// without decoding q. The zero time is stored as 0.
+func encodeStamped(t time.Time, q *Quote) ([]byte, error) {
+	v, err := q.Serialize()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot serialize quote")
+	}
+	var n int64
+	if !t.IsZero() {
+		n = t.UnixNano()
+	}
+	return append(itob(uint64(n)), v...), nil
+}
+
+// decodeStamped decodes a value written by encodeStamped.
+func decodeStamped(v []byte) (time.Time, *Quote, error) {
+	if len(v) < 8 {
+		return time.Time{}, nil, errors.New("short record")
+	}
+	var t time.Time
+	if n := int64(btoi(v[:8])); n != 0 {
+		t = time.Unix(0, n).UTC()
+	}
+	q := &Quote{}
+	err := q.Deserialize(v[8:])
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	return t, q, nil
+}
+
+// History implements Historian.
+func (m *MemoryStore) History(id uint64) ([]*Revision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	revs := m.history[id]
+	if len(revs) == 0 {
+		return nil, ErrNotFound
+	}
+	revisions := make([]*Revision, len(revs))
+	for i := range revs {
+		r := revs[i]
+		r.Quote = clone(r.Quote)
+		revisions[i] = &r
+	}
+	return revisions, nil
+}
+
+// Revision implements Historian.
+func (m *MemoryStore) Revision(id, rev uint64) (*Revision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, r := range m.history[id] {
+		if r.Rev == rev {
+			r.Quote = clone(r.Quote)
+			return &r, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// saveRevision adds q to the history of its quote. The caller holds m.mu.
+func (m *MemoryStore) saveRevision(q Quote) {
+	m.history[q.ID] = append(m.history[q.ID], Revision{Quote: clone(q), Time: time.Now().UTC(), Actor: actorOf(m.ctx)})
+}
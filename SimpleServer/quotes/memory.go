@@ -0,0 +1,188 @@
+package quotes
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps quotes in a map. Its content is lost on Close;
+// it is meant for tests and ephemeral environments.
+type MemoryStore struct {
+	*memoryState
+	// ctx is the context of the calls, see WithContext.
+	ctx context.Context
+}
+
+// memoryState is the content of a MemoryStore, shared by its copies.
+type memoryState struct {
+	mu      sync.RWMutex
+	quotes  map[uint64]Quote
+	trash   map[uint64]TrashedQuote
+	history map[uint64][]Revision
+	pins    map[string]uint64
+	seq     uint64
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{memoryState: &memoryState{
+		quotes:  map[uint64]Quote{},
+		trash:   map[uint64]TrashedQuote{},
+		history: map[uint64][]Revision{},
+		pins:    map[string]uint64{},
+	}}
+}
+
+// WithContext returns a copy of m whose changes are made with the API key
+// in ctx, see WithActor. The copy shares the quotes with m.
+func (m *MemoryStore) WithContext(ctx context.Context) QuoteStore {
+	c := *m
+	c.ctx = ctx
+	return &c
+}
+
+// Create assigns q the next ID and revision 1 and stores a copy of it.
+func (m *MemoryStore) Create(q *Quote) error {
+	err := prepare(q)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q.ID = 0
+	if id := m.findDuplicate(q); id != 0 {
+		return duplicateError(id)
+	}
+	m.seq++
+	q.ID = m.seq
+	q.Rev = 1
+	m.quotes[q.ID] = clone(*q)
+	m.saveRevision(*q)
+	return nil
+}
+
+// Get returns a copy of the quote with the given ID.
+func (m *MemoryStore) Get(id uint64) (*Quote, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	q, ok := m.quotes[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	q = clone(q)
+	return &q, nil
+}
+
+// Update replaces the quote with ID q.ID if it exists and bumps its
+// revision. A non-zero q.Rev must match the stored revision.
+func (m *MemoryStore) Update(q *Quote) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.quotes[q.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if q.Rev != 0 && q.Rev != old.Rev {
+		return ErrRevisionMismatch
+	}
+	err := prepare(q)
+	if err != nil {
+		return err
+	}
+	if id := m.findDuplicate(q); id != 0 && !keepsText(q, &old) {
+		return duplicateError(id)
+	}
+	q.Rev = old.Rev + 1
+	m.quotes[q.ID] = clone(*q)
+	m.saveRevision(*q)
+	return nil
+}
+
+// findDuplicate returns the ID of a quote other than q whose text nearly
+// duplicates that of q, or 0.
+func (m *MemoryStore) findDuplicate(q *Quote) uint64 {
+	key := dedupKey(q.Text)
+	if key == nil {
+		return 0
+	}
+	for id, other := range m.quotes {
+		if id != q.ID && bytes.Equal(dedupKey(other.Text), key) {
+			return id
+		}
+	}
+	return 0
+}
+
+// Delete moves the quote with the given ID into the trash.
+func (m *MemoryStore) Delete(id uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.quotes[id]
+	if !ok {
+		return ErrNotFound
+	}
+	m.trashQuote(q)
+	return nil
+}
+
+// DeleteIfMatch moves the quote with the given ID into the trash if it is
+// at revision rev.
+func (m *MemoryStore) DeleteIfMatch(id, rev uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.quotes[id]
+	if !ok || q.Rev != rev {
+		return ErrRevisionMismatch
+	}
+	m.trashQuote(q)
+	return nil
+}
+
+// trashQuote moves q into the trash. The caller holds m.mu.
+func (m *MemoryStore) trashQuote(q Quote) {
+	delete(m.quotes, q.ID)
+	m.trash[q.ID] = TrashedQuote{Quote: q, DeletedAt: time.Now().UTC()}
+}
+
+// List returns copies of all quotes, ordered by ID.
+func (m *MemoryStore) List() ([]*Quote, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	structList := make([]*Quote, 0, len(m.quotes))
+	for _, q := range m.quotes {
+		q := clone(q)
+		structList = append(structList, &q)
+	}
+	sort.Slice(structList, func(i, j int) bool {
+		return structList[i].ID < structList[j].ID
+	})
+	return structList, nil
+}
+
+// Close drops all quotes.
+func (m *MemoryStore) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.quotes = map[uint64]Quote{}
+	m.trash = map[uint64]TrashedQuote{}
+	m.history = map[uint64][]Revision{}
+	return nil
+}
+
+// clone returns a copy of q that shares no memory with it.
+func clone(q Quote) Quote {
+	if q.Tags != nil {
+		q.Tags = append([]string(nil), q.Tags...)
+	}
+	return q
+}
@@ -0,0 +1,361 @@
+package quotes
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+const metaBucket = "meta"
+
+var schemaVersionKey = []byte("schema_version")
+
+// Migration upgrades the database schema from Version-1 to Version.
+type Migration struct {
+	Version     int
+	Description string
+	// Migrate applies the change within tx. It calls report once for
+	// every change it makes, so that a dry run can show them.
+	Migrate func(tx namespace, report func(format string, args ...interface{})) error
+}
+
+// MigrationReport lists what a migration changed in the namespace of a
+// tenant, or would change in a dry run.
+type MigrationReport struct {
+	Version     int      `json:"version"`
+	Description string   `json:"description"`
+	Changes     []string `json:"changes"`
+	Tenant      string   `json:"tenant,omitempty"`
+}
+
+// migrations is the registry of schema changes, ordered by version.
+// Append new migrations at the end; never edit or reorder released ones.
+var migrations = []Migration{
+	{1, "key quotes by generated ID instead of author", migrateKeyByID},
+	{2, "build the author and search indexes", migrateRebuildIndexes},
+	{3, "start quote revisions at 1", migrateInitRevisions},
+	{4, "record the current revision of every quote in the history", migrateInitHistory},
+	{5, "number the quotes for random picks", migrateInitSlots},
+	{6, "index the normalized texts for duplicate detection", migrateIndexTexts},
+}
+
+// errDryRun rolls back the transaction of a dry run.
+var errDryRun = errors.New("dry run")
+
+// SchemaVersion returns the schema version the database is at.
+func (d *DB) SchemaVersion() (int, error) {
+	version := 0
+	err := d.db.View(func(tx *bolt.Tx) error {
+		version = schemaVersion(tx)
+		return nil
+	})
+	return version, err
+}
+
+// PlanMigrations opens the database at path and reports what Open would
+// migrate, without changing anything.
+func PlanMigrations(path string) ([]MigrationReport, error) {
+	d, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	return d.migrate(true)
+}
+
+// migrate creates missing buckets and runs all pending migrations on the
+// namespaces of all tenants in one transaction. If dryRun is set the
+// transaction is rolled back.
+func (d *DB) migrate(dryRun bool) ([]MigrationReport, error) {
+	reports := []MigrationReport{}
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range globalBuckets {
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return fmt.Errorf("create bucket %s: %s", name, err)
+			}
+		}
+		err := namespaces(tx, func(tenant string, tx namespace) error {
+			r, err := migrateNamespace(tx, tenant)
+			reports = append(reports, r...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return nil, errors.Wrap(err, "migrate: DB.Update() failed")
+	}
+	return reports, nil
+}
+
+// migrateNamespace brings the namespace tx of tenant to the current
+// schema version.
+func migrateNamespace(tx namespace, tenant string) ([]MigrationReport, error) {
+	reports := []MigrationReport{}
+	for _, name := range buckets {
+		_, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return nil, fmt.Errorf("create bucket %s: %s", name, err)
+		}
+	}
+
+	version := schemaVersion(tx)
+	latest := migrations[len(migrations)-1].Version
+	if version > latest {
+		return nil, errors.Errorf("schema version %d is newer than supported version %d", version, latest)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		r := MigrationReport{Tenant: tenant, Version: m.Version, Description: m.Description, Changes: []string{}}
+		err := m.Migrate(tx, func(format string, args ...interface{}) {
+			r.Changes = append(r.Changes, fmt.Sprintf(format, args...))
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "migration %d (%s) failed", m.Version, m.Description)
+		}
+		reports = append(reports, r)
+	}
+
+	err := tx.Bucket([]byte(metaBucket)).Put(schemaVersionKey, itob(uint64(latest)))
+	if err != nil {
+		return nil, fmt.Errorf("put schema version: %s", err)
+	}
+	return reports, nil
+}
+
+func schemaVersion(tx namespace) int {
+	b := tx.Bucket([]byte(metaBucket))
+	if b == nil {
+		return 0
+	}
+	return int(getCount(b, schemaVersionKey))
+}
+
+// migrateKeyByID moves records that were stored under their author name
+// to a generated ID key. Such records are recognized by their zero ID.
+func migrateKeyByID(tx namespace, report func(string, ...interface{})) error {
+	bucket := tx.Bucket([]byte(quoteBucket))
+
+	legacy := map[string]*Quote{}
+	err := bucket.ForEach(func(k, v []byte) error {
+		q := &Quote{}
+		err := q.Deserialize(v)
+		if err != nil {
+			return errors.Wrapf(err, "cannot deserialize record %q", k)
+		}
+		if q.ID == 0 {
+			legacy[string(k)] = q
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Assign IDs in key order, so that the result does not depend on
+	// map iteration order.
+	c := bucket.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		q, ok := legacy[string(k)]
+		if !ok {
+			continue
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return fmt.Errorf("next sequence: %s", err)
+		}
+		q.ID = id
+		report("rekey quote of %q as %d", q.Author, id)
+	}
+	for k, q := range legacy {
+		err := bucket.Delete([]byte(k))
+		if err != nil {
+			return err
+		}
+		buffer, err := q.Serialize()
+		if err != nil {
+			return fmt.Errorf("can`t serialize quote: %s", err)
+		}
+		err = bucket.Put(itob(q.ID), buffer)
+		if err != nil {
+			return fmt.Errorf("put data to bucket: %s", err)
+		}
+	}
+	return nil
+}
+
+// migrateRebuildIndexes drops and rebuilds the author and search indexes
+// from the quote bucket.
+func migrateRebuildIndexes(tx namespace, report func(string, ...interface{})) error {
+	for _, name := range []string{authorBucket, searchBucket} {
+		err := tx.DeleteBucket([]byte(name))
+		if err != nil {
+			return fmt.Errorf("delete bucket %s: %s", name, err)
+		}
+		_, err = tx.CreateBucket([]byte(name))
+		if err != nil {
+			return fmt.Errorf("create bucket %s: %s", name, err)
+		}
+	}
+
+	all := []*Quote{}
+	err := tx.Bucket([]byte(quoteBucket)).ForEach(func(k, v []byte) error {
+		q := &Quote{}
+		err := q.Deserialize(v)
+		if err != nil {
+			return errors.Wrapf(err, "cannot deserialize record %d", btoi(k))
+		}
+		all = append(all, q)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	authors := tx.Bucket([]byte(authorBucket))
+	for _, q := range all {
+		err := authors.Put(indexKey(q.Author, q.ID), nil)
+		if err != nil {
+			return fmt.Errorf("put author index: %s", err)
+		}
+		err = indexQuote(tx, q)
+		if err != nil {
+			return err
+		}
+	}
+	report("index %d quotes", len(all))
+	return nil
+}
+
+// migrateInitRevisions sets the revision of quotes stored before
+// revisions existed to 1.
+func migrateInitRevisions(tx namespace, report func(string, ...interface{})) error {
+	bucket := tx.Bucket([]byte(quoteBucket))
+	unversioned := []*Quote{}
+	err := bucket.ForEach(func(k, v []byte) error {
+		q := &Quote{}
+		err := q.Deserialize(v)
+		if err != nil {
+			return errors.Wrapf(err, "cannot deserialize record %d", btoi(k))
+		}
+		if q.Rev == 0 {
+			unversioned = append(unversioned, q)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, q := range unversioned {
+		q.Rev = 1
+		buffer, err := q.Serialize()
+		if err != nil {
+			return fmt.Errorf("can`t serialize quote: %s", err)
+		}
+		err = bucket.Put(itob(q.ID), buffer)
+		if err != nil {
+			return fmt.Errorf("put data to bucket: %s", err)
+		}
+	}
+	report("set revision of %d quotes", len(unversioned))
+	return nil
+}
+
+// migrateInitHistory adds the current revision of every quote to its
+// history, with a zero time since it is unknown when it was written.
+func migrateInitHistory(tx namespace, report func(string, ...interface{})) error {
+	history := tx.Bucket([]byte(historyBucket))
+	n := 0
+	err := tx.Bucket([]byte(quoteBucket)).ForEach(func(k, v []byte) error {
+		q := &Quote{}
+		err := q.Deserialize(v)
+		if err != nil {
+			return errors.Wrapf(err, "cannot deserialize record %d", btoi(k))
+		}
+		revs, err := history.CreateBucketIfNotExists(k)
+		if err != nil {
+			return err
+		}
+		if revs.Get(itob(q.Rev)) != nil {
+			return nil
+		}
+		v, err = encodeStamped(time.Time{}, q)
+		if err != nil {
+			return err
+		}
+		n++
+		return revs.Put(itob(q.Rev), v)
+	})
+	if err != nil {
+		return err
+	}
+	report("recorded %d revisions", n)
+	return nil
+}
+
+// migrateInitSlots gives every quote a slot in the slot index.
+func migrateInitSlots(tx namespace, report func(string, ...interface{})) error {
+	ids := []uint64{}
+	err := tx.Bucket([]byte(quoteBucket)).ForEach(func(k, v []byte) error {
+		ids = append(ids, btoi(k))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err := addSlot(tx, id)
+		if err != nil {
+			return err
+		}
+	}
+	report("numbered %d quotes", len(ids))
+	return nil
+}
+
+// migrateIndexTexts builds the near-duplicate index. Existing quotes are
+// not normalized, and those that nearly duplicate each other are kept and
+// reported: they can still be edited, but not copied again.
+func migrateIndexTexts(tx namespace, report func(string, ...interface{})) error {
+	n := 0
+	groups := map[string][]uint64{}
+	keys := []string{}
+	err := tx.Bucket([]byte(quoteBucket)).ForEach(func(k, v []byte) error {
+		q := &Quote{}
+		err := q.Deserialize(v)
+		if err != nil {
+			return errors.Wrapf(err, "cannot deserialize record %d", btoi(k))
+		}
+		n++
+		if key := string(dedupKey(q.Text)); key != "" {
+			if groups[key] == nil {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], q.ID)
+		}
+		return indexText(tx, q)
+	})
+	if err != nil {
+		return err
+	}
+	report("indexed %d quotes", n)
+	for _, key := range keys {
+		if ids := groups[key]; len(ids) > 1 {
+			report("quotes %v nearly duplicate each other", ids)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package quotes
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// createLegacyDB writes quotes the way the first version of the store did:
+// keyed by author, without IDs, indexes or schema version.
+func createLegacyDB(t *testing.T, path string, data []Quote) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("Cannot open %s: %v", path, err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte(quoteBucket))
+		if err != nil {
+			return err
+		}
+		for _, q := range data {
+			buffer, err := q.Serialize()
+			if err != nil {
+				return err
+			}
+			err = bucket.Put([]byte(q.Author), buffer)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cannot fill legacy database: %v", err)
+	}
+}
+
+func TestOpen_Migrate(t *testing.T) {
+	path := "testdata/migratedb"
+	createLegacyDB(t, path, []Quote{
+		{Author: "Gopher", Text: "Errors are values."},
+		{Author: "Alfred E. Neuman", Text: "What, me worry?", Source: "MAD Magazine"},
+		{Author: "Rob Pike", Text: "Errors are values!"},
+	})
+	defer func() {
+		// Teardown
+		err := os.Remove(path)
+		if err != nil {
+			t.Errorf("Cannot remove %s", path)
+		}
+	}()
+
+	// A dry run reports the changes but leaves the file alone.
+	reports, err := PlanMigrations(path)
+	if err != nil {
+		t.Fatalf("PlanMigrations() error = %v", err)
+	}
+	want := []MigrationReport{
+		{1, "key quotes by generated ID instead of author", []string{
+			`rekey quote of "Alfred E. Neuman" as 1`,
+			`rekey quote of "Gopher" as 2`,
+			`rekey quote of "Rob Pike" as 3`,
+		}, ""},
+		{2, "build the author and search indexes", []string{"index 3 quotes"}, ""},
+		{3, "start quote revisions at 1", []string{"set revision of 3 quotes"}, ""},
+		{4, "record the current revision of every quote in the history", []string{"recorded 3 revisions"}, ""},
+		{5, "number the quotes for random picks", []string{"numbered 3 quotes"}, ""},
+		{6, "index the normalized texts for duplicate detection", []string{"indexed 3 quotes", "quotes [2 3] nearly duplicate each other"}, ""},
+	}
+	if len(reports) < len(want) || !reflect.DeepEqual(reports[:len(want)], want) {
+		t.Errorf("PlanMigrations() = %#v, want %#v", reports, want)
+	}
+
+	d, err := open(path)
+	if err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	version, err := d.SchemaVersion()
+	if err != nil || version != 0 {
+		t.Errorf("SchemaVersion() after dry run = %d, %v, want 0", version, err)
+	}
+	d.Close()
+
+	// Open migrates for real.
+	d, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer d.Close()
+
+	version, err = d.SchemaVersion()
+	if err != nil || version != migrations[len(migrations)-1].Version {
+		t.Errorf("SchemaVersion() = %d, %v, want %d", version, err, migrations[len(migrations)-1].Version)
+	}
+
+	got, err := d.ListByAuthor("Gopher")
+	if err != nil {
+		t.Fatalf("DB.ListByAuthor() error = %v", err)
+	}
+	wantQuotes := []*Quote{{ID: 2, Rev: 1, Author: "Gopher", Text: "Errors are values."}}
+	if !reflect.DeepEqual(got, wantQuotes) {
+		t.Errorf("DB.ListByAuthor() = %v, want %v", got, wantQuotes)
+	}
+
+	results, err := d.Search("worry", 0)
+	if err != nil || len(results) != 1 || results[0].Quote.ID != 1 {
+		t.Errorf("DB.Search() = %v, %v, want quote 1", results, err)
+	}
+
+	// New quotes continue the sequence.
+	q := &Quote{Author: "Gopher", Text: "Don't panic."}
+	err = d.Create(q)
+	if err != nil || q.ID != 4 {
+		t.Errorf("DB.Create() = %d, %v, want ID 4", q.ID, err)
+	}
+
+	// Quotes that nearly duplicated each other before can be edited as
+	// long as they keep their text, but not copied.
+	err = d.Update(&Quote{ID: 3, Author: "Rob Pike", Text: "Errors are values!", Source: "Go Proverbs"})
+	if err != nil {
+		t.Errorf("DB.Update() of a legacy duplicate keeping its text error = %v", err)
+	}
+	err = d.Create(&Quote{Author: "Rob Pike", Text: "Errors are values."})
+	if !isValidationError(err) {
+		t.Errorf("DB.Create() of a legacy duplicate error = %v, want a ValidationError", err)
+	}
+
+	// Migrating again is a no-op.
+	reports, err = d.migrate(true)
+	if err != nil || len(reports) != 0 {
+		t.Errorf("migrate() on current schema = %v, %v, want no reports", reports, err)
+	}
+}
@@ -0,0 +1,247 @@
+package quotes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultPageSize is used by ListPage when no limit is given.
+	DefaultPageSize = 100
+	// MaxPageSize caps the limit a caller may ask for.
+	MaxPageSize = 1000
+)
+
+// ErrInvalidCursor is returned by ListPage for a cursor it did not issue
+// for the same kind of listing.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions narrows down and pages the output of ListPage.
+type ListOptions struct {
+	// Limit is the maximum number of quotes per page.
+	Limit int
+	// Cursor continues a listing; it is the NextCursor of the previous page.
+	Cursor string
+	// Author only lists quotes of exactly this author.
+	Author string
+	// AuthorPrefix only lists quotes whose author starts with this prefix.
+	// It is ignored if Author is set.
+	AuthorPrefix string
+	// Source only lists quotes with exactly this source.
+	Source string
+	// Tags only lists quotes tagged with all of these tags, or with any
+	// of them if AnyTag is set.
+	Tags   []string
+	AnyTag bool
+}
+
+// Page is one chunk of a listing.
+type Page struct {
+	Quotes []*Quote `json:"quotes"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ListPage lists one page of quotes. Without an author filter the quotes
+// are ordered by ID; otherwise they come in author order from the author
+// index, so neither case needs to load the whole bucket. Quotes that must
+// have all of several tags are found through the index of the first one.
+// Pages are served from the cache until any quote changes.
+func (d *DB) ListPage(opts ListOptions) (*Page, error) {
+	opts.Tags = normalizeTags(opts.Tags)
+	v, err := d.cached(cacheKey{kind: "page", args: pageCacheArgs(opts)}, func() (interface{}, error) {
+		return d.listPage(opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	page := *v.(*Page)
+	page.Quotes = cloneQuotes(page.Quotes)
+	return &page, nil
+}
+
+func (d *DB) listPage(opts ListOptions) (*Page, error) {
+	limit := pageLimit(opts)
+	after, err := decodeCursor(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Quotes: []*Quote{}}
+	err = d.view("ListPage", func(tx namespace) error {
+		quotes := tx.Bucket([]byte(quoteBucket))
+
+		// Pick the bucket to walk and a function that turns its
+		// keys into quote IDs.
+		var c *bolt.Cursor
+		var prefix []byte
+		var keyID func(k []byte) uint64
+		seek := after
+		switch {
+		case byAuthor(opts):
+			c = tx.Bucket([]byte(authorBucket)).Cursor()
+			prefix = authorPrefix(opts)
+			keyID = func(k []byte) uint64 { return btoi(k[len(k)-8:]) }
+		case len(opts.Tags) > 0 && !opts.AnyTag:
+			c = tx.Bucket([]byte(tagBucket)).Cursor()
+			prefix = indexPrefix(opts.Tags[0])
+			keyID = func(k []byte) uint64 { return btoi(k[len(k)-8:]) }
+			if after != nil {
+				seek = indexKey(opts.Tags[0], btoi(after))
+			}
+		default:
+			c = quotes.Cursor()
+			keyID = btoi
+		}
+
+		var k []byte
+		if seek != nil {
+			k, _ = c.Seek(seek)
+			if bytes.Equal(k, seek) {
+				k, _ = c.Next()
+			}
+		} else {
+			k, _ = c.Seek(prefix)
+		}
+
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			id := keyID(k)
+			v := quotes.Get(itob(id))
+			if v == nil {
+				return errors.Errorf("ListPage: dangling index entry %d", id)
+			}
+			q := &Quote{}
+			err := q.Deserialize(v)
+			if err != nil {
+				return errors.Wrapf(err, "ListPage: cannot deserialize record %d", id)
+			}
+			if !matchesFilter(opts, q) {
+				continue
+			}
+			// Only a page with more matches after it gets a cursor.
+			if len(page.Quotes) == limit {
+				last := page.Quotes[len(page.Quotes)-1]
+				page.NextCursor = encodeCursor(opts, last)
+				return nil
+			}
+			page.Quotes = append(page.Quotes, q)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "ListPage: DB.View() failed")
+	}
+	return page, nil
+}
+
+// ListPage lists one page of quotes from s. Stores that are not a Pager
+// are listed completely and paged in memory, with the same ordering and
+// cursors as DB.ListPage.
+func ListPage(s QuoteStore, opts ListOptions) (*Page, error) {
+	if p, ok := s.(Pager); ok {
+		return p.ListPage(opts)
+	}
+
+	opts.Tags = normalizeTags(opts.Tags)
+	limit := pageLimit(opts)
+	after, err := decodeCursor(opts)
+	if err != nil {
+		return nil, err
+	}
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := authorPrefix(opts)
+	matches := []*Quote{}
+	for _, q := range all {
+		k := lastKey(opts, q)
+		if bytes.HasPrefix(k, prefix) && bytes.Compare(k, after) > 0 && matchesFilter(opts, q) {
+			matches = append(matches, q)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return bytes.Compare(lastKey(opts, matches[i]), lastKey(opts, matches[j])) < 0
+	})
+
+	page := &Page{Quotes: matches}
+	if len(matches) > limit {
+		page.Quotes = matches[:limit]
+		page.NextCursor = encodeCursor(opts, page.Quotes[limit-1])
+	}
+	return page, nil
+}
+
+// matchesFilter reports whether q passes the source and tag filters of
+// opts.
+func matchesFilter(opts ListOptions, q *Quote) bool {
+	return (opts.Source == "" || q.Source == opts.Source) && matchesTags(q, opts.Tags, opts.AnyTag)
+}
+
+// byAuthor reports whether a listing walks the author index.
+func byAuthor(opts ListOptions) bool {
+	return opts.Author != "" || opts.AuthorPrefix != ""
+}
+
+// authorPrefix returns the prefix of the author index keys a listing
+// is restricted to.
+func authorPrefix(opts ListOptions) []byte {
+	if opts.Author != "" {
+		return indexPrefix(opts.Author)
+	}
+	return []byte(opts.AuthorPrefix)
+}
+
+func pageLimit(opts ListOptions) int {
+	if opts.Limit <= 0 {
+		return DefaultPageSize
+	}
+	if opts.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return opts.Limit
+}
+
+// decodeCursor returns the key a listing continues after, or nil for the
+// first page. It checks that the cursor fits the kind of listing.
+func decodeCursor(opts ListOptions) ([]byte, error) {
+	if opts.Cursor == "" {
+		return nil, nil
+	}
+	after, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if byAuthor(opts) {
+		if !bytes.HasPrefix(after, authorPrefix(opts)) || !isIndexKey(after) {
+			return nil, ErrInvalidCursor
+		}
+	} else if len(after) != 8 {
+		return nil, ErrInvalidCursor
+	}
+	return after, nil
+}
+
+// encodeCursor returns the cursor of a page that ends with q.
+func encodeCursor(opts ListOptions, q *Quote) string {
+	return base64.RawURLEncoding.EncodeToString(lastKey(opts, q))
+}
+
+// lastKey returns the key a listing with opts continues after,
+// given the last quote of a page.
+func lastKey(opts ListOptions, q *Quote) []byte {
+	if byAuthor(opts) {
+		return indexKey(q.Author, q.ID)
+	}
+	return itob(q.ID)
+}
+
+// isIndexKey reports whether k looks like a key built by indexKey.
+func isIndexKey(k []byte) bool {
+	return len(k) >= 9 && k[len(k)-9] == 0
+}
@@ -0,0 +1,100 @@
+package quotes
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDB_ListPage(t *testing.T) {
+	path := "testdata/listpagedb"
+
+	// Setup
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+
+	defer func() {
+		// Teardown
+		err = d.Close()
+		if err != nil {
+			t.Errorf("Cannot close %s", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			t.Errorf("Cannot remove %s", path)
+		}
+	}()
+
+	data := []*Quote{
+		{Author: "Rob Pike", Text: "Clear is better than clever.", Source: "Go Proverbs"},
+		{Author: "Robert Griesemer", Text: "Go is a language for the 21st century."},
+		{Author: "Gopher", Text: "Errors are values.", Source: "Go Proverbs"},
+		{Author: "Rob Pike", Text: "Concurrency is not parallelism."},
+		{Author: "Rob Pike", Text: "Don't panic.", Source: "Go Proverbs"},
+	}
+	for _, q := range data {
+		err := d.Create(q)
+		if err != nil {
+			t.Fatalf("Cannot fill test database: " + err.Error())
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want [][]uint64
+	}{
+		{"All", ListOptions{}, [][]uint64{{1, 2, 3, 4, 5}}},
+		{"Paged", ListOptions{Limit: 2}, [][]uint64{{1, 2}, {3, 4}, {5}}},
+		{"ExactPages", ListOptions{Limit: 5}, [][]uint64{{1, 2, 3, 4, 5}}},
+		{"Author", ListOptions{Limit: 2, Author: "Rob Pike"}, [][]uint64{{1, 4}, {5}}},
+		// The author index is sorted by author first, then by ID.
+		{"AuthorPrefix", ListOptions{Limit: 3, AuthorPrefix: "Rob"}, [][]uint64{{1, 4, 5}, {2}}},
+		{"Source", ListOptions{Limit: 2, Source: "Go Proverbs"}, [][]uint64{{1, 3}, {5}}},
+		{"AuthorAndSource", ListOptions{AuthorPrefix: "Rob", Source: "Go Proverbs"}, [][]uint64{{1, 5}}},
+		{"NoMatch", ListOptions{AuthorPrefix: "Nobody"}, [][]uint64{{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := [][]uint64{}
+			opts := tt.opts
+			for {
+				page, err := d.ListPage(opts)
+				if err != nil {
+					t.Errorf("DB.ListPage() error = %v", err)
+					return
+				}
+				ids := []uint64{}
+				for _, q := range page.Quotes {
+					ids = append(ids, q.ID)
+				}
+				got = append(got, ids)
+				if page.NextCursor == "" || len(got) > len(data) {
+					break
+				}
+				opts.Cursor = page.NextCursor
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DB.ListPage() pages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("InvalidCursor", func(t *testing.T) {
+		page, err := d.ListPage(ListOptions{Limit: 1})
+		if err != nil {
+			t.Fatalf("DB.ListPage() error = %v", err)
+		}
+		for _, opts := range []ListOptions{
+			{Cursor: "!!"},
+			{Cursor: page.NextCursor, AuthorPrefix: "Rob"},
+		} {
+			_, err = d.ListPage(opts)
+			if err != ErrInvalidCursor {
+				t.Errorf("DB.ListPage(%#v) error = %v, want %v", opts, err, ErrInvalidCursor)
+			}
+		}
+	})
+}
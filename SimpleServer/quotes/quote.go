@@ -1,41 +1,36 @@
 package quotes
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
 
 	"github.com/pkg/errors"
 )
 
 // Quote represents a quote, inlcuding its author and an optional source. The ID is a unique key.
+// Rev is the revision of the stored quote; it starts at 1 and grows with every update.
+// Tags are stored lowercased, sorted and without duplicates.
 type Quote struct {
-	Author string `json:"author"`
-	Text   string `json:"text"`
-	Source string `json:"source,omitempty"`
+	ID     uint64   `json:"id"`
+	Rev    uint64   `json:"rev"`
+	Author string   `json:"author"`
+	Text   string   `json:"text"`
+	Source string   `json:"source,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
 }
 
-// Serialize returns a gob encoding of quote q.
+// Serialize returns an encoding of quote q by DefaultCodec, marked
+// as such.
 func (q Quote) Serialize() ([]byte, error) {
-	var b bytes.Buffer
-
-	enc := gob.NewEncoder(&b)
-	err := enc.Encode(q)
-	if err != nil {
-		return nil, err
-	}
-
-	return b.Bytes(), nil
+	return Encode(DefaultCodec, &q)
 }
 
-// Deserialize takes a byte slice that contains a gob-encoded quote
-// and turns it back into a Quote.
+// Deserialize takes a byte slice that contains an encoded quote, by any
+// registered codec or a gob record of older versions, and turns it back
+// into a Quote.
 func (q *Quote) Deserialize(b []byte) error {
-	buf := bytes.NewBuffer(b)
-	dec := gob.NewDecoder(buf)
-	err := dec.Decode(&q)
+	err := Decode(b, q)
 	if err != nil {
-		return errors.Wrapf(err, "Deserialize: decoding failed for %s", b)
+		return errors.Wrapf(err, "Deserialize: decoding failed for %x", b)
 	}
 	return nil
 }
@@ -10,7 +10,7 @@ func TestQuote_SerializeDeserialize(t *testing.T) {
 		name  string
 		quote Quote
 	}{
-		{"01", Quote{Author: "Test", Text: "This is a test", Source: "unknown"}},
+		{"01", Quote{ID: 1, Author: "Test", Text: "This is a test", Source: "unknown"}},
 		{"02", Quote{Author: "Test", Text: "This is a test", Source: ""}},
 	}
 	for _, tt := range tests {
@@ -40,10 +40,10 @@ func TestQuote_String(t *testing.T) {
 		want  string
 	}{
 		{
-			"StringWithSource", Quote{"Author", "Text", "Source"}, "\"Text\"\n\n(Author, Source)\n",
+			"StringWithSource", Quote{Author: "Author", Text: "Text", Source: "Source"}, "\"Text\"\n\n(Author, Source)\n",
 		},
 		{
-			"StringWithoutSource", Quote{"Author", "Text", ""}, "\"Text\"\n\n(Author)\n",
+			"StringWithoutSource", Quote{Author: "Author", Text: "Text"}, "\"Text\"\n\n(Author)\n",
 		},
 	}
 	for _, tt := range tests {
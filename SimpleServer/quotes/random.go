@@ -0,0 +1,186 @@
+package quotes
+
+import (
+	"bytes"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// slotBucket numbers the quotes densely from 0, so that a random quote can
+// be picked without walking the quote bucket. Slot s maps to an ID under
+// 'p'+itob(s), an ID to its slot under 'i'+itob(id). The bucket sequence
+// is the number of slots.
+const slotBucket = "slots"
+
+// PickFilter restricts the quotes Pick chooses from.
+type PickFilter struct {
+	Author string
+	// Tags are required all, or any of them if AnyTag is set.
+	Tags   []string
+	AnyTag bool
+}
+
+// Picker is implemented by stores that can pick one of the quotes matching
+// a filter without listing all quotes.
+type Picker interface {
+	// Pick returns quote number n modulo the number of quotes matching
+	// filter, in an order that is fixed as long as the quotes do not
+	// change. It fails with ErrNotFound if no quote matches.
+	Pick(n uint64, filter PickFilter) (*Quote, error)
+}
+
+// Pick implements Picker. Without a filter it takes one read of the slot
+// index; otherwise it walks the index entries of the author, or of the
+// first tag if all tags are required, or the slot index, and checks the
+// tags of each candidate in the tag index.
+func (d *DB) Pick(n uint64, filter PickFilter) (*Quote, error) {
+	filter.Tags = normalizeTags(filter.Tags)
+	var q *Quote
+	err := d.view("Pick", func(tx namespace) error {
+		var id uint64
+		if filter.Author == "" && len(filter.Tags) == 0 {
+			slots := tx.Bucket([]byte(slotBucket))
+			count := slots.Sequence()
+			if count == 0 {
+				return ErrNotFound
+			}
+			v := slots.Get(slotKey('p', n%count))
+			if v == nil {
+				return errors.Errorf("missing slot %d", n%count)
+			}
+			id = btoi(v)
+		} else {
+			var c *bolt.Cursor
+			var prefix []byte
+			switch {
+			case filter.Author != "":
+				c = tx.Bucket([]byte(authorBucket)).Cursor()
+				prefix = indexPrefix(filter.Author)
+			case !filter.AnyTag:
+				c = tx.Bucket([]byte(tagBucket)).Cursor()
+				prefix = indexPrefix(filter.Tags[0])
+			default:
+				c = tx.Bucket([]byte(slotBucket)).Cursor()
+				prefix = []byte{'i'}
+			}
+			ids := []uint64{}
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				id := btoi(k[len(k)-8:])
+				if hasTags(tx, id, filter.Tags, filter.AnyTag) {
+					ids = append(ids, id)
+				}
+			}
+			if len(ids) == 0 {
+				return ErrNotFound
+			}
+			id = ids[n%uint64(len(ids))]
+		}
+
+		var err error
+		q, err = getQuote(tx, id)
+		if err != nil {
+			return err
+		}
+		if q == nil {
+			return errors.Errorf("dangling index entry %d", id)
+		}
+		return nil
+	})
+	if err == ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Pick: DB.View() failed")
+	}
+	return q, nil
+}
+
+// Pick picks one of the quotes of s matching filter, see Picker. Stores
+// that are not a Picker are listed completely.
+func Pick(s QuoteStore, n uint64, filter PickFilter) (*Quote, error) {
+	if p, ok := s.(Picker); ok {
+		return p.Pick(n, filter)
+	}
+	matches, err := matching(s, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, ErrNotFound
+	}
+	return matches[n%uint64(len(matches))], nil
+}
+
+// matching lists the quotes of s that match filter.
+func matching(s QuoteStore, filter PickFilter) ([]*Quote, error) {
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	tags := normalizeTags(filter.Tags)
+	matches := []*Quote{}
+	for _, q := range all {
+		if (filter.Author == "" || q.Author == filter.Author) && matchesTags(q, tags, filter.AnyTag) {
+			matches = append(matches, q)
+		}
+	}
+	return matches, nil
+}
+
+// addSlot gives the quote with the given ID the next free slot.
+func addSlot(tx namespace, id uint64) error {
+	slots := tx.Bucket([]byte(slotBucket))
+	slot := slots.Sequence()
+	err := slots.Put(slotKey('p', slot), itob(id))
+	if err != nil {
+		return err
+	}
+	err = slots.Put(slotKey('i', id), itob(slot))
+	if err != nil {
+		return err
+	}
+	return slots.SetSequence(slot + 1)
+}
+
+// removeSlot frees the slot of the quote with the given ID and moves the
+// quote in the last slot into it, so that the slots stay dense.
+func removeSlot(tx namespace, id uint64) error {
+	slots := tx.Bucket([]byte(slotBucket))
+	v := slots.Get(slotKey('i', id))
+	if v == nil {
+		return errors.Errorf("quote %d has no slot", id)
+	}
+	slot := btoi(v)
+	last := slots.Sequence() - 1
+
+	if slot != last {
+		moved := slots.Get(slotKey('p', last))
+		if moved == nil {
+			return errors.Errorf("missing slot %d", last)
+		}
+		movedID := btoi(moved)
+		err := slots.Put(slotKey('p', slot), itob(movedID))
+		if err != nil {
+			return err
+		}
+		err = slots.Put(slotKey('i', movedID), itob(slot))
+		if err != nil {
+			return err
+		}
+	}
+	err := slots.Delete(slotKey('p', last))
+	if err != nil {
+		return err
+	}
+	err = slots.Delete(slotKey('i', id))
+	if err != nil {
+		return err
+	}
+	return slots.SetSequence(last)
+}
+
+// slotKey returns the key of slot or ID n in the slot bucket.
+func slotKey(kind byte, n uint64) []byte {
+	return append([]byte{kind}, itob(n)...)
+}
@@ -0,0 +1,250 @@
+package quotes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+const searchBucket = "search"
+
+// docCountKey holds the number of indexed quotes. Tokens are never empty,
+// so it cannot collide with a posting key.
+var docCountKey = []byte{0}
+
+// Field weights used when indexing. A match in the author counts more
+// than a match in the text.
+const (
+	textWeight   = 1
+	authorWeight = 2
+	sourceWeight = 1
+)
+
+// phraseBoost is added to the score of a quote whose text contains
+// all query words in the same order.
+const phraseBoost = 2.0
+
+// SearchResult is a single hit of a full-text search.
+type SearchResult struct {
+	Quote *Quote  `json:"quote"`
+	Score float64 `json:"score"`
+	// Highlights maps the fields that matched ("text", "author", "source")
+	// to their HTML-escaped content with matches wrapped in <mark> tags.
+	Highlights map[string]string `json:"highlights"`
+}
+
+// Search looks up quotes whose text, author or source contain any of the
+// words in query. Results are ranked by a tf-idf score, best first, and at
+// most limit results are returned if limit > 0.
+func (d *DB) Search(query string, limit int) ([]*SearchResult, error) {
+	terms := uniqueTokens(query)
+	if len(terms) == 0 {
+		return []*SearchResult{}, nil
+	}
+
+	results := []*SearchResult{}
+	err := d.view("Search", func(tx namespace) error {
+		index := tx.Bucket([]byte(searchBucket))
+		docs := float64(getCount(index, docCountKey))
+
+		scores := map[uint64]float64{}
+		matched := map[uint64]int{}
+		c := index.Cursor()
+		for _, term := range terms {
+			prefix := indexPrefix(term)
+			postings := map[uint64]uint32{}
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				postings[btoi(k[len(prefix):])] = binary.BigEndian.Uint32(v)
+			}
+			idf := math.Log(1 + docs/float64(len(postings)))
+			for id, tf := range postings {
+				scores[id] += float64(tf) * idf
+				matched[id]++
+			}
+		}
+
+		quotes := tx.Bucket([]byte(quoteBucket))
+		phrase := strings.Join(tokenize(query), " ")
+		for id, score := range scores {
+			v := quotes.Get(itob(id))
+			if v == nil {
+				return errors.Errorf("Search: dangling index entry %d", id)
+			}
+			q := &Quote{}
+			err := q.Deserialize(v)
+			if err != nil {
+				return errors.Wrapf(err, "Search: cannot deserialize record %d", id)
+			}
+			// Quotes matching every term rank above partial matches.
+			score *= float64(matched[id]) / float64(len(terms))
+			if len(terms) > 1 && strings.Contains(strings.Join(tokenize(q.Text), " "), phrase) {
+				score += phraseBoost
+			}
+			results = append(results, &SearchResult{
+				Quote:      q,
+				Score:      score,
+				Highlights: highlights(q, terms),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Search: DB.View() failed")
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Quote.ID < results[j].Quote.ID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// indexQuote adds the postings of q to the search index.
+func indexQuote(tx namespace, q *Quote) error {
+	index := tx.Bucket([]byte(searchBucket))
+	for term, tf := range termFrequencies(q) {
+		v := make([]byte, 4)
+		binary.BigEndian.PutUint32(v, tf)
+		err := index.Put(indexKey(term, q.ID), v)
+		if err != nil {
+			return fmt.Errorf("put search index: %s", err)
+		}
+	}
+	return addCount(index, docCountKey, 1)
+}
+
+// unindexQuote removes the postings of q from the search index.
+func unindexQuote(tx namespace, q *Quote) error {
+	index := tx.Bucket([]byte(searchBucket))
+	for term := range termFrequencies(q) {
+		err := index.Delete(indexKey(term, q.ID))
+		if err != nil {
+			return fmt.Errorf("delete search index: %s", err)
+		}
+	}
+	return addCount(index, docCountKey, -1)
+}
+
+// termFrequencies returns the weighted frequency of every token of q.
+func termFrequencies(q *Quote) map[string]uint32 {
+	tf := map[string]uint32{}
+	for _, t := range tokenize(q.Text) {
+		tf[t] += textWeight
+	}
+	for _, t := range tokenize(q.Author) {
+		tf[t] += authorWeight
+	}
+	for _, t := range tokenize(q.Source) {
+		tf[t] += sourceWeight
+	}
+	return tf
+}
+
+// tokenize splits s into lower-case words of letters and digits.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), isSeparator)
+}
+
+// uniqueTokens is tokenize without duplicates, in order of appearance.
+func uniqueTokens(s string) []string {
+	seen := map[string]bool{}
+	terms := []string{}
+	for _, t := range tokenize(s) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// highlights returns the fields of q that contain one of terms, with the
+// matching words wrapped in <mark> tags.
+func highlights(q *Quote, terms []string) map[string]string {
+	h := map[string]string{}
+	fields := []struct{ name, value string }{
+		{"text", q.Text},
+		{"author", q.Author},
+		{"source", q.Source},
+	}
+	for _, f := range fields {
+		if s, ok := highlight(f.value, terms); ok {
+			h[f.name] = s
+		}
+	}
+	return h
+}
+
+// highlight wraps every word of s that is one of terms in <mark> tags and
+// escapes the rest for HTML. It reports whether anything matched.
+func highlight(s string, terms []string) (string, bool) {
+	want := map[string]bool{}
+	for _, t := range terms {
+		want[t] = true
+	}
+
+	var b strings.Builder
+	found := false
+	start := -1
+	flush := func(end int) {
+		word := s[start:end]
+		if want[strings.ToLower(word)] {
+			found = true
+			b.WriteString("<mark>" + html.EscapeString(word) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		start = -1
+	}
+	for i, r := range s {
+		if isSeparator(r) {
+			if start >= 0 {
+				flush(i)
+			}
+			b.WriteString(html.EscapeString(string(r)))
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		flush(len(s))
+	}
+	return b.String(), found
+}
+
+// getCount reads a counter stored as 8-byte big endian value.
+func getCount(b *bolt.Bucket, key []byte) uint64 {
+	v := b.Get(key)
+	if v == nil {
+		return 0
+	}
+	return btoi(v)
+}
+
+// addCount adds delta to the counter stored under key.
+func addCount(b *bolt.Bucket, key []byte, delta int64) error {
+	n := int64(getCount(b, key)) + delta
+	if n < 0 {
+		n = 0
+	}
+	return b.Put(key, itob(uint64(n)))
+}
@@ -0,0 +1,109 @@
+package quotes
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDB_Search(t *testing.T) {
+	path := "testdata/searchdb"
+
+	// Setup
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+
+	defer func() {
+		// Teardown
+		err = d.Close()
+		if err != nil {
+			t.Errorf("Cannot close %s", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			t.Errorf("Cannot remove %s", path)
+		}
+	}()
+
+	data := []*Quote{
+		{Author: "Rob Pike", Text: "Clear is better than clever.", Source: "Go Proverbs"},
+		{Author: "Rob Pike", Text: "A little copying is better than a little dependency.", Source: "Go Proverbs"},
+		{Author: "Gopher", Text: "Better late than never."},
+		{Author: "Gopher", Text: "Errors are values."},
+	}
+	for _, q := range data {
+		err := d.Create(q)
+		if err != nil {
+			t.Fatalf("Cannot fill test database: " + err.Error())
+		}
+	}
+
+	// The first quote goes away, the last one no longer talks about errors.
+	err = d.Delete(data[0].ID)
+	if err != nil {
+		t.Fatalf("DB.Delete() error = %v", err)
+	}
+	changed := *data[3]
+	changed.Text = "Don't panic."
+	err = d.Update(&changed)
+	if err != nil {
+		t.Fatalf("DB.Update() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []uint64
+	}{
+		{"SingleTerm", "better", []uint64{2, 3}},
+		{"PhraseRanksFirst", "late than never", []uint64{3, 2}},
+		{"Author", "PIKE", []uint64{2}},
+		{"Source", "proverbs", []uint64{2}},
+		{"Deleted", "clever", []uint64{}},
+		{"Updated", "errors", []uint64{}},
+		{"UpdatedText", "panic", []uint64{4}},
+		{"NoTerms", "  ?! ", []uint64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.Search(tt.query, 0)
+			if err != nil {
+				t.Errorf("DB.Search() error = %v", err)
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("DB.Search(%q) returned %d results, want %d", tt.query, len(got), len(tt.want))
+				return
+			}
+			for i, r := range got {
+				if r.Quote.ID != tt.want[i] {
+					t.Errorf("DB.Search(%q)[%d] = %d, want %d", tt.query, i, r.Quote.ID, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		terms []string
+		want  string
+		found bool
+	}{
+		{"Match", "Clear is better than clever.", []string{"clever"}, "Clear is better than <mark>clever</mark>.", true},
+		{"CaseInsensitive", "Better late", []string{"better"}, "<mark>Better</mark> late", true},
+		{"WholeWordsOnly", "cleverly", []string{"clever"}, "cleverly", false},
+		{"Escaped", "<b> & Go", []string{"go"}, "&lt;b&gt; &amp; <mark>Go</mark>", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := highlight(tt.s, tt.terms)
+			if got != tt.want || found != tt.found {
+				t.Errorf("highlight() = %q, %t, want %q, %t", got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
@@ -0,0 +1,695 @@
+package quotes
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS quotes (
+	id     INTEGER PRIMARY KEY AUTOINCREMENT,
+	author TEXT NOT NULL,
+	text   TEXT NOT NULL,
+	source TEXT NOT NULL DEFAULT '',
+	rev    INTEGER NOT NULL DEFAULT 1,
+	tags   TEXT NOT NULL DEFAULT '[]',
+	dedup  BLOB
+);
+CREATE INDEX IF NOT EXISTS quotes_author ON quotes (author, id);
+CREATE TABLE IF NOT EXISTS trash (
+	id         INTEGER PRIMARY KEY,
+	author     TEXT NOT NULL,
+	text       TEXT NOT NULL,
+	source     TEXT NOT NULL DEFAULT '',
+	rev        INTEGER NOT NULL,
+	tags       TEXT NOT NULL DEFAULT '[]',
+	deleted_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS history (
+	id     INTEGER NOT NULL,
+	rev    INTEGER NOT NULL,
+	author TEXT NOT NULL,
+	text   TEXT NOT NULL,
+	source TEXT NOT NULL DEFAULT '',
+	tags   TEXT NOT NULL DEFAULT '[]',
+	time   INTEGER NOT NULL,
+	actor  INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (id, rev)
+);
+CREATE TABLE IF NOT EXISTS pins (
+	day TEXT NOT NULL,
+	key TEXT NOT NULL,
+	id  INTEGER NOT NULL,
+	PRIMARY KEY (day, key)
+);
+`
+
+// sqliteColumns are the columns of a quote, in the order they are scanned.
+const sqliteColumns = "id, rev, author, text, source, tags"
+
+// sqliteUpgrades add columns to tables created by older versions.
+var sqliteUpgrades = []struct{ table, column, statement string }{
+	{"quotes", "rev", "ALTER TABLE quotes ADD COLUMN rev INTEGER NOT NULL DEFAULT 1"},
+	{"quotes", "tags", "ALTER TABLE quotes ADD COLUMN tags TEXT NOT NULL DEFAULT '[]'"},
+	{"trash", "tags", "ALTER TABLE trash ADD COLUMN tags TEXT NOT NULL DEFAULT '[]'"},
+	{"history", "tags", "ALTER TABLE history ADD COLUMN tags TEXT NOT NULL DEFAULT '[]'"},
+	{"quotes", "dedup", "ALTER TABLE quotes ADD COLUMN dedup BLOB"},
+	{"history", "actor", "ALTER TABLE history ADD COLUMN actor INTEGER NOT NULL DEFAULT 0"},
+}
+
+// SQLiteStore keeps quotes in the "quotes" table of an SQLite file, so that
+// the data can be queried with plain SQL for reporting.
+type SQLiteStore struct {
+	db *sql.DB
+	// ctx is the context of the calls, see WithContext.
+	ctx context.Context
+}
+
+// OpenSQLite opens or creates the SQLite file at path.
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenSQLite: cannot open DB file "+path)
+	}
+	// SQLite allows one writer at a time; serialize in the pool instead
+	// of failing with "database is locked".
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "OpenSQLite: cannot create schema")
+	}
+	for _, u := range sqliteUpgrades {
+		var n int
+		err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", u.table, u.column).Scan(&n)
+		if err == nil && n == 0 {
+			_, err = db.Exec(u.statement)
+		}
+		if err != nil {
+			db.Close()
+			return nil, errors.Wrap(err, "OpenSQLite: cannot upgrade schema")
+		}
+	}
+	// The index needs the dedup column, which older files only have
+	// after the upgrade.
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS quotes_dedup ON quotes (dedup)")
+	if err == nil {
+		err = sqliteIndexTexts(db)
+	}
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "OpenSQLite: cannot index texts")
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// sqliteIndexTexts fills in the dedup column of quotes written before it
+// existed.
+func sqliteIndexTexts(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, text FROM quotes WHERE dedup IS NULL")
+	if err != nil {
+		return err
+	}
+	keys := map[uint64][]byte{}
+	for rows.Next() {
+		var id uint64
+		var text string
+		if err := rows.Scan(&id, &text); err != nil {
+			rows.Close()
+			return err
+		}
+		keys[id] = dedupKey(text)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, key := range keys {
+		if _, err := db.Exec("UPDATE quotes SET dedup = ? WHERE id = ?", key, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqliteFindDuplicate returns the ID of a quote other than q whose text
+// nearly duplicates that of q, or 0.
+func sqliteFindDuplicate(tx *sql.Tx, q *Quote) (uint64, error) {
+	if dedupKey(q.Text) == nil {
+		return 0, nil
+	}
+	var id uint64
+	err := tx.QueryRow("SELECT id FROM quotes WHERE dedup = ? AND id != ? LIMIT 1", dedupKey(q.Text), q.ID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// WithContext returns a copy of s whose changes are made with the API key
+// in ctx, see WithActor. The copy shares the database with s and needs no
+// closing.
+func (s *SQLiteStore) WithContext(ctx context.Context) QuoteStore {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+func (s *SQLiteStore) Close() error {
+	err := s.db.Close()
+	if err != nil {
+		return errors.Wrap(err, "Close: cannot close database")
+	}
+	return nil
+}
+
+// Create inserts q at revision 1 and sets its ID to the generated row ID.
+func (s *SQLiteStore) Create(q *Quote) error {
+	err := prepare(q)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Create: cannot begin transaction")
+	}
+	defer tx.Rollback()
+
+	q.ID = 0
+	dup, err := sqliteFindDuplicate(tx, q)
+	if err != nil {
+		return errors.Wrap(err, "Create: select failed")
+	}
+	if dup != 0 {
+		return duplicateError(dup)
+	}
+	res, err := tx.Exec("INSERT INTO quotes (author, text, source, tags, rev, dedup) VALUES (?, ?, ?, ?, 1, ?)",
+		q.Author, q.Text, q.Source, tagList(q.Tags), dedupKey(q.Text))
+	if err != nil {
+		return errors.Wrap(err, "Create: insert failed")
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return errors.Wrap(err, "Create: no row ID")
+	}
+	err = sqliteSaveRevision(tx, uint64(id), actorOf(s.ctx))
+	if err != nil {
+		return errors.Wrap(err, "Create")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Create: commit failed")
+	}
+	q.ID = uint64(id)
+	q.Rev = 1
+	return nil
+}
+
+// Get returns the quote with the given ID.
+func (s *SQLiteStore) Get(id uint64) (*Quote, error) {
+	q := &Quote{}
+	err := s.db.QueryRow("SELECT "+sqliteColumns+" FROM quotes WHERE id = ?", id).
+		Scan(&q.ID, &q.Rev, &q.Author, &q.Text, &q.Source, (*tagList)(&q.Tags))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Get: select failed")
+	}
+	return q, nil
+}
+
+// Update replaces the quote with ID q.ID if it exists and bumps its
+// revision. A non-zero q.Rev must match the stored revision.
+func (s *SQLiteStore) Update(q *Quote) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Update: cannot begin transaction")
+	}
+	defer tx.Rollback()
+
+	var rev uint64
+	old := &Quote{}
+	err = tx.QueryRow("SELECT rev, text FROM quotes WHERE id = ?", q.ID).Scan(&rev, &old.Text)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return errors.Wrap(err, "Update: select failed")
+	}
+	if q.Rev != 0 && q.Rev != rev {
+		return ErrRevisionMismatch
+	}
+	err = prepare(q)
+	if err != nil {
+		return err
+	}
+	dup, err := sqliteFindDuplicate(tx, q)
+	if err != nil {
+		return errors.Wrap(err, "Update: select failed")
+	}
+	if dup != 0 && !keepsText(q, old) {
+		return duplicateError(dup)
+	}
+
+	// The previous revision is already in the history unless it was
+	// written before history was kept.
+	_, err = tx.Exec(`INSERT OR IGNORE INTO history (id, rev, author, text, source, tags, time)
+		SELECT id, rev, author, text, source, tags, 0 FROM quotes WHERE id = ?`, q.ID)
+	if err != nil {
+		return errors.Wrap(err, "Update: cannot save previous revision")
+	}
+	_, err = tx.Exec("UPDATE quotes SET author = ?, text = ?, source = ?, tags = ?, rev = ?, dedup = ? WHERE id = ?",
+		q.Author, q.Text, q.Source, tagList(q.Tags), rev+1, dedupKey(q.Text), q.ID)
+	if err != nil {
+		return errors.Wrap(err, "Update: update failed")
+	}
+	err = sqliteSaveRevision(tx, q.ID, actorOf(s.ctx))
+	if err != nil {
+		return errors.Wrap(err, "Update")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Update: commit failed")
+	}
+	q.Rev = rev + 1
+	return nil
+}
+
+// Delete moves the quote with the given ID into the trash table.
+func (s *SQLiteStore) Delete(id uint64) error {
+	ok, err := s.trashQuote(id, 0)
+	if err != nil {
+		return errors.Wrap(err, "Delete")
+	}
+	if !ok {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteIfMatch moves the quote with the given ID into the trash table if
+// it is at revision rev.
+func (s *SQLiteStore) DeleteIfMatch(id, rev uint64) error {
+	ok, err := s.trashQuote(id, rev)
+	if err != nil {
+		return errors.Wrap(err, "DeleteIfMatch")
+	}
+	if !ok {
+		return ErrRevisionMismatch
+	}
+	return nil
+}
+
+// trashQuote moves the quote with the given ID, at revision rev unless rev
+// is 0, into the trash table. It reports whether there was such a quote.
+func (s *SQLiteStore) trashQuote(id, rev uint64) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "cannot begin transaction")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT OR REPLACE INTO trash (id, author, text, source, tags, rev, deleted_at)
+		SELECT id, author, text, source, tags, rev, ? FROM quotes WHERE id = ? AND (? = 0 OR rev = ?)`,
+		time.Now().UnixNano(), id, rev, rev)
+	if err != nil {
+		return false, errors.Wrap(err, "insert failed")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "no row count")
+	}
+	if n == 0 {
+		return false, nil
+	}
+	_, err = tx.Exec("DELETE FROM quotes WHERE id = ?", id)
+	if err != nil {
+		return false, errors.Wrap(err, "delete failed")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, errors.Wrap(err, "commit failed")
+	}
+	return true, nil
+}
+
+// Trash implements Trasher.
+func (s *SQLiteStore) Trash() ([]*TrashedQuote, error) {
+	rows, err := s.db.Query("SELECT " + sqliteColumns + ", deleted_at FROM trash ORDER BY id")
+	if err != nil {
+		return nil, errors.Wrap(err, "Trash: select failed")
+	}
+	defer rows.Close()
+
+	structList := []*TrashedQuote{}
+	for rows.Next() {
+		t := &TrashedQuote{}
+		var deletedAt int64
+		err := rows.Scan(&t.ID, &t.Rev, &t.Author, &t.Text, &t.Source, (*tagList)(&t.Tags), &deletedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "Trash: cannot scan row")
+		}
+		t.DeletedAt = time.Unix(0, deletedAt).UTC()
+		structList = append(structList, t)
+	}
+	return structList, rows.Err()
+}
+
+// Undelete implements Trasher.
+func (s *SQLiteStore) Undelete(id uint64) (*Quote, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "Undelete: cannot begin transaction")
+	}
+	defer tx.Rollback()
+
+	q := &Quote{}
+	err = tx.QueryRow("SELECT "+sqliteColumns+" FROM trash WHERE id = ?", id).
+		Scan(&q.ID, &q.Rev, &q.Author, &q.Text, &q.Source, (*tagList)(&q.Tags))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Undelete: select failed")
+	}
+	var n int
+	err = tx.QueryRow("SELECT COUNT(*) FROM quotes WHERE id = ?", id).Scan(&n)
+	if err != nil {
+		return nil, errors.Wrap(err, "Undelete: select failed")
+	}
+	if n > 0 {
+		return nil, ErrExists
+	}
+	dup, err := sqliteFindDuplicate(tx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "Undelete: select failed")
+	}
+	if dup != 0 {
+		return nil, duplicateError(dup)
+	}
+
+	_, err = tx.Exec("INSERT INTO quotes (id, author, text, source, tags, rev, dedup) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		q.ID, q.Author, q.Text, q.Source, tagList(q.Tags), q.Rev, dedupKey(q.Text))
+	if err != nil {
+		return nil, errors.Wrap(err, "Undelete: insert failed")
+	}
+	_, err = tx.Exec("DELETE FROM trash WHERE id = ?", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "Undelete: delete failed")
+	}
+	err = sqliteSaveRevision(tx, id, actorOf(s.ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "Undelete")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "Undelete: commit failed")
+	}
+	return q, nil
+}
+
+// PurgeTrash implements Trasher. The history of purged quotes is dropped
+// as well.
+func (s *SQLiteStore) PurgeTrash(before time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "PurgeTrash: cannot begin transaction")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM history WHERE id IN (SELECT id FROM trash WHERE deleted_at < ?)", before.UnixNano())
+	if err != nil {
+		return 0, errors.Wrap(err, "PurgeTrash: delete history failed")
+	}
+	res, err := tx.Exec("DELETE FROM trash WHERE deleted_at < ?", before.UnixNano())
+	if err != nil {
+		return 0, errors.Wrap(err, "PurgeTrash: delete failed")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "PurgeTrash: no row count")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "PurgeTrash: commit failed")
+	}
+	return int(n), nil
+}
+
+// List returns all quotes, ordered by ID.
+func (s *SQLiteStore) List() ([]*Quote, error) {
+	return s.query("SELECT " + sqliteColumns + " FROM quotes ORDER BY id")
+}
+
+// ListPage lists one page of quotes with a query per page. Ordering and
+// cursors are the same as for DB.ListPage.
+func (s *SQLiteStore) ListPage(opts ListOptions) (*Page, error) {
+	opts.Tags = normalizeTags(opts.Tags)
+	limit := pageLimit(opts)
+	after, err := decodeCursor(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	where := []string{"1 = 1"}
+	args := []interface{}{}
+	order := "id"
+	switch {
+	case opts.Author != "":
+		where = append(where, "author = ?")
+		args = append(args, opts.Author)
+		if after != nil {
+			where = append(where, "id > ?")
+			args = append(args, btoi(after[len(after)-8:]))
+		}
+	case opts.AuthorPrefix != "":
+		where = append(where, "substr(author, 1, length(?)) = ?")
+		args = append(args, opts.AuthorPrefix, opts.AuthorPrefix)
+		if after != nil {
+			author := string(after[:len(after)-9])
+			where = append(where, "(author > ? OR (author = ? AND id > ?))")
+			args = append(args, author, author, btoi(after[len(after)-8:]))
+		}
+		order = "author, id"
+	default:
+		if after != nil {
+			where = append(where, "id > ?")
+			args = append(args, btoi(after))
+		}
+	}
+	if opts.Source != "" {
+		where = append(where, "source = ?")
+		args = append(args, opts.Source)
+	}
+	if len(opts.Tags) > 0 {
+		clause, tagArgs := sqliteTagFilter(opts.Tags, opts.AnyTag)
+		where = append(where, clause)
+		args = append(args, tagArgs...)
+	}
+	// Ask for one more row to learn whether there is a next page.
+	args = append(args, limit+1)
+
+	structList, err := s.query("SELECT "+sqliteColumns+" FROM quotes WHERE "+
+		strings.Join(where, " AND ")+" ORDER BY "+order+" LIMIT ?", args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "ListPage")
+	}
+
+	page := &Page{Quotes: structList}
+	if len(structList) > limit {
+		page.Quotes = structList[:limit]
+		page.NextCursor = encodeCursor(opts, page.Quotes[limit-1])
+	}
+	return page, nil
+}
+
+// query runs a SELECT of sqliteColumns and collects the rows.
+func (s *SQLiteStore) query(query string, args ...interface{}) ([]*Quote, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "select failed")
+	}
+	defer rows.Close()
+
+	structList := []*Quote{}
+	for rows.Next() {
+		q := &Quote{}
+		err := rows.Scan(&q.ID, &q.Rev, &q.Author, &q.Text, &q.Source, (*tagList)(&q.Tags))
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot scan row")
+		}
+		structList = append(structList, q)
+	}
+	return structList, rows.Err()
+}
+
+// History implements Historian.
+func (s *SQLiteStore) History(id uint64) ([]*Revision, error) {
+	rows, err := s.db.Query("SELECT "+sqliteColumns+", time, actor FROM history WHERE id = ? ORDER BY rev", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "History: select failed")
+	}
+	defer rows.Close()
+
+	revisions := []*Revision{}
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "History")
+		}
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "History")
+	}
+	if len(revisions) == 0 {
+		return nil, ErrNotFound
+	}
+	return revisions, nil
+}
+
+// Revision implements Historian.
+func (s *SQLiteStore) Revision(id, rev uint64) (*Revision, error) {
+	r, err := scanRevision(s.db.QueryRow("SELECT "+sqliteColumns+", time, actor FROM history WHERE id = ? AND rev = ?", id, rev))
+	if errors.Cause(err) == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Revision")
+	}
+	return r, nil
+}
+
+// scanRevision scans a row of sqliteColumns, time and actor.
+func scanRevision(row interface{ Scan(...interface{}) error }) (*Revision, error) {
+	r := &Revision{}
+	var t int64
+	err := row.Scan(&r.ID, &r.Rev, &r.Author, &r.Text, &r.Source, (*tagList)(&r.Tags), &t, &r.Actor)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot scan row")
+	}
+	if t != 0 {
+		r.Time = time.Unix(0, t).UTC()
+	}
+	return r, nil
+}
+
+// sqliteSaveRevision copies the quote with the given ID, as it is now stored,
+// into the history table, written with the API key actor. The time and
+// actor of a revision that is already there are kept.
+func sqliteSaveRevision(tx *sql.Tx, id, actor uint64) error {
+	_, err := tx.Exec(`INSERT OR IGNORE INTO history (id, rev, author, text, source, tags, time, actor)
+		SELECT id, rev, author, text, source, tags, ?, ? FROM quotes WHERE id = ?`, time.Now().UnixNano(), actor, id)
+	if err != nil {
+		return errors.Wrap(err, "cannot save revision")
+	}
+	return nil
+}
+
+// Pick implements Picker.
+func (s *SQLiteStore) Pick(n uint64, filter PickFilter) (*Quote, error) {
+	where, args := "1 = 1", []interface{}{}
+	if filter.Author != "" {
+		where, args = "author = ?", append(args, filter.Author)
+	}
+	if tags := normalizeTags(filter.Tags); len(tags) > 0 {
+		clause, tagArgs := sqliteTagFilter(tags, filter.AnyTag)
+		where, args = where+" AND "+clause, append(args, tagArgs...)
+	}
+	var count uint64
+	err := s.db.QueryRow("SELECT COUNT(*) FROM quotes WHERE "+where, args...).Scan(&count)
+	if err != nil {
+		return nil, errors.Wrap(err, "Pick: count failed")
+	}
+	if count == 0 {
+		return nil, ErrNotFound
+	}
+	structList, err := s.query("SELECT "+sqliteColumns+" FROM quotes WHERE "+where+
+		" ORDER BY id LIMIT 1 OFFSET ?", append(args, n%count)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Pick")
+	}
+	if len(structList) == 0 {
+		// Deleted between the two queries.
+		return nil, ErrNotFound
+	}
+	return structList[0], nil
+}
+
+// TagCounts implements Tagger.
+func (s *SQLiteStore) TagCounts() ([]TagCount, error) {
+	rows, err := s.db.Query("SELECT value, COUNT(*) FROM quotes, json_each(quotes.tags) GROUP BY value ORDER BY value")
+	if err != nil {
+		return nil, errors.Wrap(err, "TagCounts: select failed")
+	}
+	defer rows.Close()
+
+	counts := []TagCount{}
+	for rows.Next() {
+		var c TagCount
+		err := rows.Scan(&c.Tag, &c.Count)
+		if err != nil {
+			return nil, errors.Wrap(err, "TagCounts: cannot scan row")
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// sqliteTagFilter returns a WHERE clause for quotes tagged with all of
+// tags, or any of them, and its arguments.
+func sqliteTagFilter(tags []string, any bool) (string, []interface{}) {
+	args := make([]interface{}, len(tags))
+	clauses := make([]string, len(tags))
+	for i, tag := range tags {
+		args[i] = tag
+		clauses[i] = "EXISTS (SELECT 1 FROM json_each(quotes.tags) WHERE value = ?)"
+	}
+	if any {
+		return "(" + strings.Join(clauses, " OR ") + ")", args
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+// tagList stores tags in a column as a JSON array.
+type tagList []string
+
+// Value implements driver.Valuer.
+func (t tagList) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+	v, err := json.Marshal([]string(t))
+	return string(v), err
+}
+
+// Scan implements sql.Scanner.
+func (t *tagList) Scan(src interface{}) error {
+	var v []byte
+	switch src := src.(type) {
+	case string:
+		v = []byte(src)
+	case []byte:
+		v = src
+	default:
+		return errors.Errorf("cannot scan %T into tags", src)
+	}
+	var tags []string
+	err := json.Unmarshal(v, &tags)
+	if err != nil {
+		return errors.Wrap(err, "cannot decode tags")
+	}
+	if len(tags) == 0 {
+		tags = nil
+	}
+	*t = tags
+	return nil
+}
@@ -0,0 +1,126 @@
+package quotes
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// Pinger is implemented by stores that can check that they are usable.
+type Pinger interface {
+	// Ping fails if the store is closed or cannot be read.
+	Ping() error
+}
+
+// Stats are the statistics of the Bolt database.
+type Stats struct {
+	// Stats are the freelist and transaction statistics of Bolt. Its
+	// TxStats add up all committed transactions.
+	bolt.Stats
+	// Pages is the number of pages of the database file.
+	Pages int
+	// PageSize is the size of a page in bytes.
+	PageSize int
+	// Cache are the counters of the read cache.
+	Cache CacheStats
+	// Tx are the durations of the transactions of the operations of d,
+	// ordered by operation.
+	Tx []TxDurations
+}
+
+// TxDurationBuckets are the upper bounds in seconds of the buckets of
+// TxDurations.
+var TxDurationBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// TxDurations is the histogram of the durations of the transactions of an
+// operation, like Create or Get, waiting for a batch included.
+type TxDurations struct {
+	Op string
+	// Counts[i] is the number of transactions that took up to
+	// TxDurationBuckets[i]; the last element counts the slower ones.
+	Counts []uint64
+	// Sum is the total duration in seconds.
+	Sum float64
+}
+
+// txTimes records the durations of the transactions of view and update.
+type txTimes struct {
+	mu  sync.Mutex
+	ops map[string]*TxDurations
+}
+
+func newTxTimes() *txTimes {
+	return &txTimes{ops: map[string]*TxDurations{}}
+}
+
+func (t *txTimes) observe(op string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.ops[op]
+	if !ok {
+		h = &TxDurations{Op: op, Counts: make([]uint64, len(TxDurationBuckets)+1)}
+		t.ops[op] = h
+	}
+	h.Counts[sort.SearchFloat64s(TxDurationBuckets, d.Seconds())]++
+	h.Sum += d.Seconds()
+}
+
+func (t *txTimes) snapshot() []TxDurations {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	all := make([]TxDurations, 0, len(t.ops))
+	for _, h := range t.ops {
+		all = append(all, TxDurations{h.Op, append([]uint64(nil), h.Counts...), h.Sum})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Op < all[j].Op })
+	return all
+}
+
+// Stats returns the statistics of the database.
+func (d *DB) Stats() (*Stats, error) {
+	s := &Stats{
+		Stats:    d.db.Stats(),
+		PageSize: d.db.Info().PageSize,
+		Cache:    d.CacheStats(),
+		Tx:       d.txTimes.snapshot(),
+	}
+	err := d.db.View(func(tx *bolt.Tx) error {
+		s.Pages = int(tx.Size()) / s.PageSize
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Stats: DB.View() failed")
+	}
+	return s, nil
+}
+
+// Ping implements Pinger. It runs a read transaction that looks for the
+// quote bucket.
+func (d *DB) Ping() error {
+	err := d.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(quoteBucket)) == nil {
+			return errors.New("quote bucket is missing")
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "Ping")
+	}
+	return nil
+}
+
+// Ping implements Pinger.
+func (s *SQLiteStore) Ping() error {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'quotes'").Scan(&n)
+	if err == nil && n == 0 {
+		err = errors.New("quotes table is missing")
+	}
+	if err != nil {
+		return errors.Wrap(err, "Ping")
+	}
+	return nil
+}
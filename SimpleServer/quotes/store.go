@@ -0,0 +1,82 @@
+package quotes
+
+import "context"
+
+// QuoteStore is a storage backend for quotes. DB is the Bolt backend;
+// MemoryStore and SQLiteStore are the alternatives.
+type QuoteStore interface {
+	// Create assigns q a new ID and revision 1 and stores it.
+	Create(q *Quote) error
+	// Get returns the quote with the given ID, or fails with ErrNotFound.
+	Get(id uint64) (*Quote, error)
+	// Update replaces the stored quote with ID q.ID and bumps its
+	// revision, or fails with ErrNotFound. A non-zero q.Rev must match the stored revision,
+	// otherwise Update fails with ErrRevisionMismatch.
+	Update(q *Quote) error
+	// Delete removes the quote with the given ID, or fails with
+	// ErrNotFound. Stores that are a Trasher keep it in their trash.
+	Delete(id uint64) error
+	// DeleteIfMatch removes the quote with the given ID if it is at
+	// revision rev, and fails with ErrRevisionMismatch otherwise.
+	DeleteIfMatch(id, rev uint64) error
+	// List returns all quotes, ordered by ID.
+	List() ([]*Quote, error)
+	Close() error
+}
+
+// ContextBinder is implemented by stores that can tie their calls to a
+// context, for tracing and to record the API key of changes. See
+// WithContext and WithActor.
+type ContextBinder interface {
+	WithContext(ctx context.Context) QuoteStore
+}
+
+// WithContext returns s with its calls tied to ctx, or s itself if it is
+// not a ContextBinder.
+func WithContext(s QuoteStore, ctx context.Context) QuoteStore {
+	if b, ok := s.(ContextBinder); ok {
+		return b.WithContext(ctx)
+	}
+	return s
+}
+
+// Pager is implemented by stores that can page through quotes without
+// loading all of them. See ListPage.
+type Pager interface {
+	ListPage(opts ListOptions) (*Page, error)
+}
+
+// Searcher is implemented by stores that support full-text search.
+type Searcher interface {
+	Search(query string, limit int) ([]*SearchResult, error)
+}
+
+var (
+	_ QuoteStore    = (*DB)(nil)
+	_ Pager         = (*DB)(nil)
+	_ Searcher      = (*DB)(nil)
+	_ Trasher       = (*DB)(nil)
+	_ Historian     = (*DB)(nil)
+	_ Picker        = (*DB)(nil)
+	_ Tagger        = (*DB)(nil)
+	_ Pinger        = (*DB)(nil)
+	_ Modifier      = (*DB)(nil)
+	_ Collector     = (*DB)(nil)
+	_ ContextBinder = (*DB)(nil)
+	_ Pinner        = (*DB)(nil)
+	_ QuoteStore    = (*MemoryStore)(nil)
+	_ Trasher       = (*MemoryStore)(nil)
+	_ Historian     = (*MemoryStore)(nil)
+	_ Tagger        = (*MemoryStore)(nil)
+	_ ContextBinder = (*MemoryStore)(nil)
+	_ Pinner        = (*MemoryStore)(nil)
+	_ QuoteStore    = (*SQLiteStore)(nil)
+	_ Pager         = (*SQLiteStore)(nil)
+	_ Trasher       = (*SQLiteStore)(nil)
+	_ Historian     = (*SQLiteStore)(nil)
+	_ Picker        = (*SQLiteStore)(nil)
+	_ Tagger        = (*SQLiteStore)(nil)
+	_ Pinger        = (*SQLiteStore)(nil)
+	_ ContextBinder = (*SQLiteStore)(nil)
+	_ Pinner        = (*SQLiteStore)(nil)
+)
@@ -0,0 +1,571 @@
+package quotes
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stores returns a fresh instance of every QuoteStore implementation and
+// a teardown function for each.
+func stores(t *testing.T) map[string]func() (QuoteStore, func()) {
+	return map[string]func() (QuoteStore, func()){
+		"Bolt": func() (QuoteStore, func()) {
+			path := "testdata/storedb"
+			d, err := Open(path)
+			if err != nil {
+				t.Fatalf("Open(): Cannot open %s", path)
+			}
+			return d, func() {
+				d.Close()
+				os.Remove(path)
+			}
+		},
+		"Memory": func() (QuoteStore, func()) {
+			m := NewMemoryStore()
+			return m, func() { m.Close() }
+		},
+		"SQLite": func() (QuoteStore, func()) {
+			path := "testdata/store.sqlite"
+			s, err := OpenSQLite(path)
+			if err != nil {
+				t.Fatalf("OpenSQLite(): Cannot open %s: %v", path, err)
+			}
+			return s, func() {
+				s.Close()
+				os.Remove(path)
+			}
+		},
+	}
+}
+
+func TestQuoteStore_CRUD(t *testing.T) {
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s, teardown := newStore()
+			defer teardown()
+
+			a := &Quote{Author: "Gopher", Text: "Errors are values."}
+			b := &Quote{Author: "Gopher", Text: "Don't panic.", Source: "Go Proverbs"}
+			for _, q := range []*Quote{a, b} {
+				err := s.Create(q)
+				if err != nil {
+					t.Fatalf("Create() error = %v", err)
+				}
+			}
+			if a.ID != 1 || b.ID != 2 {
+				t.Errorf("Create() IDs = %d, %d, want 1, 2", a.ID, b.ID)
+			}
+
+			got, err := s.Get(b.ID)
+			if err != nil || !reflect.DeepEqual(got, b) {
+				t.Errorf("Get() = %v, %v, want %v", got, err, b)
+			}
+
+			changed := *a
+			changed.Author = "Rob Pike"
+			err = s.Update(&changed)
+			if err != nil || changed.Rev != 2 {
+				t.Errorf("Update() = rev %d, %v, want rev 2", changed.Rev, err)
+			}
+			stale := *a
+			err = s.Update(&stale)
+			if err != ErrRevisionMismatch {
+				t.Errorf("Update() at a stale revision error = %v, want %v", err, ErrRevisionMismatch)
+			}
+			err = s.DeleteIfMatch(b.ID, 2)
+			if err != ErrRevisionMismatch {
+				t.Errorf("DeleteIfMatch() at a wrong revision error = %v, want %v", err, ErrRevisionMismatch)
+			}
+			err = s.Update(&Quote{ID: 42, Author: "Nobody"})
+			if err != ErrNotFound {
+				t.Errorf("Update() of a missing quote error = %v, want %v", err, ErrNotFound)
+			}
+
+			err = s.DeleteIfMatch(b.ID, 1)
+			if err != nil {
+				t.Errorf("DeleteIfMatch() error = %v", err)
+			}
+			_, err = s.Get(b.ID)
+			if err != ErrNotFound {
+				t.Errorf("Get() of a deleted quote error = %v, want %v", err, ErrNotFound)
+			}
+
+			list, err := s.List()
+			if err != nil || !reflect.DeepEqual(list, []*Quote{&changed}) {
+				t.Errorf("List() = %v, %v, want %v", list, err, []*Quote{&changed})
+			}
+
+			// IDs are not reused.
+			c := &Quote{Author: "Gopher", Text: "Clear is better than clever."}
+			err = s.Create(c)
+			if err != nil || c.ID != 3 {
+				t.Errorf("Create() = %d, %v, want ID 3", c.ID, err)
+			}
+			err = s.Delete(c.ID)
+			if err != nil {
+				t.Errorf("Delete() error = %v", err)
+			}
+			err = s.Delete(c.ID)
+			if err != ErrNotFound {
+				t.Errorf("Delete() of a deleted quote error = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestQuoteStore_Trash(t *testing.T) {
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s, teardown := newStore()
+			defer teardown()
+			trasher := s.(Trasher)
+
+			a := &Quote{Author: "Gopher", Text: "Errors are values."}
+			b := &Quote{Author: "Gopher", Text: "Don't panic.", Source: "Go Proverbs"}
+			for _, q := range []*Quote{a, b} {
+				err := s.Create(q)
+				if err != nil {
+					t.Fatalf("Create() error = %v", err)
+				}
+			}
+			err := s.Delete(a.ID)
+			if err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			err = s.DeleteIfMatch(b.ID, 1)
+			if err != nil {
+				t.Fatalf("DeleteIfMatch() error = %v", err)
+			}
+
+			trash, err := trasher.Trash()
+			if err != nil || len(trash) != 2 || !reflect.DeepEqual(trash[0].Quote, *a) || trash[0].DeletedAt.IsZero() {
+				t.Fatalf("Trash() = %v, %v, want %v and %v", trash, err, a, b)
+			}
+
+			got, err := trasher.Undelete(a.ID)
+			if err != nil || !reflect.DeepEqual(got, a) {
+				t.Errorf("Undelete() = %v, %v, want %v", got, err, a)
+			}
+			got, err = s.Get(a.ID)
+			if err != nil || !reflect.DeepEqual(got, a) {
+				t.Errorf("Get() of an undeleted quote = %v, %v, want %v", got, err, a)
+			}
+			_, err = trasher.Undelete(a.ID)
+			if err != ErrNotFound {
+				t.Errorf("Undelete() twice error = %v, want %v", err, ErrNotFound)
+			}
+
+			// A quote created since the deletion keeps its text.
+			c := &Quote{Author: "Rob Pike", Text: "Don't  panic!"}
+			err = s.Create(c)
+			if err != nil {
+				t.Fatalf("Create() of the text of a trashed quote error = %v", err)
+			}
+			_, err = trasher.Undelete(b.ID)
+			if v, ok := err.(*ValidationError); !ok || v.DuplicateOf != c.ID {
+				t.Errorf("Undelete() of a duplicate error = %v, want a duplicate of %d", err, c.ID)
+			}
+			err = s.Delete(c.ID)
+			if err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+
+			n, err := trasher.PurgeTrash(time.Now().Add(-time.Hour))
+			if err != nil || n != 0 {
+				t.Errorf("PurgeTrash() of recent deletes = %d, %v, want 0", n, err)
+			}
+			n, err = trasher.PurgeTrash(time.Now().Add(time.Hour))
+			if err != nil || n != 2 {
+				t.Errorf("PurgeTrash() = %d, %v, want 2", n, err)
+			}
+			_, err = trasher.Undelete(b.ID)
+			if err != ErrNotFound {
+				t.Errorf("Undelete() of a purged quote error = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestQuoteStore_ListPage(t *testing.T) {
+	data := []Quote{
+		{Author: "Rob Pike", Text: "Clear is better than clever.", Source: "Go Proverbs"},
+		{Author: "Robert Griesemer", Text: "Go is a language for the 21st century."},
+		{Author: "Gopher", Text: "Errors are values.", Source: "Go Proverbs"},
+		{Author: "Rob Pike", Text: "Concurrency is not parallelism."},
+		{Author: "Rob Pike", Text: "Don't panic.", Source: "Go Proverbs"},
+	}
+	tests := []struct {
+		name string
+		opts ListOptions
+		want [][]uint64
+	}{
+		{"Paged", ListOptions{Limit: 2}, [][]uint64{{1, 2}, {3, 4}, {5}}},
+		{"Author", ListOptions{Limit: 2, Author: "Rob Pike"}, [][]uint64{{1, 4}, {5}}},
+		{"AuthorPrefix", ListOptions{Limit: 3, AuthorPrefix: "Rob"}, [][]uint64{{1, 4, 5}, {2}}},
+		{"AuthorAndSource", ListOptions{AuthorPrefix: "Rob", Source: "Go Proverbs"}, [][]uint64{{1, 5}}},
+	}
+
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s, teardown := newStore()
+			defer teardown()
+
+			for _, q := range data {
+				q := q
+				err := s.Create(&q)
+				if err != nil {
+					t.Fatalf("Cannot fill test database: " + err.Error())
+				}
+			}
+
+			for _, tt := range tests {
+				got := [][]uint64{}
+				opts := tt.opts
+				for len(got) <= len(data) {
+					page, err := ListPage(s, opts)
+					if err != nil {
+						t.Fatalf("ListPage() error = %v", err)
+					}
+					ids := []uint64{}
+					for _, q := range page.Quotes {
+						ids = append(ids, q.ID)
+					}
+					got = append(got, ids)
+					if page.NextCursor == "" {
+						break
+					}
+					opts.Cursor = page.NextCursor
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s: ListPage() pages = %v, want %v", tt.name, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQuoteStore_History(t *testing.T) {
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s, teardown := newStore()
+			defer teardown()
+			historian := s.(Historian)
+
+			q := &Quote{Author: "Gopher", Text: "Errors are values."}
+			err := s.Create(q)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			first := *q
+			q.Author = "Rob Pike"
+			q.Source = "Go Proverbs"
+			// The update is made with API key 7.
+			err = WithContext(s, WithActor(context.Background(), 7)).Update(q)
+			if err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+
+			history, err := historian.History(q.ID)
+			if err != nil || len(history) != 2 {
+				t.Fatalf("History() = %v, %v, want 2 revisions", history, err)
+			}
+			if !reflect.DeepEqual(history[0].Quote, first) || !reflect.DeepEqual(history[1].Quote, *q) || history[0].Time.IsZero() {
+				t.Errorf("History() = %v, want %v and %v", history, first, q)
+			}
+			if history[0].Actor != 0 || history[1].Actor != 7 {
+				t.Errorf("History() actors = %d, %d, want 0, 7", history[0].Actor, history[1].Actor)
+			}
+
+			r, err := historian.Revision(q.ID, 1)
+			if err != nil || !reflect.DeepEqual(r.Quote, first) {
+				t.Errorf("Revision(1) = %v, %v, want %v", r, err, first)
+			}
+			_, err = historian.Revision(q.ID, 3)
+			if err != ErrNotFound {
+				t.Errorf("Revision(3) error = %v, want %v", err, ErrNotFound)
+			}
+			_, err = historian.History(42)
+			if err != ErrNotFound {
+				t.Errorf("History() of a missing quote error = %v, want %v", err, ErrNotFound)
+			}
+
+			// Undeleting a quote leaves its history as it was, also at
+			// revision 1.
+			p := &Quote{Author: "Gopher", Text: "Don't panic."}
+			err = s.Create(p)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			before, err := historian.History(p.ID)
+			if err != nil {
+				t.Fatalf("History() error = %v", err)
+			}
+			err = s.Delete(p.ID)
+			if err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			time.Sleep(time.Millisecond)
+			_, err = s.(Trasher).Undelete(p.ID)
+			if err != nil {
+				t.Fatalf("Undelete() error = %v", err)
+			}
+			after, err := historian.History(p.ID)
+			if err != nil || !reflect.DeepEqual(after, before) {
+				t.Errorf("History() after Undelete() = %v, %v, want %v", after, err, before)
+			}
+
+			// The history survives the trash until it is purged.
+			err = s.Delete(q.ID)
+			if err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			history, err = historian.History(q.ID)
+			if err != nil || len(history) != 2 {
+				t.Errorf("History() of a trashed quote = %v, %v, want 2 revisions", history, err)
+			}
+			_, err = s.(Trasher).PurgeTrash(time.Now().Add(time.Hour))
+			if err != nil {
+				t.Fatalf("PurgeTrash() error = %v", err)
+			}
+			_, err = historian.History(q.ID)
+			if err != ErrNotFound {
+				t.Errorf("History() of a purged quote error = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := &Quote{Author: "Gopher", Text: "Errors are values."}
+	b := &Quote{Author: "Rob Pike", Text: "Errors are values.", Source: "Go Proverbs"}
+	want := []FieldChange{
+		{"author", "Gopher", "Rob Pike"},
+		{"source", "", "Go Proverbs"},
+	}
+	if got := Diff(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+	if got := Diff(a, a); len(got) != 0 {
+		t.Errorf("Diff() of equal quotes = %v, want none", got)
+	}
+}
+
+func TestQuoteStore_Pick(t *testing.T) {
+	data := []Quote{
+		{Author: "Rob Pike", Text: "Clear is better than clever."},
+		{Author: "Gopher", Text: "Errors are values."},
+		{Author: "Rob Pike", Text: "Don't panic."},
+		{Author: "Gopher", Text: "A little copying is better than a little dependency."},
+	}
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s, teardown := newStore()
+			defer teardown()
+
+			_, err := Pick(s, 0, PickFilter{})
+			if err != ErrNotFound {
+				t.Errorf("Pick() from an empty store error = %v, want %v", err, ErrNotFound)
+			}
+			for _, q := range data {
+				q := q
+				err := s.Create(&q)
+				if err != nil {
+					t.Fatalf("Create() error = %v", err)
+				}
+			}
+			err = s.Delete(2)
+			if err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+
+			// Every quote is picked by exactly one n modulo the count.
+			seen := map[uint64]int{}
+			for n := uint64(0); n < 6; n++ {
+				q, err := Pick(s, n, PickFilter{})
+				if err != nil {
+					t.Fatalf("Pick(%d) error = %v", n, err)
+				}
+				seen[q.ID]++
+			}
+			if !reflect.DeepEqual(seen, map[uint64]int{1: 2, 3: 2, 4: 2}) {
+				t.Errorf("Pick() picked %v, want quotes 1, 3 and 4 twice each", seen)
+			}
+
+			for n := uint64(0); n < 4; n++ {
+				q, err := Pick(s, n, PickFilter{Author: "Gopher"})
+				if err != nil || q.ID != 4 {
+					t.Errorf("Pick(%d, Gopher) = %v, %v, want quote 4", n, q, err)
+				}
+			}
+			_, err = Pick(s, 0, PickFilter{Author: "Nobody"})
+			if err != ErrNotFound {
+				t.Errorf("Pick() by a missing author error = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestQuoteStore_PickDaily(t *testing.T) {
+	today := time.Now().UTC().Format("2006-01-02")
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s, teardown := newStore()
+			defer teardown()
+
+			_, err := PickDaily(s, today, "", PickFilter{})
+			if err != ErrNotFound {
+				t.Errorf("PickDaily() from an empty store error = %v, want %v", err, ErrNotFound)
+			}
+			for i := 0; i < 5; i++ {
+				err := s.Create(&Quote{Author: "Gopher", Text: fmt.Sprintf("Proverb %d.", i)})
+				if err != nil {
+					t.Fatalf("Create() error = %v", err)
+				}
+			}
+			q, err := PickDaily(s, today, "", PickFilter{})
+			if err != nil {
+				t.Fatalf("PickDaily() error = %v", err)
+			}
+			if id, err := s.(Pinner).Pinned(today, ""); err != nil || id != q.ID {
+				t.Errorf("Pinned() = %d, %v, want %d", id, err, q.ID)
+			}
+
+			// Neither creating nor deleting other quotes changes the pick.
+			for i := 5; i < 20; i++ {
+				err := s.Create(&Quote{Author: "Gopher", Text: fmt.Sprintf("Proverb %d.", i)})
+				if err != nil {
+					t.Fatalf("Create() error = %v", err)
+				}
+			}
+			for id := uint64(1); id <= 5; id++ {
+				if id == q.ID {
+					continue
+				}
+				if err := s.Delete(id); err != nil {
+					t.Fatalf("Delete() error = %v", err)
+				}
+			}
+			if again, err := PickDaily(s, today, "", PickFilter{}); err != nil || again.ID != q.ID {
+				t.Errorf("PickDaily() after changes = %v, %v, want quote %d", again, err, q.ID)
+			}
+
+			// Deleting the pick picks another one, and pins it.
+			if err := s.Delete(q.ID); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			next, err := PickDaily(s, today, "", PickFilter{})
+			if err != nil || next.ID == q.ID {
+				t.Fatalf("PickDaily() after deleting the pick = %v, %v", next, err)
+			}
+			if again, err := PickDaily(s, today, "", PickFilter{}); err != nil || again.ID != next.ID {
+				t.Errorf("PickDaily() again = %v, %v, want quote %d", again, err, next.ID)
+			}
+
+			// Past days are not pinned, and their pins are dropped.
+			if _, err := PickDaily(s, "2000-01-01", "", PickFilter{}); err != nil {
+				t.Fatalf("PickDaily(2000-01-01) error = %v", err)
+			}
+			if err := s.(Pinner).Pin(today, "other", next.ID); err != nil {
+				t.Fatalf("Pin() error = %v", err)
+			}
+			if err := s.(Pinner).Pin("2000-01-02", "", next.ID); err != nil {
+				t.Fatalf("Pin() error = %v", err)
+			}
+			if err := s.(Pinner).Pin(today, "another", next.ID); err != nil {
+				t.Fatalf("Pin() error = %v", err)
+			}
+			for _, day := range []string{"2000-01-01", "2000-01-02"} {
+				if id, err := s.(Pinner).Pinned(day, ""); err != nil || id != 0 {
+					t.Errorf("Pinned(%s) = %d, %v, want none", day, id, err)
+				}
+			}
+		})
+	}
+}
+
+func TestQuoteStore_Tags(t *testing.T) {
+	data := []Quote{
+		{Author: "Rob Pike", Text: "Clear is better than clever.", Tags: []string{"go", "Style "}},
+		{Author: "Gopher", Text: "Errors are values.", Tags: []string{"go", "errors", "go"}},
+		{Author: "Rob Pike", Text: "Don't panic.", Tags: []string{"errors"}},
+		{Author: "Gopher", Text: "A little copying is better than a little dependency.", Tags: []string{"style", "go"}},
+		{Author: "Gopher", Text: "Untagged."},
+	}
+	tests := []struct {
+		name string
+		opts ListOptions
+		want [][]uint64
+	}{
+		{"All", ListOptions{Tags: []string{"GO", "style"}, Limit: 1}, [][]uint64{{1}, {4}}},
+		{"Any", ListOptions{Tags: []string{"style", "errors"}, AnyTag: true, Limit: 2}, [][]uint64{{1, 2}, {3, 4}}},
+		{"AuthorAndTag", ListOptions{Author: "Gopher", Tags: []string{"go"}}, [][]uint64{{2, 4}}},
+		{"None", ListOptions{Tags: []string{"rust"}}, [][]uint64{{}}},
+	}
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s, teardown := newStore()
+			defer teardown()
+
+			for _, q := range data {
+				q := q
+				err := s.Create(&q)
+				if err != nil {
+					t.Fatalf("Create() error = %v", err)
+				}
+			}
+			got, err := s.Get(1)
+			if err != nil || !reflect.DeepEqual(got.Tags, []string{"go", "style"}) {
+				t.Errorf("Get() tags = %v, %v, want normalized [go style]", got, err)
+			}
+
+			for _, tt := range tests {
+				pages := [][]uint64{}
+				opts := tt.opts
+				for {
+					page, err := ListPage(s, opts)
+					if err != nil {
+						t.Fatalf("%s: ListPage() error = %v", tt.name, err)
+					}
+					ids := []uint64{}
+					for _, q := range page.Quotes {
+						ids = append(ids, q.ID)
+					}
+					pages = append(pages, ids)
+					if page.NextCursor == "" {
+						break
+					}
+					opts.Cursor = page.NextCursor
+				}
+				if !reflect.DeepEqual(pages, tt.want) {
+					t.Errorf("%s: ListPage() pages = %v, want %v", tt.name, pages, tt.want)
+				}
+			}
+
+			q, err := Pick(s, 7, PickFilter{Author: "Rob Pike", Tags: []string{"errors"}})
+			if err != nil || q.ID != 3 {
+				t.Errorf("Pick() by author and tag = %v, %v, want quote 3", q, err)
+			}
+
+			changed := data[2]
+			changed.ID = 3
+			changed.Tags = []string{"go"}
+			err = s.Update(&changed)
+			if err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			err = s.Delete(4)
+			if err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			counts, err := s.(Tagger).TagCounts()
+			want := []TagCount{{"errors", 1}, {"go", 3}, {"style", 1}}
+			if err != nil || !reflect.DeepEqual(counts, want) {
+				t.Errorf("TagCounts() = %v, %v, want %v", counts, err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package quotes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// tagBucket indexes quotes by tag, keyed by indexKey(tag, id).
+	tagBucket = "tags"
+	// tagCountBucket holds the number of quotes per tag.
+	tagCountBucket = "tagcounts"
+)
+
+// TagCount is a tag and the number of quotes tagged with it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count uint64 `json:"count"`
+}
+
+// Tagger is implemented by stores that can count their tags.
+type Tagger interface {
+	// TagCounts returns every tag in use, ordered by tag.
+	TagCounts() ([]TagCount, error)
+}
+
+// TagCounts implements Tagger.
+func (d *DB) TagCounts() ([]TagCount, error) {
+	counts := []TagCount{}
+	err := d.view("TagCounts", func(tx namespace) error {
+		return tx.Bucket([]byte(tagCountBucket)).ForEach(func(k, v []byte) error {
+			counts = append(counts, TagCount{string(k), btoi(v)})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "TagCounts: DB.View() failed")
+	}
+	return counts, nil
+}
+
+// hasTags reports whether the quote with the given ID is tagged with all of
+// tags, or with any of them if any is set, according to the tag index.
+func hasTags(tx namespace, id uint64, tags []string, any bool) bool {
+	index := tx.Bucket([]byte(tagBucket))
+	for _, tag := range tags {
+		found := index.Get(indexKey(tag, id)) != nil
+		if found == any {
+			return found
+		}
+	}
+	return !any || len(tags) == 0
+}
+
+// indexTags adds the tags of q to the tag index.
+func indexTags(tx namespace, q *Quote) error {
+	index := tx.Bucket([]byte(tagBucket))
+	counts := tx.Bucket([]byte(tagCountBucket))
+	for _, tag := range q.Tags {
+		err := index.Put(indexKey(tag, q.ID), nil)
+		if err != nil {
+			return fmt.Errorf("put tag index: %s", err)
+		}
+		err = addCount(counts, []byte(tag), 1)
+		if err != nil {
+			return fmt.Errorf("put tag count: %s", err)
+		}
+	}
+	return nil
+}
+
+// unindexTags removes the tags of q from the tag index.
+func unindexTags(tx namespace, q *Quote) error {
+	index := tx.Bucket([]byte(tagBucket))
+	counts := tx.Bucket([]byte(tagCountBucket))
+	for _, tag := range q.Tags {
+		err := index.Delete(indexKey(tag, q.ID))
+		if err != nil {
+			return fmt.Errorf("delete tag index: %s", err)
+		}
+		if getCount(counts, []byte(tag)) <= 1 {
+			err = counts.Delete([]byte(tag))
+		} else {
+			err = addCount(counts, []byte(tag), -1)
+		}
+		if err != nil {
+			return fmt.Errorf("put tag count: %s", err)
+		}
+	}
+	return nil
+}
+
+// normalizeTags lowercases and trims tags and returns them sorted and
+// without duplicates, or nil if none are left. Tags must not contain NUL,
+// which separates the parts of index keys.
+func normalizeTags(tags []string) []string {
+	set := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(strings.ReplaceAll(tag, "\x00", "")))
+		if tag != "" {
+			set[tag] = true
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	normalized := make([]string, 0, len(set))
+	for tag := range set {
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// matchesTags reports whether q is tagged with all of tags, or with any of
+// them if any is set.
+func matchesTags(q *Quote, tags []string, any bool) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range q.Tags {
+			found = found || t == tag
+		}
+		if found == any {
+			return found
+		}
+	}
+	return !any || len(tags) == 0
+}
+
+// TagCounts implements Tagger.
+func (m *MemoryStore) TagCounts() ([]TagCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := map[string]uint64{}
+	for _, q := range m.quotes {
+		for _, tag := range q.Tags {
+			set[tag]++
+		}
+	}
+	counts := make([]TagCount, 0, len(set))
+	for tag, n := range set {
+		counts = append(counts, TagCount{tag, n})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Tag < counts[j].Tag })
+	return counts, nil
+}
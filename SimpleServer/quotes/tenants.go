@@ -0,0 +1,365 @@
+package quotes
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// tenantPrefix starts the names of the top-level buckets that hold the
+// buckets of a tenant, one per tenant. The default tenant "" keeps its
+// buckets at the top level, where they were before there were tenants.
+const tenantPrefix = "tenant:"
+
+// tenantKey is the key of the Tenant record in the meta bucket of a
+// tenant.
+var tenantKey = []byte("tenant")
+
+// namespace holds the buckets of one tenant: the transaction itself for
+// the default tenant, the top-level bucket of the tenant otherwise. The
+// functions that work within a transaction take the namespace of the
+// tenant as their tx, so that they cannot reach the data of others.
+type namespace interface {
+	Bucket(name []byte) *bolt.Bucket
+	CreateBucket(name []byte) (*bolt.Bucket, error)
+	CreateBucketIfNotExists(name []byte) (*bolt.Bucket, error)
+	DeleteBucket(name []byte) error
+}
+
+// ErrTenantNotFound is returned when there is no tenant with the requested
+// name.
+var ErrTenantNotFound = errors.New("tenant not found")
+
+// ErrQuotaExceeded is returned when a tenant already stores as many quotes
+// or collections as its quotas allow.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// tenantName is what a tenant may be called: it ends up in URLs.
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Quotas limit what a tenant stores. 0 means no limit.
+type Quotas struct {
+	// MaxQuotes limits the quotes, the trash not counted.
+	MaxQuotes int `json:"max_quotes"`
+	// MaxCollections limits the collections.
+	MaxCollections int `json:"max_collections"`
+}
+
+// Tenant is a namespace of quotes, with everything that belongs to them,
+// that is isolated from the other tenants. Quotes and Collections are the
+// current usage; they are not stored.
+type Tenant struct {
+	Name        string    `json:"name"`
+	Quotas      Quotas    `json:"quotas"`
+	CreatedAt   time.Time `json:"created_at"`
+	Quotes      int       `json:"quotes"`
+	Collections int       `json:"collections"`
+}
+
+// Tenanter is implemented by stores that keep tenants apart.
+type Tenanter interface {
+	// ForTenant returns the store of the tenant called name. It shares
+	// the database with the store and needs no closing; its calls fail
+	// with ErrTenantNotFound while there is no such tenant.
+	ForTenant(name string) QuoteStore
+	// CreateTenant creates the tenant t.Name with the quotas t.Quotas. It
+	// fails with a ValidationError for an unusable name and with
+	// ErrExists if the name is taken.
+	CreateTenant(t *Tenant) error
+	// Tenant returns the tenant called name, or fails with
+	// ErrTenantNotFound.
+	Tenant(name string) (*Tenant, error)
+	// Tenants returns all tenants, ordered by name. The default tenant
+	// is not one of them.
+	Tenants() ([]*Tenant, error)
+	// SetQuotas changes the quotas of a tenant. Lowering them below the
+	// usage only stops it from growing.
+	SetQuotas(name string, quotas Quotas) (*Tenant, error)
+	// DropTenant deletes a tenant and all its data for good.
+	DropTenant(name string) error
+}
+
+// ForTenant implements Tenanter. The copy keeps the context of d.
+func (d *DB) ForTenant(name string) QuoteStore {
+	c := *d
+	c.tenant = name
+	c.hub = d.hubs.get(name)
+	return &c
+}
+
+// namespace returns the namespace of the tenant of d within tx.
+func (d *DB) namespace(tx *bolt.Tx) (namespace, error) {
+	if d.tenant == "" {
+		return tx, nil
+	}
+	b := tx.Bucket([]byte(tenantPrefix + d.tenant))
+	if b == nil {
+		return nil, ErrTenantNotFound
+	}
+	return b, nil
+}
+
+// namespaces calls fn with the name and namespace of every tenant within
+// tx, the default tenant first.
+func namespaces(tx *bolt.Tx, fn func(name string, tx namespace) error) error {
+	err := fn("", tx)
+	if err != nil {
+		return err
+	}
+	// Collect the names first, fn may change the buckets.
+	names := []string{}
+	prefix := []byte(tenantPrefix)
+	c := tx.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		names = append(names, string(k[len(prefix):]))
+	}
+	for _, name := range names {
+		err := fn(name, tx.Bucket([]byte(tenantPrefix+name)))
+		if err != nil {
+			return errors.Wrapf(err, "tenant %s", name)
+		}
+	}
+	return nil
+}
+
+// CreateTenant implements Tenanter. The buckets of the tenant are created
+// at the current schema version.
+func (d *DB) CreateTenant(t *Tenant) error {
+	if !tenantName.MatchString(t.Name) {
+		return &ValidationError{Fields: []FieldError{{"name", "invalid", "must be 1 to 63 lowercase letters, digits and dashes, starting with a letter or digit"}}}
+	}
+	err := checkQuotas(t.Quotas)
+	if err != nil {
+		return err
+	}
+	t.CreatedAt = time.Now().UTC()
+	t.Quotes, t.Collections = 0, 0
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(tenantPrefix+t.Name)) != nil {
+			return ErrExists
+		}
+		ns, err := tx.CreateBucket([]byte(tenantPrefix + t.Name))
+		if err != nil {
+			return err
+		}
+		for _, name := range buckets {
+			_, err := ns.CreateBucket([]byte(name))
+			if err != nil {
+				return errors.Wrapf(err, "create bucket %s", name)
+			}
+		}
+		meta := ns.Bucket([]byte(metaBucket))
+		err = meta.Put(schemaVersionKey, itob(uint64(migrations[len(migrations)-1].Version)))
+		if err != nil {
+			return err
+		}
+		return putTenant(ns, t)
+	})
+	if err == ErrExists {
+		return err
+	}
+	if err != nil {
+		return errors.Wrap(err, "CreateTenant: DB.Update() failed")
+	}
+	// Nothing of a dropped tenant of the same name may be served.
+	d.cache.invalidate(t.Name, nil)
+	return nil
+}
+
+// Tenant implements Tenanter.
+func (d *DB) Tenant(name string) (*Tenant, error) {
+	var t *Tenant
+	err := d.db.View(func(tx *bolt.Tx) error {
+		ns := tx.Bucket([]byte(tenantPrefix + name))
+		if name == "" || ns == nil {
+			return ErrTenantNotFound
+		}
+		var err error
+		t, err = getTenant(ns)
+		return err
+	})
+	if err == ErrTenantNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Tenant: DB.View() failed")
+	}
+	return t, nil
+}
+
+// Tenants implements Tenanter.
+func (d *DB) Tenants() ([]*Tenant, error) {
+	tenants := []*Tenant{}
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return namespaces(tx, func(name string, ns namespace) error {
+			if name == "" {
+				return nil
+			}
+			t, err := getTenant(ns)
+			if err != nil {
+				return err
+			}
+			tenants = append(tenants, t)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Tenants: DB.View() failed")
+	}
+	return tenants, nil
+}
+
+// SetQuotas implements Tenanter.
+func (d *DB) SetQuotas(name string, quotas Quotas) (*Tenant, error) {
+	err := checkQuotas(quotas)
+	if err != nil {
+		return nil, err
+	}
+	var t *Tenant
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		ns := tx.Bucket([]byte(tenantPrefix + name))
+		if name == "" || ns == nil {
+			return ErrTenantNotFound
+		}
+		var err error
+		t, err = getTenant(ns)
+		if err != nil {
+			return err
+		}
+		t.Quotas = quotas
+		return putTenant(ns, t)
+	})
+	if err == ErrTenantNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "SetQuotas: DB.Update() failed")
+	}
+	return t, nil
+}
+
+// DropTenant implements Tenanter. Subscribers to the changes of the tenant
+// are disconnected, and its API keys revoked.
+func (d *DB) DropTenant(name string) error {
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		if name == "" || tx.Bucket([]byte(tenantPrefix+name)) == nil {
+			return ErrTenantNotFound
+		}
+		err := revokeTenantKeys(tx, name)
+		if err != nil {
+			return err
+		}
+		return tx.DeleteBucket([]byte(tenantPrefix + name))
+	})
+	if err == ErrTenantNotFound {
+		return err
+	}
+	if err != nil {
+		return errors.Wrap(err, "DropTenant: DB.Update() failed")
+	}
+	d.hubs.drop(name)
+	d.cache.invalidate(name, nil)
+	return nil
+}
+
+func checkQuotas(q Quotas) error {
+	fields := []FieldError{}
+	if q.MaxQuotes < 0 {
+		fields = append(fields, FieldError{"quotas.max_quotes", "invalid", "must not be negative"})
+	}
+	if q.MaxCollections < 0 {
+		fields = append(fields, FieldError{"quotas.max_collections", "invalid", "must not be negative"})
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// getTenant reads the Tenant record of the namespace tx, with its usage.
+// The default tenant has none and gets nil.
+func getTenant(tx namespace) (*Tenant, error) {
+	v := tx.Bucket([]byte(metaBucket)).Get(tenantKey)
+	if v == nil {
+		return nil, nil
+	}
+	t := &Tenant{}
+	err := json.Unmarshal(v, t)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decode tenant")
+	}
+	t.Quotes = int(tx.Bucket([]byte(slotBucket)).Sequence())
+	c := tx.Bucket([]byte(collectionBucket)).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil {
+			t.Collections++
+		}
+	}
+	return t, nil
+}
+
+func putTenant(tx namespace, t *Tenant) error {
+	v, err := json.Marshal(&Tenant{Name: t.Name, Quotas: t.Quotas, CreatedAt: t.CreatedAt})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(metaBucket)).Put(tenantKey, v)
+}
+
+// checkQuota fails with ErrQuotaExceeded if the tenant of tx may not store
+// another quote, or another collection if collections is set.
+func checkQuota(tx namespace, collections bool) error {
+	t, err := getTenant(tx)
+	if err != nil || t == nil {
+		return err
+	}
+	if !collections && t.Quotas.MaxQuotes > 0 && t.Quotes >= t.Quotas.MaxQuotes {
+		return errors.Wrapf(ErrQuotaExceeded, "tenant %s may store %d quotes", t.Name, t.Quotas.MaxQuotes)
+	}
+	if collections && t.Quotas.MaxCollections > 0 && t.Collections >= t.Quotas.MaxCollections {
+		return errors.Wrapf(ErrQuotaExceeded, "tenant %s may store %d collections", t.Name, t.Quotas.MaxCollections)
+	}
+	return nil
+}
+
+// hubs keeps the hub of every tenant that was asked for one.
+type hubs struct {
+	mu   sync.Mutex
+	hubs map[string]*hub
+}
+
+func newHubs(root *hub) *hubs {
+	return &hubs{hubs: map[string]*hub{"": root}}
+}
+
+func (h *hubs) get(name string) *hub {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hubs[name] == nil {
+		h.hubs[name] = newHub()
+	}
+	return h.hubs[name]
+}
+
+// drop ends the subscriptions of the tenant called name.
+func (h *hubs) drop(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hub := h.hubs[name]; hub != nil && name != "" {
+		hub.closeSubscribers()
+		delete(h.hubs, name)
+	}
+}
+
+func (h *hubs) closeSubscribers() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, hub := range h.hubs {
+		hub.closeSubscribers()
+	}
+}
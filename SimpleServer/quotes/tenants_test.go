@@ -0,0 +1,141 @@
+package quotes
+
+import (
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestDB_Tenants(t *testing.T) {
+	path := "testdata/tenantsdb"
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer os.Remove(path)
+
+	for _, name := range []string{"red", "blue"} {
+		err := d.CreateTenant(&Tenant{Name: name, Quotas: Quotas{MaxQuotes: 2, MaxCollections: 1}})
+		if err != nil {
+			t.Fatalf("CreateTenant(%s) error = %v", name, err)
+		}
+	}
+	for _, tenant := range []*Tenant{{Name: "Red"}, {Name: "-"}, {Name: "green", Quotas: Quotas{MaxQuotes: -1}}} {
+		if err := d.CreateTenant(tenant); !isValidationError(err) {
+			t.Errorf("CreateTenant(%q) error = %v, want a ValidationError", tenant.Name, err)
+		}
+	}
+	if err := d.CreateTenant(&Tenant{Name: "red"}); err != ErrExists {
+		t.Errorf("CreateTenant() twice error = %v, want ErrExists", err)
+	}
+
+	red, blue := d.ForTenant("red").(*DB), d.ForTenant("blue").(*DB)
+	for _, s := range []*DB{d, red, blue} {
+		err := s.Create(&Quote{Author: "Gopher", Text: "Errors are values, says " + s.tenant + ".", Tags: []string{s.tenant + "tag"}})
+		if err != nil {
+			t.Fatalf("Create() for %q error = %v", s.tenant, err)
+		}
+	}
+	err = blue.Create(&Quote{Author: "Rob Pike", Text: "Clear is better than clever."})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	// The same IDs and authors do not meet.
+	for _, s := range []*DB{d, red, blue} {
+		q, err := s.Get(1)
+		if err != nil || q.Text != "Errors are values, says "+s.tenant+"." {
+			t.Errorf("Get(1) for %q = %v, %v", s.tenant, q, err)
+		}
+		page, err := s.ListPage(ListOptions{Author: "Gopher"})
+		if err != nil || len(page.Quotes) != 1 {
+			t.Errorf("ListPage() for %q = %v, %v", s.tenant, page, err)
+		}
+		results, err := s.Search("errors", 10)
+		if err != nil || len(results) != 1 {
+			t.Errorf("Search() for %q = %v, %v", s.tenant, results, err)
+		}
+		tags, err := s.TagCounts()
+		if err != nil || len(tags) != 1 || tags[0].Tag != s.tenant+"tag" {
+			t.Errorf("TagCounts() for %q = %v, %v", s.tenant, tags, err)
+		}
+		events, err := s.Changes(0, 10)
+		if err != nil || len(events) == 0 || events[0].Quote.Text != "Errors are values, says "+s.tenant+"." {
+			t.Errorf("Changes() for %q = %v, %v", s.tenant, events, err)
+		}
+	}
+	if _, err := red.Get(2); err != ErrNotFound {
+		t.Errorf("Get() of a quote of another tenant error = %v, want ErrNotFound", err)
+	}
+	if _, err := red.Pick(1, PickFilter{Author: "Rob Pike"}); err != ErrNotFound {
+		t.Errorf("Pick() of a quote of another tenant error = %v, want ErrNotFound", err)
+	}
+
+	// Quotas.
+	if err := blue.Create(&Quote{Author: "Gopher", Text: "Don't panic."}); errors.Cause(err) != ErrQuotaExceeded {
+		t.Errorf("Create() over the quota error = %v, want ErrQuotaExceeded", err)
+	}
+	results, err := blue.Import([]*Quote{{Author: "Gopher", Text: "Don't panic."}}, ConflictError)
+	if err != nil || results[0].Action != ImportFailed || errors.Cause(results[0].Err) != ErrQuotaExceeded {
+		t.Errorf("Import() over the quota = %v, %v", results, err)
+	}
+	err = blue.CreateCollection(&Collection{Name: "Proverbs"})
+	if err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	if err := blue.CreateCollection(&Collection{Name: "More proverbs"}); errors.Cause(err) != ErrQuotaExceeded {
+		t.Errorf("CreateCollection() over the quota error = %v, want ErrQuotaExceeded", err)
+	}
+	tenant, err := d.SetQuotas("blue", Quotas{MaxQuotes: 3})
+	if err != nil || tenant.Quotes != 2 || tenant.Collections != 1 || tenant.Quotas.MaxQuotes != 3 {
+		t.Errorf("SetQuotas() = %+v, %v", tenant, err)
+	}
+	if err := blue.Create(&Quote{Author: "Gopher", Text: "Don't panic."}); err != nil {
+		t.Errorf("Create() after SetQuotas() error = %v", err)
+	}
+	if err := d.Create(&Quote{Author: "Gopher", Text: "Don't panic."}); err != nil {
+		t.Errorf("Create() for the default tenant error = %v", err)
+	}
+
+	tenants, err := d.Tenants()
+	if err != nil || len(tenants) != 2 || tenants[0].Name != "blue" || tenants[0].Quotes != 3 || tenants[1].Name != "red" || tenants[1].Quotes != 1 {
+		t.Errorf("Tenants() = %v, %v", tenants, err)
+	}
+
+	// Dropping a tenant ends its subscriptions and forgets its data, also
+	// in the cache.
+	events, cancel := red.Subscribe()
+	defer cancel()
+	err = d.DropTenant("red")
+	if err != nil {
+		t.Fatalf("DropTenant() error = %v", err)
+	}
+	if _, ok := <-events; ok {
+		t.Errorf("Subscribe() of a dropped tenant is still open")
+	}
+	if _, err := red.Get(1); errors.Cause(err) != ErrTenantNotFound {
+		t.Errorf("Get() of a dropped tenant error = %v, want ErrTenantNotFound", err)
+	}
+	if err := d.DropTenant("red"); err != ErrTenantNotFound {
+		t.Errorf("DropTenant() twice error = %v, want ErrTenantNotFound", err)
+	}
+	err = d.CreateTenant(&Tenant{Name: "red"})
+	if err != nil {
+		t.Fatalf("CreateTenant() again error = %v", err)
+	}
+	if _, err := red.Get(1); err != ErrNotFound {
+		t.Errorf("Get() of a recreated tenant error = %v, want ErrNotFound", err)
+	}
+
+	// Tenants are migrated and backed up with the database.
+	d.Close()
+	d, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() again error = %v", err)
+	}
+	defer d.Close()
+	if q, err := d.ForTenant("blue").Get(2); err != nil || q.Author != "Rob Pike" {
+		t.Errorf("Get() after reopening = %v, %v", q, err)
+	}
+}
@@ -0,0 +1,223 @@
+package quotes
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrExists is reported by Import for a record whose ID is already taken
+// when the conflict mode is ConflictError, and by AddToCollection for a
+// quote that is in the collection already.
+var ErrExists = errors.New("record already exists")
+
+// ConflictMode decides what Import does with a record whose ID exists.
+type ConflictMode int
+
+const (
+	// ConflictError reports ErrExists for the record.
+	ConflictError ConflictMode = iota
+	// ConflictSkip keeps the stored quote.
+	ConflictSkip
+	// ConflictUpsert replaces the stored quote.
+	ConflictUpsert
+)
+
+// ImportAction is what Import did with one record.
+type ImportAction string
+
+const (
+	ImportCreated ImportAction = "created"
+	ImportUpdated ImportAction = "updated"
+	ImportSkipped ImportAction = "skipped"
+	ImportFailed  ImportAction = "failed"
+)
+
+// ImportResult is the outcome of importing one record.
+type ImportResult struct {
+	Action ImportAction
+	// Err is set if Action is ImportFailed.
+	Err error
+}
+
+// Importer is implemented by stores that can write many quotes in one
+// transaction.
+type Importer interface {
+	Import(batch []*Quote, mode ConflictMode) ([]ImportResult, error)
+}
+
+// ForEacher is implemented by stores that can stream all quotes.
+type ForEacher interface {
+	ForEach(fn func(q *Quote) error) error
+}
+
+// Import writes a batch of quotes in one transaction. Quotes are
+// normalized and validated as by Create; invalid ones fail with a
+// *ValidationError. Quotes without an ID get a new one; quotes with an ID
+// keep it, and if it is taken, by a stored or a trashed quote, mode
+// decides what happens. Revisions are not checked: new quotes start at
+// revision 1, replaced ones get the next revision. A trashed quote that is
+// replaced comes out of the trash with its history. The result for batch[i] is at
+// index i. Record errors do not abort the batch; the returned error is set
+// only if the transaction fails, in which case nothing was written.
+// Concurrent calls share transactions if batching is on, see SetBatch.
+func (d *DB) Import(batch []*Quote, mode ConflictMode) ([]ImportResult, error) {
+	var results []ImportResult
+	// A batched transaction may run again, with the IDs given.
+	ids := make([]uint64, len(batch))
+	for i, q := range batch {
+		ids[i] = q.ID
+	}
+	err := d.batch("Import", func(tx namespace) error {
+		results = make([]ImportResult, len(batch))
+		bucket := tx.Bucket([]byte(quoteBucket))
+		for i, q := range batch {
+			q.ID = ids[i]
+			old, err := getQuote(tx, q.ID)
+			if err != nil {
+				return err
+			}
+			err = prepare(q)
+			if err == nil {
+				if id := findDuplicate(tx, q); id != 0 && !keepsText(q, old) {
+					err = duplicateError(id)
+				}
+			}
+			if err != nil {
+				results[i] = ImportResult{ImportFailed, err}
+				continue
+			}
+			if q.ID == 0 {
+				err := checkQuota(tx, false)
+				if err != nil {
+					results[i] = ImportResult{ImportFailed, err}
+					continue
+				}
+				id, err := bucket.NextSequence()
+				if err != nil {
+					return err
+				}
+				q.ID = id
+				q.Rev = 1
+				results[i].Action = ImportCreated
+				err = putQuote(tx, q, nil, d.actor())
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			trash := tx.Bucket([]byte(trashBucket))
+			trashed := trash.Get(itob(q.ID))
+			switch {
+			case old == nil && trashed == nil:
+				err := checkQuota(tx, false)
+				if err != nil {
+					results[i] = ImportResult{ImportFailed, err}
+					continue
+				}
+				q.Rev = 1
+				results[i].Action = ImportCreated
+				// Keep the sequence ahead of explicit IDs.
+				if q.ID > bucket.Sequence() {
+					err = bucket.SetSequence(q.ID)
+					if err != nil {
+						return err
+					}
+				}
+			case mode == ConflictUpsert && old != nil:
+				q.Rev = old.Rev + 1
+				results[i].Action = ImportUpdated
+			case mode == ConflictUpsert:
+				t, err := decodeTrashed(trashed)
+				if err != nil {
+					return errors.Wrapf(err, "cannot decode trashed record %d", q.ID)
+				}
+				err = checkQuota(tx, false)
+				if err != nil {
+					results[i] = ImportResult{ImportFailed, err}
+					continue
+				}
+				err = trash.Delete(itob(q.ID))
+				if err != nil {
+					return err
+				}
+				q.Rev = t.Rev + 1
+				results[i].Action = ImportUpdated
+			case mode == ConflictSkip:
+				results[i].Action = ImportSkipped
+				continue
+			default:
+				results[i] = ImportResult{ImportFailed, ErrExists}
+				continue
+			}
+			err = putQuote(tx, q, old, d.actor())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Import: DB.Update() failed")
+	}
+	return results, nil
+}
+
+// forEachPage is the number of quotes ForEach reads per transaction.
+var forEachPage = 500
+
+// ForEach calls fn for every quote, ordered by ID, without loading all of
+// them at once. Iteration stops at the first error, which is returned.
+// The quotes are read in pages, each in a short read transaction that has
+// ended before fn is called, so that a slow fn, like a client that reads
+// an export slowly, does not keep old pages of the file from being
+// reused. Quotes written meanwhile are seen if their ID is not passed yet.
+func (d *DB) ForEach(fn func(q *Quote) error) error {
+	var after uint64
+	for {
+		page := make([]*Quote, 0, forEachPage)
+		err := d.view("ForEach", func(tx namespace) error {
+			c := tx.Bucket([]byte(quoteBucket)).Cursor()
+			for k, v := c.Seek(itob(after + 1)); k != nil && len(page) < forEachPage; k, v = c.Next() {
+				q := &Quote{}
+				err := q.Deserialize(v)
+				if err != nil {
+					return errors.Wrapf(err, "ForEach: cannot deserialize record %d", btoi(k))
+				}
+				page = append(page, q)
+				after = btoi(k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, q := range page {
+			err := fn(q)
+			if err != nil {
+				return err
+			}
+		}
+		if len(page) < forEachPage {
+			return nil
+		}
+	}
+}
+
+// ForEach calls fn for every quote of s. Stores that are not a ForEacher
+// are listed completely first.
+func ForEach(s QuoteStore, fn func(q *Quote) error) error {
+	if f, ok := s.(ForEacher); ok {
+		return f.ForEach(fn)
+	}
+	all, err := s.List()
+	if err != nil {
+		return err
+	}
+	for _, q := range all {
+		err := fn(q)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package quotes
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDB_Import(t *testing.T) {
+	path := "testdata/importdb"
+
+	// Setup
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer func() {
+		// Teardown
+		err = d.Close()
+		if err != nil {
+			t.Errorf("Cannot close %s", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			t.Errorf("Cannot remove %s", path)
+		}
+	}()
+
+	err = d.Create(&Quote{Author: "Gopher", Text: "Errors are values."})
+	if err != nil {
+		t.Fatalf("Cannot fill test database: " + err.Error())
+	}
+
+	tests := []struct {
+		name    string
+		mode    ConflictMode
+		batch   []*Quote
+		want    []ImportResult
+		wantIDs []uint64
+		text1   string
+	}{
+		{
+			"NewAndExplicitIDs", ConflictError,
+			[]*Quote{{Author: "Rob Pike", Text: "Clear is better than clever."}, {ID: 10, Author: "Rob Pike", Text: "Don't panic."}},
+			[]ImportResult{{Action: ImportCreated}, {Action: ImportCreated}},
+			[]uint64{1, 2, 10}, "Errors are values.",
+		},
+		{
+			"Error", ConflictError,
+			[]*Quote{{ID: 1, Author: "Gopher", Text: "Changed"}},
+			[]ImportResult{{ImportFailed, ErrExists}},
+			[]uint64{1, 2, 10}, "Errors are values.",
+		},
+		{
+			"Skip", ConflictSkip,
+			[]*Quote{{ID: 1, Author: "Gopher", Text: "Changed"}},
+			[]ImportResult{{Action: ImportSkipped}},
+			[]uint64{1, 2, 10}, "Errors are values.",
+		},
+		{
+			// The sequence continues after the explicit ID 10.
+			"Upsert", ConflictUpsert,
+			[]*Quote{{ID: 1, Author: "Gopher", Text: "Changed"}, {Author: "Gopher", Text: "Gofmt's style is no one's favorite."}},
+			[]ImportResult{{Action: ImportUpdated}, {Action: ImportCreated}},
+			[]uint64{1, 2, 10, 11}, "Changed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.Import(tt.batch, tt.mode)
+			if err != nil {
+				t.Fatalf("DB.Import() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DB.Import() = %v, want %v", got, tt.want)
+			}
+
+			ids := []uint64{}
+			err = d.ForEach(func(q *Quote) error {
+				ids = append(ids, q.ID)
+				if q.ID == 1 && q.Text != tt.text1 {
+					t.Errorf("quote 1 text = %q, want %q", q.Text, tt.text1)
+				}
+				return nil
+			})
+			if err != nil || !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("DB.ForEach() IDs = %v, %v, want %v", ids, err, tt.wantIDs)
+			}
+		})
+	}
+
+	// The IDs of trashed quotes are taken as well.
+	err = d.Delete(10)
+	if err != nil {
+		t.Fatalf("DB.Delete() error = %v", err)
+	}
+	for _, tt := range []struct {
+		mode ConflictMode
+		want ImportResult
+	}{
+		{ConflictError, ImportResult{ImportFailed, ErrExists}},
+		{ConflictSkip, ImportResult{Action: ImportSkipped}},
+	} {
+		got, err := d.Import([]*Quote{{ID: 10, Author: "Rob Pike", Text: "Don't panic!"}}, tt.mode)
+		if err != nil || !reflect.DeepEqual(got, []ImportResult{tt.want}) {
+			t.Errorf("DB.Import() of a trashed ID in mode %d = %v, %v, want %v", tt.mode, got, err, tt.want)
+		}
+	}
+	if trash, err := d.Trash(); err != nil || len(trash) != 1 || trash[0].Text != "Don't panic." {
+		t.Errorf("DB.Trash() after importing its ID = %v, %v", trash, err)
+	}
+	got, err := d.Import([]*Quote{{ID: 10, Author: "Rob Pike", Text: "Don't panic!"}}, ConflictUpsert)
+	if err != nil || !reflect.DeepEqual(got, []ImportResult{{Action: ImportUpdated}}) {
+		t.Errorf("DB.Import() upserting a trashed ID = %v, %v", got, err)
+	}
+	if history, err := d.History(10); err != nil || len(history) != 2 || history[0].Text != "Don't panic." || history[1].Rev != 2 {
+		t.Errorf("DB.History() of an upserted trashed quote = %v, %v", history, err)
+	}
+	if _, err := d.Undelete(10); err != ErrNotFound {
+		t.Errorf("DB.Undelete() of an upserted trashed quote error = %v, want %v", err, ErrNotFound)
+	}
+
+	// ForEach reads page by page and holds no transaction while fn runs.
+	forEachPage = 2
+	defer func() { forEachPage = 500 }()
+	ids := []uint64{}
+	err = d.ForEach(func(q *Quote) error {
+		if n := d.db.Stats().OpenTxN; n != 0 {
+			t.Errorf("DB.ForEach() holds %d transactions while fn runs", n)
+		}
+		ids = append(ids, q.ID)
+		return nil
+	})
+	if want := []uint64{1, 2, 10, 11}; err != nil || !reflect.DeepEqual(ids, want) {
+		t.Errorf("DB.ForEach() in pages of 2 IDs = %v, %v, want %v", ids, err, want)
+	}
+
+	// Imported quotes are indexed.
+	results, err := d.Search("panic", 0)
+	if err != nil || len(results) != 1 || results[0].Quote.ID != 10 {
+		t.Errorf("DB.Search() = %v, %v, want quote 10", results, err)
+	}
+}
@@ -0,0 +1,207 @@
+package quotes
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const trashBucket = "trash"
+
+// TrashedQuote is a deleted quote that can still be undeleted.
+type TrashedQuote struct {
+	Quote
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// Trasher is implemented by stores that keep deleted quotes in a trash.
+type Trasher interface {
+	// Trash returns the trashed quotes, ordered by ID.
+	Trash() ([]*TrashedQuote, error)
+	// Undelete moves the quote with the given ID back out of the trash.
+	// It fails with ErrNotFound if the quote is not in the trash, with
+	// ErrExists if its ID has been taken again in the meantime, and with a
+	// *ValidationError if a quote created since nearly duplicates it.
+	Undelete(id uint64) (*Quote, error)
+	// PurgeTrash drops the quotes deleted before t for good and returns
+	// how many there were.
+	PurgeTrash(before time.Time) (int, error)
+}
+
+// Trash implements Trasher.
+func (d *DB) Trash() ([]*TrashedQuote, error) {
+	structList := []*TrashedQuote{}
+	err := d.view("Trash", func(tx namespace) error {
+		return tx.Bucket([]byte(trashBucket)).ForEach(func(k, v []byte) error {
+			t, err := decodeTrashed(v)
+			if err != nil {
+				return errors.Wrapf(err, "cannot decode trashed record %d", btoi(k))
+			}
+			structList = append(structList, t)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Trash: DB.View() failed")
+	}
+	return structList, nil
+}
+
+// Undelete implements Trasher. The quote keeps its ID and revision and is
+// published as created again.
+func (d *DB) Undelete(id uint64) (*Quote, error) {
+	var q *Quote
+	err := d.update("Undelete", func(tx namespace) error {
+		trash := tx.Bucket([]byte(trashBucket))
+		v := trash.Get(itob(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		t, err := decodeTrashed(v)
+		if err != nil {
+			return errors.Wrapf(err, "Undelete: cannot decode trashed record %d", id)
+		}
+		old, err := getQuote(tx, id)
+		if err != nil {
+			return errors.Wrap(err, "Undelete")
+		}
+		if old != nil {
+			return ErrExists
+		}
+		if id := findDuplicate(tx, &t.Quote); id != 0 {
+			return duplicateError(id)
+		}
+		err = checkQuota(tx, false)
+		if err != nil {
+			return err
+		}
+		q = &t.Quote
+		err = trash.Delete(itob(id))
+		if err != nil {
+			return err
+		}
+		return putQuote(tx, q, nil, d.actor())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// PurgeTrash implements Trasher. The history of purged quotes is dropped
+// as well.
+func (d *DB) PurgeTrash(before time.Time) (int, error) {
+	n := 0
+	err := d.update("PurgeTrash", func(tx namespace) error {
+		trash := tx.Bucket([]byte(trashBucket))
+		// Deleting while iterating makes the cursor skip keys.
+		expired := [][]byte{}
+		err := trash.ForEach(func(k, v []byte) error {
+			if len(v) < 8 || int64(btoi(v[:8])) < before.UnixNano() {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			err := trash.Delete(k)
+			if err != nil {
+				return err
+			}
+			err = deleteHistory(tx, btoi(k))
+			if err != nil {
+				return err
+			}
+		}
+		n = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "PurgeTrash: DB.Update() failed")
+	}
+	return n, nil
+}
+
+// trashQuote moves q from the quote bucket into the trash within tx and
+// takes it out of its collections.
+func trashQuote(tx namespace, q *Quote) error {
+	err := removeQuote(tx, q)
+	if err != nil {
+		return err
+	}
+	err = removeFromCollections(tx, q.ID)
+	if err != nil {
+		return err
+	}
+	v, err := encodeStamped(time.Now(), q)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(trashBucket)).Put(itob(q.ID), v)
+}
+
+// decodeTrashed decodes a value of the trash bucket.
+func decodeTrashed(v []byte) (*TrashedQuote, error) {
+	t, q, err := decodeStamped(v)
+	if err != nil {
+		return nil, err
+	}
+	return &TrashedQuote{Quote: *q, DeletedAt: t}, nil
+}
+
+// Trash implements Trasher.
+func (m *MemoryStore) Trash() ([]*TrashedQuote, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	structList := make([]*TrashedQuote, 0, len(m.trash))
+	for _, t := range m.trash {
+		t := t
+		t.Quote = clone(t.Quote)
+		structList = append(structList, &t)
+	}
+	sort.Slice(structList, func(i, j int) bool {
+		return structList[i].ID < structList[j].ID
+	})
+	return structList, nil
+}
+
+// Undelete implements Trasher.
+func (m *MemoryStore) Undelete(id uint64) (*Quote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.trash[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if _, ok := m.quotes[id]; ok {
+		return nil, ErrExists
+	}
+	if dup := m.findDuplicate(&t.Quote); dup != 0 {
+		return nil, duplicateError(dup)
+	}
+	delete(m.trash, id)
+	m.quotes[id] = t.Quote
+	q := clone(t.Quote)
+	return &q, nil
+}
+
+// PurgeTrash implements Trasher.
+func (m *MemoryStore) PurgeTrash(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for id, t := range m.trash {
+		if t.DeletedAt.Before(before) {
+			delete(m.trash, id)
+			delete(m.history, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,209 @@
+package quotes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// dedupBucket indexes quotes by the dedupKey of their text followed by
+// their ID.
+const dedupBucket = "dedup"
+
+// Length limits of quote fields, in characters.
+const (
+	MaxAuthorLen = 200
+	MaxTextLen   = 2000
+	MaxSourceLen = 300
+	MaxTags      = 20
+	MaxTagLen    = 50
+)
+
+// FieldError describes what is wrong with one field of a quote. Code is
+// one of "required", "too_long", "invalid" and "duplicate".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned by Create, Update and Import for a quote
+// that cannot be stored as it is.
+type ValidationError struct {
+	Fields []FieldError
+	// DuplicateOf is the ID of the quote that q nearly duplicates, if
+	// that is one of the problems.
+	DuplicateOf uint64
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "invalid quote: " + strings.Join(msgs, "; ")
+}
+
+// Normalize brings the fields of q into their canonical form: Unicode NFC,
+// typographic quotes and apostrophes replaced by ASCII ones, surrounding
+// whitespace trimmed, and runs of whitespace in author and source
+// collapsed. Line breaks in the text are kept as "\n". Tags are
+// normalized as well.
+func Normalize(q *Quote) {
+	q.Author = strings.Join(strings.Fields(canonicalize(q.Author)), " ")
+	q.Source = strings.Join(strings.Fields(canonicalize(q.Source)), " ")
+	text := strings.Replace(canonicalize(q.Text), "\r\n", "\n", -1)
+	q.Text = strings.TrimSpace(strings.Replace(text, "\r", "\n", -1))
+	for i, t := range q.Tags {
+		q.Tags[i] = canonicalize(t)
+	}
+	q.Tags = normalizeTags(q.Tags)
+}
+
+// smartQuotes maps typographic quotes to their ASCII counterparts.
+var smartQuotes = strings.NewReplacer(
+	"‘", "'", "’", "'", "‚", "'", "‛", "'", "′", "'",
+	"“", `"`, "”", `"`, "„", `"`, "‟", `"`, "″", `"`,
+	"«", `"`, "»", `"`,
+)
+
+// canonicalize returns s in NFC with ASCII quotes. Invalid UTF-8 is left
+// alone for Validate to report.
+func canonicalize(s string) string {
+	if !utf8.ValidString(s) {
+		return s
+	}
+	return smartQuotes.Replace(norm.NFC.String(s))
+}
+
+// Validate checks a normalized quote: author and text are required, all
+// fields must be valid UTF-8 within their length limits, and only the
+// text may contain line breaks and tabs. It returns a *ValidationError
+// or nil.
+func Validate(q *Quote) error {
+	e := &ValidationError{}
+	check := func(field, value string, max int, required, multiline bool) {
+		n := utf8.RuneCountInString(value)
+		switch {
+		case required && n == 0:
+			e.add(field, "required", "must not be empty")
+		case !utf8.ValidString(value):
+			e.add(field, "invalid", "must be valid UTF-8")
+		case n > max:
+			e.add(field, "too_long", fmt.Sprintf("must be at most %d characters long, not %d", max, n))
+		case strings.IndexFunc(value, func(r rune) bool { return isForbidden(r, multiline) }) >= 0:
+			e.add(field, "invalid", "must not contain control characters")
+		}
+	}
+	check("author", q.Author, MaxAuthorLen, true, false)
+	check("text", q.Text, MaxTextLen, true, true)
+	check("source", q.Source, MaxSourceLen, false, false)
+	if len(q.Tags) > MaxTags {
+		e.add("tags", "too_long", fmt.Sprintf("must be at most %d tags, not %d", MaxTags, len(q.Tags)))
+	}
+	for _, t := range q.Tags {
+		check("tags", t, MaxTagLen, false, false)
+	}
+	if len(e.Fields) > 0 {
+		return e
+	}
+	return nil
+}
+
+func (e *ValidationError) add(field, code, message string) {
+	e.Fields = append(e.Fields, FieldError{field, code, message})
+}
+
+// isForbidden reports whether r may not appear in a field.
+func isForbidden(r rune, multiline bool) bool {
+	if multiline && (r == '\n' || r == '\t') {
+		return false
+	}
+	return unicode.IsControl(r) || r == '\u2028' || r == '\u2029'
+}
+
+// prepare normalizes and validates q before it is stored.
+func prepare(q *Quote) error {
+	Normalize(q)
+	return Validate(q)
+}
+
+// duplicateError is the error for a quote whose text nearly duplicates
+// the quote with ID id.
+func duplicateError(id uint64) *ValidationError {
+	e := &ValidationError{DuplicateOf: id}
+	e.add("text", "duplicate", fmt.Sprintf("nearly duplicates quote %d", id))
+	return e
+}
+
+// keepsText reports whether q keeps the near-duplicate key of old, the
+// version it replaces, which may be nil. Quotes stored before duplicates
+// were rejected may share a key; they are not held against edits that
+// leave the text as it was.
+func keepsText(q, old *Quote) bool {
+	return old != nil && bytes.Equal(dedupKey(q.Text), dedupKey(old.Text))
+}
+
+// findDuplicate returns the ID of a quote other than q whose text nearly
+// duplicates that of q, or 0.
+func findDuplicate(tx namespace, q *Quote) uint64 {
+	prefix := dedupKey(q.Text)
+	if prefix == nil {
+		return 0
+	}
+	c := tx.Bucket([]byte(dedupBucket)).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		if id := btoi(k[len(prefix):]); id != q.ID {
+			return id
+		}
+	}
+	return 0
+}
+
+// indexText adds q to the near-duplicate index.
+func indexText(tx namespace, q *Quote) error {
+	if dedupKey(q.Text) == nil {
+		return nil
+	}
+	return tx.Bucket([]byte(dedupBucket)).Put(append(dedupKey(q.Text), itob(q.ID)...), nil)
+}
+
+// unindexText removes q from the near-duplicate index.
+func unindexText(tx namespace, q *Quote) error {
+	if dedupKey(q.Text) == nil {
+		return nil
+	}
+	return tx.Bucket([]byte(dedupBucket)).Delete(append(dedupKey(q.Text), itob(q.ID)...))
+}
+
+// dedupKey returns the key under which texts that differ only in case,
+// punctuation, diacritics and spacing collide. Texts without letters or
+// digits, all punctuation or emoji, have no key: they would all collide.
+func dedupKey(text string) []byte {
+	var b strings.Builder
+	space := false
+	for _, r := range norm.NFKD.String(text) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Diacritics, split off by NFKD.
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			space = true
+		}
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	h := sha256.Sum256([]byte(b.String()))
+	return h[:16]
+}
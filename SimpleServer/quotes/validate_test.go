@@ -0,0 +1,158 @@
+package quotes
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		q    Quote
+		want Quote
+	}{
+		{"Trim", Quote{Author: "  Rob \t Pike ", Text: "\n Clear is better than clever. \n", Source: " Go  Proverbs"},
+			Quote{Author: "Rob Pike", Text: "Clear is better than clever.", Source: "Go Proverbs"}},
+		{"SmartQuotes", Quote{Author: "Gopher", Text: "“Don’t panic.”"},
+			Quote{Author: "Gopher", Text: `"Don't panic."`}},
+		{"NFC", Quote{Author: "René", Text: "Cogito."},
+			Quote{Author: "René", Text: "Cogito."}},
+		{"LineBreaks", Quote{Author: "Gopher", Text: "One.\r\nTwo.\rThree."},
+			Quote{Author: "Gopher", Text: "One.\nTwo.\nThree."}},
+		{"Tags", Quote{Author: "Gopher", Text: "Go.", Tags: []string{" Go", "go", "Café"}},
+			Quote{Author: "Gopher", Text: "Go.", Tags: []string{"café", "go"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Normalize(&tt.q)
+			if !reflect.DeepEqual(tt.q, tt.want) {
+				t.Errorf("Normalize() = %#v, want %#v", tt.q, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		q    Quote
+		want []FieldError
+	}{
+		{"Valid", Quote{Author: "Gopher", Text: "Line one.\n\tLine two."}, nil},
+		{"Empty", Quote{}, []FieldError{
+			{"author", "required", "must not be empty"},
+			{"text", "required", "must not be empty"},
+		}},
+		{"TooLong", Quote{Author: strings.Repeat("é", MaxAuthorLen+1), Text: "Go."}, []FieldError{
+			{"author", "too_long", "must be at most 200 characters long, not 201"},
+		}},
+		{"ControlCharacters", Quote{Author: "Go\npher", Text: "Go. ", Source: "\x00"}, []FieldError{
+			{"author", "invalid", "must not contain control characters"},
+			{"text", "invalid", "must not contain control characters"},
+			{"source", "invalid", "must not contain control characters"},
+		}},
+		{"InvalidUTF8", Quote{Author: "Gopher", Text: "Go\xff."}, []FieldError{
+			{"text", "invalid", "must be valid UTF-8"},
+		}},
+		{"Tags", Quote{Author: "Gopher", Text: "Go.", Tags: []string{strings.Repeat("x", MaxTagLen+1)}}, []FieldError{
+			{"tags", "too_long", "must be at most 50 characters long, not 51"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(&tt.q)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			v, ok := err.(*ValidationError)
+			if !ok || !reflect.DeepEqual(v.Fields, tt.want) {
+				t.Errorf("Validate() error = %v, want fields %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	key := dedupKey("Don't panic.")
+	for _, text := range []string{"DON'T  PANIC!", "dón't panic", "  Don't\npanic..."} {
+		if string(dedupKey(text)) != string(key) {
+			t.Errorf("dedupKey(%q) differs from dedupKey(%q)", text, "Don't panic.")
+		}
+	}
+	for _, text := range []string{"Dont panic.", "Don't panic now."} {
+		if string(dedupKey(text)) == string(key) {
+			t.Errorf("dedupKey(%q) equals dedupKey(%q)", text, "Don't panic.")
+		}
+	}
+	for _, text := range []string{"...", "🙂 🙃", "?!"} {
+		if key := dedupKey(text); key != nil {
+			t.Errorf("dedupKey(%q) = %x, want none", text, key)
+		}
+	}
+}
+
+func TestQuoteStore_Validation(t *testing.T) {
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s, teardown := newStore()
+			defer teardown()
+
+			err := s.Create(&Quote{Author: " ", Text: "Nobody said this."})
+			if v, ok := err.(*ValidationError); !ok || v.Fields[0].Field != "author" {
+				t.Errorf("Create() without author error = %v, want a ValidationError", err)
+			}
+
+			a := &Quote{Author: " Gopher ", Text: "Errors are values."}
+			b := &Quote{Author: "Gopher", Text: "Don’t panic."}
+			for _, q := range []*Quote{a, b} {
+				err := s.Create(q)
+				if err != nil {
+					t.Fatalf("Create() error = %v", err)
+				}
+			}
+			got, err := s.Get(b.ID)
+			if err != nil || got.Author != "Gopher" || got.Text != "Don't panic." {
+				t.Errorf("Get() = %v, %v, want the normalized quote", got, err)
+			}
+
+			err = s.Create(&Quote{Author: "Rob Pike", Text: "errors are VALUES!"})
+			if v, ok := err.(*ValidationError); !ok || v.DuplicateOf != a.ID {
+				t.Errorf("Create() near-duplicate error = %v, want a duplicate of %d", err, a.ID)
+			}
+
+			// Rewording a quote does not make it its own duplicate.
+			a.Text = "Errors are values!"
+			err = s.Update(a)
+			if err != nil {
+				t.Errorf("Update() of itself error = %v", err)
+			}
+			b.Text = "errors are values"
+			err = s.Update(b)
+			if v, ok := err.(*ValidationError); !ok || v.DuplicateOf != a.ID {
+				t.Errorf("Update() near-duplicate error = %v, want a duplicate of %d", err, a.ID)
+			}
+
+			// Texts without words are never duplicates.
+			for _, text := range []string{"...", "🙂", "?!", "🙂"} {
+				err := s.Create(&Quote{Author: "Gopher", Text: text})
+				if err != nil {
+					t.Errorf("Create(%q) error = %v", text, err)
+				}
+			}
+
+			// A deleted quote is no longer a duplicate.
+			err = s.Delete(a.ID)
+			if err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			err = s.Create(&Quote{Author: "Rob Pike", Text: "Errors are values."})
+			if err != nil {
+				t.Errorf("Create() after Delete() error = %v", err)
+			}
+		})
+	}
+}
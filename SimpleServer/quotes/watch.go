@@ -0,0 +1,244 @@
+package quotes
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+const changeBucket = "changes"
+
+// changeLogSize is the number of events kept for replay.
+const changeLogSize = 10000
+
+// subscriberBuffer is the number of events a subscriber may fall behind
+// before it is dropped.
+const subscriberBuffer = 64
+
+// EventType tells what happened to a quote.
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated"
+	EventDeleted EventType = "deleted"
+)
+
+// Event describes one committed change to a quote.
+type Event struct {
+	// ID is the position of the event in the change log. IDs grow
+	// with every change.
+	ID   uint64    `json:"id"`
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Quote is the quote after the change, or the last version of a
+	// deleted quote.
+	Quote *Quote `json:"quote"`
+}
+
+// Watcher is implemented by stores that publish their changes.
+type Watcher interface {
+	// Subscribe returns a channel that receives every change committed
+	// after the call, and a function that ends the subscription. The
+	// channel is closed if the subscriber falls too far behind; it can
+	// then catch up with Changes.
+	Subscribe() (<-chan *Event, func())
+	// Changes returns at most limit logged events with an ID greater
+	// than after, oldest first. It fails with ErrTrimmed if some of them
+	// are no longer logged.
+	Changes(after uint64, limit int) ([]*Event, error)
+}
+
+// ErrTrimmed is returned when events were asked for that were already
+// trimmed from the change log. The caller has missed changes and must read
+// the quotes again.
+var ErrTrimmed = errors.New("change log trimmed")
+
+// hub fans out committed events to subscribers.
+type hub struct {
+	mu   sync.Mutex
+	subs map[chan *Event]bool
+	// last is the ID of the last event sent to subscribers.
+	last uint64
+}
+
+func newHub() *hub {
+	return &hub{subs: map[chan *Event]bool{}}
+}
+
+// Subscribe implements Watcher.
+func (d *DB) Subscribe() (<-chan *Event, func()) {
+	h := d.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subs) == 0 {
+		// Nobody tracked the log while there were no subscribers.
+		d.db.View(func(tx *bolt.Tx) error {
+			ns, err := d.namespace(tx)
+			if err != nil {
+				return err
+			}
+			h.last = ns.Bucket([]byte(changeBucket)).Sequence()
+			return nil
+		})
+	}
+	ch := make(chan *Event, subscriberBuffer)
+	h.subs[ch] = true
+
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.subs[ch] {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// Changes implements Watcher.
+func (d *DB) Changes(after uint64, limit int) ([]*Event, error) {
+	events := []*Event{}
+	err := d.view("Changes", func(tx namespace) error {
+		c := tx.Bucket([]byte(changeBucket)).Cursor()
+		k, v := c.Seek(itob(after + 1))
+		if k != nil && btoi(k) > after+1 {
+			return ErrTrimmed
+		}
+		for ; k != nil && len(events) < limit; k, v = c.Next() {
+			e, err := decodeEvent(v)
+			if err != nil {
+				return errors.Wrapf(err, "cannot decode event %d", btoi(k))
+			}
+			events = append(events, e)
+		}
+		return nil
+	})
+	if err == ErrTrimmed {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Changes: DB.View() failed")
+	}
+	return events, nil
+}
+
+// update runs fn in a read-write transaction on the namespace of the
+// tenant and, once it is committed,
+// publishes the changes fn logged and drops the cached results they touch.
+// All writes to quotes go through update. The transaction is traced and
+// timed as operation op, see view.
+func (d *DB) update(op string, fn func(tx namespace) error) error {
+	return d.write(op, d.db.Update, fn)
+}
+
+// write is update with the transaction run by run, bolt.DB.Update or
+// bolt.DB.Batch.
+func (d *DB) write(op string, run func(func(*bolt.Tx) error) error, fn func(tx namespace) error) error {
+	span := d.startSpan(op, true)
+	start := time.Now()
+	err := run(func(tx *bolt.Tx) error {
+		ns, err := d.namespace(tx)
+		if err != nil {
+			return err
+		}
+		before := ns.Bucket([]byte(changeBucket)).Sequence()
+		err = fn(ns)
+		if err != nil {
+			return err
+		}
+		return d.invalidateOnCommit(tx, ns, before)
+	})
+	d.txTimes.observe(op, time.Since(start))
+	endSpan(span, err)
+	if err == nil {
+		d.publish()
+	}
+	return err
+}
+
+// publish sends the events logged since the last publish to all
+// subscribers. A subscriber whose buffer is full is dropped, and so are
+// all if the log was trimmed past the last publish.
+func (d *DB) publish() {
+	h := d.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subs) == 0 {
+		return
+	}
+	events, err := d.Changes(h.last, changeLogSize)
+	if err == ErrTrimmed {
+		for ch := range h.subs {
+			delete(h.subs, ch)
+			close(ch)
+		}
+		return
+	}
+	if err != nil {
+		// The writer already committed; the subscribers get the
+		// events with the next publish.
+		d.logError("cannot publish changes", err, "tenant", d.tenant, "after", h.last)
+		return
+	}
+	for _, e := range events {
+		for ch := range h.subs {
+			select {
+			case ch <- e:
+			default:
+				delete(h.subs, ch)
+				close(ch)
+			}
+		}
+		h.last = e.ID
+	}
+}
+
+// closeSubscribers ends all subscriptions.
+func (h *hub) closeSubscribers() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+func decodeEvent(v []byte) (*Event, error) {
+	e := &Event{}
+	err := json.Unmarshal(v, e)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// logChange appends an event for q to the change log within tx and trims
+// the log to changeLogSize entries.
+func logChange(tx namespace, typ EventType, q *Quote) error {
+	changes := tx.Bucket([]byte(changeBucket))
+	id, err := changes.NextSequence()
+	if err != nil {
+		return fmt.Errorf("next change sequence: %s", err)
+	}
+
+	v, err := json.Marshal(&Event{ID: id, Type: typ, Time: time.Now().UTC(), Quote: q})
+	if err != nil {
+		return fmt.Errorf("can`t encode event: %s", err)
+	}
+	err = changes.Put(itob(id), v)
+	if err != nil {
+		return fmt.Errorf("put change log: %s", err)
+	}
+	if id > changeLogSize {
+		return changes.Delete(itob(id - changeLogSize))
+	}
+	return nil
+}
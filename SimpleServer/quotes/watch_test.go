@@ -0,0 +1,181 @@
+package quotes
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestDB_Watch(t *testing.T) {
+	path := "testdata/watchdb"
+
+	// Setup
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer func() {
+		// Teardown
+		os.Remove(path)
+	}()
+
+	// This change happens before the subscription and is only in the log.
+	err = d.Create(&Quote{Author: "Gopher", Text: "Errors are values."})
+	if err != nil {
+		t.Fatalf("DB.Create() error = %v", err)
+	}
+
+	events, cancel := d.Subscribe()
+	defer cancel()
+
+	q := &Quote{Author: "Rob Pike", Text: "Clear is better than clever."}
+	err = d.Create(q)
+	if err != nil {
+		t.Fatalf("DB.Create() error = %v", err)
+	}
+	q.Text = "Don't panic."
+	err = d.Update(q)
+	if err != nil {
+		t.Fatalf("DB.Update() error = %v", err)
+	}
+	err = d.Delete(q.ID)
+	if err != nil {
+		t.Fatalf("DB.Delete() error = %v", err)
+	}
+	// Failed writes are not published.
+	err = d.Update(&Quote{ID: 42})
+	if err == nil {
+		t.Fatal("DB.Update() of a missing quote succeeded")
+	}
+
+	want := []struct {
+		id   uint64
+		typ  EventType
+		text string
+	}{
+		{2, EventCreated, "Clear is better than clever."},
+		{3, EventUpdated, "Don't panic."},
+		{4, EventDeleted, "Don't panic."},
+	}
+	for _, w := range want {
+		select {
+		case e := <-events:
+			if e.ID != w.id || e.Type != w.typ || e.Quote.ID != q.ID || e.Quote.Text != w.text {
+				t.Errorf("event = %d %s %v, want %d %s %q", e.ID, e.Type, e.Quote, w.id, w.typ, w.text)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no event %d", w.id)
+		}
+	}
+
+	logged, err := d.Changes(1, 10)
+	if err != nil || len(logged) != 3 || logged[0].ID != 2 {
+		t.Errorf("DB.Changes(1) = %v, %v, want events 2 to 4", logged, err)
+	}
+	logged, err = d.Changes(0, 2)
+	if err != nil || len(logged) != 2 || logged[0].Type != EventCreated || logged[0].Quote.Author != "Gopher" {
+		t.Errorf("DB.Changes(0, 2) = %v, %v, want events 1 and 2", logged, err)
+	}
+
+	// Events that were trimmed from the log are missed.
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(changeBucket)).Delete(itob(1))
+	})
+	if err != nil {
+		t.Fatalf("cannot trim the change log: %v", err)
+	}
+	if _, err := d.Changes(0, 10); err != ErrTrimmed {
+		t.Errorf("DB.Changes(0) of a trimmed log error = %v, want ErrTrimmed", err)
+	}
+	if logged, err := d.Changes(1, 10); err != nil || len(logged) != 3 {
+		t.Errorf("DB.Changes(1) of a trimmed log = %v, %v", logged, err)
+	}
+
+	// Close ends the subscription.
+	err = d.Close()
+	if err != nil {
+		t.Errorf("Cannot close %s", path)
+	}
+	if _, ok := <-events; ok {
+		t.Error("subscription still open after Close()")
+	}
+}
+
+func TestDB_WatchSlowSubscriber(t *testing.T) {
+	path := "testdata/watchslowdb"
+
+	// Setup
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer func() {
+		// Teardown
+		d.Close()
+		os.Remove(path)
+	}()
+
+	events, cancel := d.Subscribe()
+	defer cancel()
+
+	batch := []*Quote{}
+	for i := 0; i <= subscriberBuffer; i++ {
+		batch = append(batch, &Quote{Author: "Gopher", Text: fmt.Sprintf("Errors are values, %d.", i)})
+	}
+	_, err = d.Import(batch, ConflictError)
+	if err != nil {
+		t.Fatalf("DB.Import() error = %v", err)
+	}
+
+	n := 0
+	for range events {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("slow subscriber got %d events before it was dropped, want %d", n, subscriberBuffer)
+	}
+}
+
+func TestDB_WatchErrorLog(t *testing.T) {
+	path := "testdata/watcherrdb"
+
+	// Setup
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(): Cannot open %s", path)
+	}
+	defer func() {
+		// Teardown
+		d.Close()
+		os.Remove(path)
+	}()
+	logged := []string{}
+	d.SetErrorLog(func(_ context.Context, msg string, err error, kv ...interface{}) {
+		logged = append(logged, fmt.Sprint(msg, ": ", err, kv))
+	})
+
+	_, cancel := d.Subscribe()
+	defer cancel()
+
+	// A broken event cannot be published, which goes to the error log.
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		changes := tx.Bucket([]byte(changeBucket))
+		seq, err := changes.NextSequence()
+		if err != nil {
+			return err
+		}
+		return changes.Put(itob(seq), []byte("{"))
+	})
+	if err != nil {
+		t.Fatalf("cannot write broken event: %v", err)
+	}
+	d.publish()
+	if len(logged) != 1 || !strings.HasPrefix(logged[0], "cannot publish changes: ") || !strings.HasSuffix(logged[0], "[tenant  after 0]") {
+		t.Errorf("error log = %q, want a failed publish", logged)
+	}
+}
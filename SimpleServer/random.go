@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	// Time zones for ?tz= must not depend on the tzdata of the host.
+	_ "time/tzdata"
+
+	"test/quotes"
+)
+
+// GET random quote handler. Picks uniformly among all quotes or those of
+// ?author= and ?tag= (see tagFilter). A ?seed= makes the pick repeatable
+// while the quotes do not change.
+func (app *App) handleRandom(w http.ResponseWriter, r *http.Request) {
+	filter, ok := pickFilter(w, r)
+	if !ok {
+		return
+	}
+	var n uint64
+	if seed := r.URL.Query().Get("seed"); seed != "" {
+		n = hashString(seed)
+	} else {
+		var b [8]byte
+		_, err := rand.Read(b[:])
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	app.writePick(w, r, n, filter)
+}
+
+// GET quote of the day handler. The quote is the same for everybody on a
+// calendar day, see quotes.PickDaily: stores that keep the pick keep it
+// for the whole day, across restarts and replicas, whatever is created or
+// deleted meanwhile. The day is today in the time zone ?tz= (UTC by
+// default) or ?date=YYYY-MM-DD; ?author= and ?tag= restrict the quotes to
+// choose from.
+func (app *App) handleDaily(w http.ResponseWriter, r *http.Request) {
+	filter, ok := pickFilter(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	loc := time.UTC
+	if tz := query.Get("tz"); tz != "" {
+		var err error
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", "unknown time zone "+strconv.Quote(tz))
+			return
+		}
+	}
+	date := time.Now().In(loc).Format("2006-01-02")
+	if d := query.Get("date"); d != "" {
+		_, err := time.Parse("2006-01-02", d)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid date "+strconv.Quote(d))
+			return
+		}
+		date = d
+	}
+
+	key := strings.Join(append([]string{filter.Author, strconv.FormatBool(filter.AnyTag)}, filter.Tags...), "\x00")
+	q, err := quotes.PickDaily(app.store(r), date, key, filter)
+	if err == quotes.ErrNotFound {
+		writeError(w, http.StatusNotFound, "not_found", "no quote matches")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writePicked(w, r, q)
+}
+
+// writePick answers with the quote quotes.Pick chooses for n.
+func (app *App) writePick(w http.ResponseWriter, r *http.Request, n uint64, filter quotes.PickFilter) {
+	if q := app.pick(w, r, n, filter); q != nil {
+		writePicked(w, r, q)
+	}
+}
+
+// pick returns the quote quotes.Pick chooses for n, or writes the error
+// and returns nil.
+func (app *App) pick(w http.ResponseWriter, r *http.Request, n uint64, filter quotes.PickFilter) *quotes.Quote {
+	q, err := quotes.Pick(app.store(r), n, filter)
+	if err == quotes.ErrNotFound {
+		writeError(w, http.StatusNotFound, "not_found", "no quote matches")
+		return nil
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return nil
+	}
+	return q
+}
+
+func writePicked(w http.ResponseWriter, r *http.Request, q *quotes.Quote) {
+	w.Header().Set("ETag", etag(r, q.Rev))
+	writeJSON(w, http.StatusOK, q)
+}
+
+// pickFilter reads the filter of a random or daily pick from the query.
+func pickFilter(w http.ResponseWriter, r *http.Request) (quotes.PickFilter, bool) {
+	filter := quotes.PickFilter{Author: r.URL.Query().Get("author")}
+	var ok bool
+	filter.Tags, filter.AnyTag, ok = tagFilter(w, r.URL.Query())
+	return filter, ok
+}
+
+// hashString returns the 64-bit FNV-1a hash of s.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
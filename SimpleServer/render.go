@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"test/quotes"
+)
+
+// Media types of API responses.
+const (
+	jsonType     = "application/json"
+	textType     = "text/plain"
+	htmlType     = "text/html"
+	markdownType = "text/markdown"
+	rssType      = "application/rss+xml"
+	atomType     = "application/atom+xml"
+)
+
+// quoteTypes are the media types a quote is available as, and listTypes
+// those of a list of quotes. The first is the default.
+var (
+	quoteTypes = []string{jsonType, textType, htmlType, markdownType}
+	listTypes  = []string{jsonType, textType, htmlType, markdownType, rssType, atomType}
+)
+
+// negotiate picks the media type of the response to r from offers, see
+// preferred. If none is acceptable it writes a 406 and returns "". The
+// response varies by the Accept header either way.
+func negotiate(w http.ResponseWriter, r *http.Request, offers []string) string {
+	w.Header().Add("Vary", "Accept")
+	mediaType := preferred(r, offers)
+	if mediaType == "" {
+		writeError(w, http.StatusNotAcceptable, "not_acceptable", "this resource is only available as "+strings.Join(offers, ", "))
+	}
+	return mediaType
+}
+
+// cardTemplate renders quotes as HTML cards that can be embedded into
+// other pages. The class names are the styling hooks.
+var cardTemplate = template.Must(template.New("cards").Funcs(template.FuncMap{
+	"lines": func(s string) []string { return strings.Split(s, "\n") },
+}).Parse(`
+{{- define "card" -}}
+<figure class="quote" id="quote-{{.ID}}">
+  <blockquote class="quote-text">
+    {{- range $i, $line := lines .Text}}{{if $i}}<br>{{end}}{{$line}}{{end -}}
+  </blockquote>
+  <figcaption class="quote-author">{{.Author}}{{if .Source}}, <cite class="quote-source">{{.Source}}</cite>{{end}}</figcaption>
+  {{- if .Tags}}
+  <ul class="quote-tags">{{range .Tags}}<li>{{.}}</li>{{end}}</ul>
+  {{- end}}
+</figure>
+{{end -}}
+{{- define "list" -}}
+<section class="quotes">
+{{range .}}{{template "card" .}}{{end -}}
+</section>
+{{end -}}
+`))
+
+// markdownEscaper escapes the characters that would start Markdown markup
+// inside a quote.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`,
+)
+
+// markdown renders q as a Markdown blockquote with the author below.
+func markdown(q *quotes.Quote) string {
+	var b strings.Builder
+	for _, line := range strings.Split(q.Text, "\n") {
+		b.WriteString("> " + markdownEscaper.Replace(line) + "\n")
+	}
+	b.WriteString(">\n> — " + markdownEscaper.Replace(q.Author))
+	if q.Source != "" {
+		b.WriteString(", *" + markdownEscaper.Replace(q.Source) + "*")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// writeQuote writes q as mediaType, one of quoteTypes.
+func writeQuote(w http.ResponseWriter, r *http.Request, status int, mediaType string, q *quotes.Quote) {
+	var b bytes.Buffer
+	switch mediaType {
+	case textType:
+		b.WriteString(q.String())
+	case htmlType:
+		err := cardTemplate.ExecuteTemplate(&b, "card", q)
+		if err != nil {
+			writeRenderError(w, r, err)
+			return
+		}
+	case markdownType:
+		b.WriteString(markdown(q))
+	default:
+		writeJSON(w, status, q)
+		return
+	}
+	writeBody(w, status, mediaType, b.Bytes())
+}
+
+// writeQuotes writes a page of quotes as mediaType, one of listTypes. The
+// feeds link to the quotes and to the next page and are dated modified,
+// or now if that is unknown.
+func writeQuotes(w http.ResponseWriter, r *http.Request, mediaType string, page *quotes.Page, modified time.Time) {
+	var b bytes.Buffer
+	switch mediaType {
+	case textType:
+		for i, q := range page.Quotes {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(q.String())
+		}
+	case htmlType:
+		err := cardTemplate.ExecuteTemplate(&b, "list", page.Quotes)
+		if err != nil {
+			writeRenderError(w, r, err)
+			return
+		}
+	case markdownType:
+		for i, q := range page.Quotes {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(markdown(q))
+		}
+	case rssType, atomType:
+		if modified.IsZero() {
+			modified = time.Now()
+		}
+		var feed interface{}
+		if mediaType == rssType {
+			feed = rssFeedOf(r, page, modified)
+		} else {
+			feed = atomFeedOf(r, page, modified)
+		}
+		b.WriteString(xml.Header)
+		err := xml.NewEncoder(&b).Encode(feed)
+		if err != nil {
+			writeRenderError(w, r, err)
+			return
+		}
+	default:
+		writeJSON(w, http.StatusOK, page)
+		return
+	}
+	writeBody(w, http.StatusOK, mediaType, b.Bytes())
+}
+
+func writeBody(w http.ResponseWriter, status int, mediaType string, body []byte) {
+	w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeRenderError(w http.ResponseWriter, r *http.Request, err error) {
+	logs.error(r.Context(), "cannot render response", err)
+	writeError(w, http.StatusInternalServerError, "internal_error", "internal error")
+}
+
+// feedLinks returns the absolute URLs of the listing r asked for, of its
+// next page, and a function that returns the URL of a quote. The links
+// name the tenant in the path even if it was chosen with the X-Tenant
+// header, which feed readers do not send.
+func feedLinks(r *http.Request, page *quotes.Page) (self, next string, quoteURL func(id uint64) string) {
+	base := &url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		base.Scheme = "https"
+	}
+	// The list is at .../quotes and a quote at .../quote/{id}.
+	list := *r.URL
+	list.Path = strings.TrimSuffix(list.Path, "/")
+	dir := path.Dir(list.Path)
+	if tenant := requestTenant(r); tenant != "" && pathParam(r, "tenant") == "" {
+		dir = path.Join(dir, "tenants", tenant)
+		list.Path = path.Join(dir, path.Base(list.Path))
+		list.RawPath = ""
+	}
+	self = base.ResolveReference(&list).String()
+	if page.NextCursor != "" {
+		u := list
+		query := u.Query()
+		query.Set("cursor", page.NextCursor)
+		u.RawQuery = query.Encode()
+		next = base.ResolveReference(&u).String()
+	}
+	quoteURL = func(id uint64) string {
+		return base.ResolveReference(&url.URL{Path: path.Join(dir, "quote", strconv.FormatUint(id, 10))}).String()
+	}
+	return self, next, quoteURL
+}
+
+// feedTitle returns the title of the feed entry of q: the start of its
+// text, by its author.
+func feedTitle(q *quotes.Quote) string {
+	const max = 60
+	text := strings.Join(strings.Fields(q.Text), " ")
+	if utf8.RuneCountInString(text) > max {
+		text = string([]rune(text)[:max-1]) + "…"
+	}
+	return q.Author + ": " + text
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Links         []atomLink `xml:"atom:link"`
+	Items         []rssItem  `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	Categories  []string `xml:"category"`
+	GUID        string   `xml:"guid"`
+}
+
+func rssFeedOf(r *http.Request, page *quotes.Page, modified time.Time) *rssFeed {
+	self, next, quoteURL := feedLinks(r, page)
+	feed := &rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         "Quotes",
+			Link:          self,
+			Description:   "Quotes from the quote store.",
+			LastBuildDate: modified.UTC().Format(time.RFC1123Z),
+			Links:         []atomLink{{Rel: "self", Href: self, Type: rssType}},
+			Items:         []rssItem{},
+		},
+	}
+	if next != "" {
+		feed.Channel.Links = append(feed.Channel.Links, atomLink{Rel: "next", Href: next, Type: rssType})
+	}
+	for _, q := range page.Quotes {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       feedTitle(q),
+			Link:        quoteURL(q.ID),
+			Description: q.String(),
+			Categories:  q.Tags,
+			GUID:        quoteURL(q.ID),
+		})
+	}
+	return feed
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Link       atomLink       `xml:"link"`
+	Content    atomContent    `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// atomFeedOf builds an Atom feed of page. The entries are dated like the
+// feed, since the list does not say when each quote changed.
+func atomFeedOf(r *http.Request, page *quotes.Page, modified time.Time) *atomFeed {
+	self, next, quoteURL := feedLinks(r, page)
+	updated := modified.UTC().Format(time.RFC3339)
+	feed := &atomFeed{
+		Title:   "Quotes",
+		ID:      self,
+		Updated: updated,
+		Links:   []atomLink{{Rel: "self", Href: self, Type: atomType}},
+		Entries: []atomEntry{},
+	}
+	if next != "" {
+		feed.Links = append(feed.Links, atomLink{Rel: "next", Href: next, Type: atomType})
+	}
+	for _, q := range page.Quotes {
+		entry := atomEntry{
+			Title:   feedTitle(q),
+			ID:      quoteURL(q.ID),
+			Updated: updated,
+			Author:  atomPerson{q.Author},
+			Link:    atomLink{Rel: "alternate", Href: quoteURL(q.ID)},
+			Content: atomContent{"text", q.String()},
+		}
+		for _, t := range q.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{t})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"test/trace"
+)
+
+// route is an entry of the route table. Segments of the pattern written as
+// {name} match any single path segment, see pathParam.
+type route struct {
+	method   string
+	segments []string
+	// produces is the media type of successful responses, checked
+	// against the Accept header. It is empty for routes that choose the
+	// type themselves.
+	produces string
+	handler  http.HandlerFunc
+}
+
+// router dispatches requests under prefix by method and path. Paths that
+// match no pattern get a 404, paths that match only with another method a
+// 405 with an Allow header, both as JSON errors.
+type router struct {
+	prefix string
+	routes []route
+	// metrics records every request if it is set.
+	metrics *metrics
+}
+
+func newRouter(prefix string) *router {
+	return &router{prefix: prefix}
+}
+
+// handle adds a route for method and pattern, relative to the prefix.
+func (rt *router) handle(method, pattern, produces string, handler http.HandlerFunc) {
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		segments: splitPath(pattern),
+		produces: produces,
+		handler:  handler,
+	})
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	span := trace.FromContext(r.Context())
+	if rt.metrics == nil {
+		pattern := rt.dispatch(w, r)
+		span.SetName(r.Method + " " + pattern)
+		return
+	}
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w}
+	pattern := rt.dispatch(rec, r)
+	span.SetName(r.Method + " " + pattern)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rt.metrics.observe(pattern, r.Method, rec.status, time.Since(start))
+}
+
+// dispatch serves r and returns the pattern of the route it matched,
+// including the prefix, or "unmatched".
+func (rt *router) dispatch(w http.ResponseWriter, r *http.Request) string {
+	path := splitPath(strings.TrimPrefix(r.URL.Path, rt.prefix))
+	allowed := []string{}
+	for _, route := range rt.routes {
+		params, ok := route.match(path)
+		if !ok {
+			continue
+		}
+		if route.method != r.Method && !(route.method == "GET" && r.Method == "HEAD") {
+			allowed = append(allowed, route.method)
+			if route.method == "GET" {
+				allowed = append(allowed, "HEAD")
+			}
+			continue
+		}
+		if route.produces != "" && !accepts(r, route.produces) {
+			writeError(w, http.StatusNotAcceptable, "not_acceptable", "this resource is only available as "+route.produces)
+			return route.pattern(rt.prefix)
+		}
+		if len(params) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+		}
+		route.handler(w, r)
+		return route.pattern(rt.prefix)
+	}
+
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method "+r.Method+" is not allowed")
+		return "unmatched"
+	}
+	writeError(w, http.StatusNotFound, "not_found", "no such resource "+r.URL.Path)
+	return "unmatched"
+}
+
+// pattern returns the pattern of route under prefix, for example
+// "/api/v1/quote/{id}".
+func (route route) pattern(prefix string) string {
+	return prefix + strings.Join(route.segments, "/")
+}
+
+// match reports whether path matches the pattern of route and returns the
+// values of its parameters.
+func (route route) match(path []string) (map[string]string, bool) {
+	if len(path) != len(route.segments) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, s := range route.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[s[1:len(s)-1]] = path[i]
+			continue
+		}
+		if s != path[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// splitPath splits a path into its segments, ignoring leading and
+// trailing slashes.
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+type paramsKey struct{}
+
+// pathParam returns the path segment that matched {name} in the route
+// pattern.
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+// pathID parses the path parameter name as a quote ID or revision. It
+// writes a 400 error and returns false if it is not a positive number.
+func pathID(w http.ResponseWriter, r *http.Request, name string) (uint64, bool) {
+	id, err := strconv.ParseUint(pathParam(r, name), 10, 64)
+	if err != nil || id == 0 {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid "+name+" "+strconv.Quote(pathParam(r, name)))
+		return 0, false
+	}
+	return id, true
+}
+
+// accepts reports whether the Accept header of r allows mediaType. A
+// missing header accepts anything.
+func accepts(r *http.Request, mediaType string) bool {
+	return quality(r.Header.Get("Accept"), mediaType) > 0
+}
+
+// preferred returns the media type of offers that the Accept header of r
+// rates highest, the earlier one on a tie, or "" if it accepts none. A
+// missing header prefers the first offer.
+func preferred(r *http.Request, offers []string) string {
+	header := r.Header.Get("Accept")
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := quality(header, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// quality returns the q value that an Accept header gives mediaType,
+// taken from its most specific matching range: "text/html" before
+// "text/*" before "*/*". An empty header accepts anything with q=1.
+func quality(header, mediaType string) float64 {
+	if header == "" {
+		return 1
+	}
+	major := mediaType[:strings.Index(mediaType, "/")]
+	q, specificity := 0.0, 0
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		s := 0
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case mediaType:
+			s = 3
+		case major + "/*":
+			s = 2
+		case "*/*":
+			s = 1
+		}
+		if s <= specificity {
+			continue
+		}
+		q, specificity = 1, s
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				q, _ = strconv.ParseFloat(p[2:], 64)
+			}
+		}
+	}
+	return q
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// envPrefix starts the environment variables that configure the server,
+// see flagsFromEnv.
+const envPrefix = "QUOTES_"
+
+// flagsFromEnv sets every flag of fs that was not given on the command
+// line from the environment variable named after it: -trash-retention is
+// read from QUOTES_TRASH_RETENTION, for example.
+func flagsFromEnv(fs *flag.FlagSet) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		v, ok := os.LookupEnv(name)
+		if given[f.Name] || !ok || err != nil {
+			return
+		}
+		if e := fs.Set(f.Name, v); e != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", v, name, e)
+		}
+	})
+	return err
+}
+
+// serve runs server until it fails or the process gets SIGINT or SIGTERM.
+// On a signal, it stops accepting connections and waits up to grace for
+// the requests in flight, so that the caller can close the store cleanly.
+// Long-running streams are told to end through their request context.
+// The server serves HTTPS if certFile and keyFile are set.
+func serve(server *http.Server, certFile, keyFile string, grace time.Duration) error {
+	base, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server.BaseContext = func(net.Listener) context.Context { return base }
+	server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, connKey{}, c)
+	}
+	server.RegisterOnShutdown(cancel)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			errc <- server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			errc <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+	logs.info(context.Background(), "shutting down", "grace", grace.String())
+
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), grace)
+	defer cancelShutdown()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		server.Close()
+		return fmt.Errorf("shutdown: %v", err)
+	}
+	return nil
+}
+
+type connKey struct{}
+
+// noWriteTimeout lifts the write timeout of the server for the connection
+// of r, for handlers that stream their response for longer.
+func noWriteTimeout(r *http.Request) {
+	if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+		c.SetWriteDeadline(time.Time{})
+	}
+}
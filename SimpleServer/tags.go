@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"test/quotes"
+)
+
+// GET tag handler, lists every tag in use with the number of its quotes.
+func (app *App) handleTags(w http.ResponseWriter, r *http.Request) {
+	tagger, ok := app.store(r).(quotes.Tagger)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "not_implemented", "tags are not supported by this store")
+		return
+	}
+	counts, err := tagger.TagCounts()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, counts)
+}
+
+// tagFilter reads the tags a quote must have from ?tag=, which may be
+// repeated. ?tag_match=all (the default) requires all of them,
+// ?tag_match=any one of them. It writes a 400 and returns false for an
+// unknown tag_match.
+func tagFilter(w http.ResponseWriter, query url.Values) (tags []string, any bool, ok bool) {
+	switch match := query.Get("tag_match"); match {
+	case "", "all":
+	case "any":
+		any = true
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid tag_match "+match)
+		return nil, false, false
+	}
+	return query["tag"], any, true
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"test/quotes"
+)
+
+// tenantHeader selects the tenant of a request, like the path segments
+// tenants/{tenant}/ in front of a route. Without either, requests go to
+// the default tenant.
+const tenantHeader = "X-Tenant"
+
+// tenantKey is the context key of the tenant a request was made for.
+type tenantKey struct{}
+
+// requestTenant returns the tenant r was made for, "" for the default
+// tenant.
+func requestTenant(r *http.Request) string {
+	tenant, _ := r.Context().Value(tenantKey{}).(string)
+	return tenant
+}
+
+// tenantRoutes makes the routes of rt serve the tenant named by the
+// X-Tenant header, and serves them for a tenant under tenants/{tenant}/ as
+// well. The admin routes are not per tenant.
+func (app *App) tenantRoutes(rt *router) {
+	routes := []route{}
+	tenanted := []route{}
+	for _, route := range rt.routes {
+		if len(route.segments) > 0 && route.segments[0] == "admin" {
+			routes = append(routes, route)
+			continue
+		}
+		route.handler = app.selectTenant(route.handler)
+		routes = append(routes, route)
+		route.segments = append([]string{"tenants", "{tenant}"}, route.segments...)
+		tenanted = append(tenanted, route)
+	}
+	rt.routes = append(routes, tenanted...)
+}
+
+// selectTenant returns a handler that runs next for the tenant named in the
+// path or the X-Tenant header. It answers 404 for an unknown tenant and 501
+// if the store has no tenants. With API keys, the quotes of a tenant are
+// only served to the holders of its keys, reads included.
+func (app *App) selectTenant(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, header := pathParam(r, "tenant"), r.Header.Get(tenantHeader)
+		if name != "" && header != "" && name != header {
+			writeError(w, http.StatusBadRequest, "bad_request", "the path and the "+tenantHeader+" header name different tenants")
+			return
+		}
+		if name == "" {
+			name = header
+		}
+		if name == "" {
+			next(w, r)
+			return
+		}
+		tenanter := app.tenanter(w)
+		if tenanter == nil {
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), tenantKey{}, name))
+		if app.keys != nil {
+			key := app.authenticate(w, r)
+			if key == nil || !authorize(w, r, key) {
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyKey{}, key))
+		}
+		_, err := tenanter.Tenant(name)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// tenanter returns the store as a quotes.Tenanter, or writes a 501 and
+// returns nil.
+func (app *App) tenanter(w http.ResponseWriter) quotes.Tenanter {
+	tenanter, ok := app.db.(quotes.Tenanter)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "not_implemented", "tenants are not supported by this store")
+		return nil
+	}
+	return tenanter
+}
+
+// GET admin tenants handler, lists the tenants with their quotas and
+// usage.
+func (app *App) listTenants(w http.ResponseWriter, r *http.Request) {
+	tenanter := app.tenanter(w)
+	if tenanter == nil {
+		return
+	}
+	tenants, err := tenanter.Tenants()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tenants)
+}
+
+// POST admin tenants handler. Takes a name and quotas, and answers 201
+// with the tenant and its Location.
+func (app *App) createTenant(w http.ResponseWriter, r *http.Request) {
+	tenanter := app.tenanter(w)
+	if tenanter == nil {
+		return
+	}
+	t := &quotes.Tenant{}
+	if !decodeBody(w, r, t) {
+		return
+	}
+	err := tenanter.CreateTenant(t)
+	if errors.Cause(err) == quotes.ErrExists {
+		writeError(w, http.StatusConflict, "conflict", "tenant "+t.Name+" exists")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+t.Name)
+	writeJSON(w, http.StatusCreated, t)
+}
+
+// GET admin tenant handler.
+func (app *App) getTenant(w http.ResponseWriter, r *http.Request) {
+	tenanter := app.tenanter(w)
+	if tenanter == nil {
+		return
+	}
+	t, err := tenanter.Tenant(pathParam(r, "tenant"))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// PUT admin tenant quotas handler, takes the new quotas and answers with
+// the tenant.
+func (app *App) setQuotas(w http.ResponseWriter, r *http.Request) {
+	tenanter := app.tenanter(w)
+	if tenanter == nil {
+		return
+	}
+	var quotas quotes.Quotas
+	if !decodeBody(w, r, &quotas) {
+		return
+	}
+	t, err := tenanter.SetQuotas(pathParam(r, "tenant"), quotas)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// DELETE admin tenant handler, drops a tenant with all its quotes.
+func (app *App) dropTenant(w http.ResponseWriter, r *http.Request) {
+	tenanter := app.tenanter(w)
+	if tenanter == nil {
+		return
+	}
+	err := tenanter.DropTenant(pathParam(r, "tenant"))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
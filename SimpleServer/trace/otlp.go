@@ -0,0 +1,253 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// batchSize is the number of spans an OTLPExporter sends at most in
+	// one request.
+	batchSize = 512
+	// queueSize is the number of spans an OTLPExporter buffers; spans
+	// that do not fit are dropped.
+	queueSize = 4 * batchSize
+)
+
+// OTLPExporter sends spans in batches to an OpenTelemetry collector, as
+// OTLP/HTTP with a JSON body. Exporting never blocks the caller: when the
+// collector is too slow, spans are dropped and counted.
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+	interval time.Duration
+
+	spans   chan *Span
+	flushes chan chan struct{}
+	done    chan struct{}
+
+	mu      sync.Mutex
+	dropped int
+	// OnError is called with export failures if it is set. Set it
+	// before the first span is exported.
+	OnError func(err error)
+}
+
+// NewOTLPExporter returns an exporter that posts the spans of service to
+// endpoint, for example http://localhost:4318/v1/traces, at least every
+// interval. Close it to send the spans that are still queued.
+func NewOTLPExporter(endpoint, service string, interval time.Duration) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		interval: interval,
+		spans:    make(chan *Span, queueSize),
+		flushes:  make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export implements Exporter.
+func (e *OTLPExporter) Export(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+		e.mu.Lock()
+		e.dropped++
+		e.mu.Unlock()
+	}
+}
+
+// Dropped returns the number of spans that were dropped because the queue
+// was full.
+func (e *OTLPExporter) Dropped() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.dropped
+}
+
+// Flush sends the queued spans and waits until they are sent.
+func (e *OTLPExporter) Flush() {
+	done := make(chan struct{})
+	select {
+	case e.flushes <- done:
+		<-done
+	case <-e.done:
+	}
+}
+
+// Close sends the queued spans and stops the exporter. Spans exported
+// afterwards are dropped.
+func (e *OTLPExporter) Close() {
+	e.Flush()
+	select {
+	case <-e.done:
+	default:
+		close(e.done)
+	}
+}
+
+func (e *OTLPExporter) run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	batch := []*Span{}
+	send := func() {
+		if len(batch) > 0 {
+			err := e.send(batch)
+			if err != nil && e.OnError != nil {
+				e.OnError(err)
+			}
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) == batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-e.flushes:
+			for n := len(e.spans); n > 0; n-- {
+				batch = append(batch, <-e.spans)
+				if len(batch) == batchSize {
+					send()
+				}
+			}
+			send()
+			close(done)
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// send posts one batch of spans.
+func (e *OTLPExporter) send(batch []*Span) error {
+	body, err := json.Marshal(encodeOTLP(e.service, batch))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("export %d spans: %v", len(batch), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("export %d spans: %s", len(batch), resp.Status)
+	}
+	return nil
+}
+
+// The types below are the parts of the OTLP/JSON trace format that are
+// used here.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr  `json:"attributes,omitempty"`
+	Status            *otlpStatus `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpStatusError  = 2
+)
+
+// encodeOTLP converts spans of service to an OTLP export request. Root
+// spans are server spans, the others internal.
+func encodeOTLP(service string, spans []*Span) otlpRequest {
+	scope := otlpScopeSpans{Spans: make([]otlpSpan, 0, len(spans))}
+	scope.Scope.Name = "test/trace"
+	for _, s := range spans {
+		name, end, attrs, errMsg := s.snapshot()
+		span := otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		}
+		if s.root {
+			span.Kind = otlpKindServer
+		}
+		for _, a := range attrs {
+			span.Attributes = append(span.Attributes, encodeAttr(a))
+		}
+		if errMsg != "" {
+			span.Status = &otlpStatus{Code: otlpStatusError, Message: errMsg}
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	rs.Resource.Attributes = []otlpAttr{encodeAttr(Attr{"service.name", service})}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{rs}}
+}
+
+func encodeAttr(a Attr) otlpAttr {
+	var v map[string]interface{}
+	switch x := a.Value.(type) {
+	case string:
+		v = map[string]interface{}{"stringValue": x}
+	case bool:
+		v = map[string]interface{}{"boolValue": x}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.Itoa(x)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(x, 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": x}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(x)}
+	}
+	return otlpAttr{Key: a.Key, Value: v}
+}
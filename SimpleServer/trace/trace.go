@@ -0,0 +1,234 @@
+// Package trace records spans of work and hands them to an Exporter, see
+// OTLPExporter. A span started with Start becomes a child of the span in
+// the context, so that code deep down, like the quote store, only needs
+// the context to show up in the trace of a request.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporter receives every span that ends.
+type Exporter interface {
+	Export(s *Span)
+}
+
+// Tracer starts root spans, whose children are exported to the same
+// exporter. A nil *Tracer starts spans that are not exported.
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a Tracer that exports its spans to e.
+func NewTracer(e Exporter) *Tracer {
+	return &Tracer{exporter: e}
+}
+
+// Attr is an attribute of a span. Value is a string, bool, int, int64 or
+// float64.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is a timed piece of work. The methods of a nil *Span do nothing,
+// so that callers need not check whether they are traced.
+type Span struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Start    time.Time
+
+	mu       sync.Mutex
+	name     string
+	end      time.Time
+	attrs    []Attr
+	err      string
+	exporter Exporter
+	// root is set for spans started by a Tracer.
+	root bool
+}
+
+type spanKey struct{}
+
+type requestIDKey struct{}
+
+// Start starts a root span named name that continues the trace of parent,
+// a W3C traceparent header value; parent may be empty.
+func (t *Tracer) Start(ctx context.Context, name, parent string) (context.Context, *Span) {
+	s := &Span{name: name, Start: time.Now(), root: true}
+	if t != nil {
+		s.exporter = t.exporter
+	}
+	s.TraceID, s.ParentID = parseTraceparent(parent)
+	if s.TraceID == "" {
+		s.TraceID = randomID(16)
+	}
+	s.SpanID = randomID(8)
+	if id := RequestID(ctx); id != "" {
+		s.SetAttr("request.id", id)
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Start starts a child of the span in ctx. Without a span in ctx it
+// returns ctx and a nil *Span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := &Span{
+		TraceID:  parent.TraceID,
+		SpanID:   randomID(8),
+		ParentID: parent.SpanID,
+		Start:    time.Now(),
+		name:     name,
+		exporter: parent.exporter,
+	}
+	if id := RequestID(ctx); id != "" {
+		s.SetAttr("request.id", id)
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// FromContext returns the span in ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// WithRequestID returns a copy of ctx that carries the request ID id. The
+// spans started from it get it as their request.id attribute.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID in ctx, or "".
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Name returns the name of s.
+func (s *Span) Name() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.name
+}
+
+// SetName renames s, for example once a request is routed.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttr sets the attribute key of s to value.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.attrs {
+		if s.attrs[i].Key == key {
+			s.attrs[i].Value = value
+			return
+		}
+	}
+	s.attrs = append(s.attrs, Attr{key, value})
+}
+
+// SetError marks s as failed with err. A nil err does nothing.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// End ends s and exports it. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.exporter != nil {
+		s.exporter.Export(s)
+	}
+}
+
+// Traceparent returns the W3C traceparent header value of s.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-01"
+}
+
+// snapshot returns the fields of s that may change, for exporting.
+func (s *Span) snapshot() (name string, end time.Time, attrs []Attr, err string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.name, s.end, append([]Attr(nil), s.attrs...), s.err
+}
+
+// parseTraceparent returns the trace and span ID of a W3C traceparent
+// header value, or empty strings if it is not valid.
+func parseTraceparent(v string) (traceID, spanID string) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[3]) != 2 {
+		return "", ""
+	}
+	if !isHexID(parts[1], 16) || !isHexID(parts[2], 8) {
+		return "", ""
+	}
+	return parts[1], parts[2]
+}
+
+// isHexID reports whether s is the lower-case hex encoding of n bytes that
+// are not all zero.
+func isHexID(s string, n int) bool {
+	if len(s) != 2*n || s == strings.Repeat("0", 2*n) {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomID returns n random bytes in hex.
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
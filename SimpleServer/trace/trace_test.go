@@ -0,0 +1,95 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStart(t *testing.T) {
+	ctx, s := Start(context.Background(), "orphan")
+	if s != nil || FromContext(ctx) != nil {
+		t.Errorf("Start() without a parent = %v, want nil", s)
+	}
+	// The methods of a nil span do nothing.
+	s.SetAttr("k", 1)
+	s.SetError(errors.New("boom"))
+	s.End()
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx = WithRequestID(context.Background(), "req-1")
+	ctx, root := (*Tracer)(nil).Start(ctx, "GET /quotes", parent)
+	if root.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || root.ParentID != "00f067aa0ba902b7" || len(root.SpanID) != 16 {
+		t.Errorf("Tracer.Start() continued %s as %s/%s/%s", parent, root.TraceID, root.ParentID, root.SpanID)
+	}
+	_, child := Start(ctx, "quotes.Get")
+	if child.TraceID != root.TraceID || child.ParentID != root.SpanID {
+		t.Errorf("Start() = %s/%s, want a child of %s/%s", child.TraceID, child.ParentID, root.TraceID, root.SpanID)
+	}
+	if _, _, attrs, _ := child.snapshot(); len(attrs) != 1 || attrs[0] != (Attr{"request.id", "req-1"}) {
+		t.Errorf("Start() attributes = %v, want the request ID", attrs)
+	}
+
+	for _, bad := range []string{"", "garbage", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"} {
+		_, s := (*Tracer)(nil).Start(context.Background(), "x", bad)
+		if s.ParentID != "" || len(s.TraceID) != 32 {
+			t.Errorf("Tracer.Start() with traceparent %q = %s/%s, want a new trace", bad, s.TraceID, s.ParentID)
+		}
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	// A stand-in for the collector.
+	var mu sync.Mutex
+	var received []otlpSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("collector got %s %s: %v", r.Method, r.URL, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			if rs.Resource.Attributes[0].Value["stringValue"] != "quotes" {
+				t.Errorf("resource attributes = %v", rs.Resource.Attributes)
+			}
+			for _, ss := range rs.ScopeSpans {
+				received = append(received, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	e := NewOTLPExporter(collector.URL+"/v1/traces", "quotes", time.Hour)
+	e.OnError = func(err error) { t.Errorf("export error = %v", err) }
+	ctx, root := NewTracer(e).Start(context.Background(), "GET /quotes", "")
+	root.SetAttr("http.status_code", 200)
+	_, child := Start(ctx, "quotes.ListPage")
+	child.SetError(errors.New("boom"))
+	child.End()
+	root.End()
+	root.End()
+	e.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 {
+		t.Fatalf("collector received %d spans, want 2", len(received))
+	}
+	c, r := received[0], received[1]
+	if c.Name != "quotes.ListPage" || c.Kind != otlpKindInternal || c.ParentSpanID != r.SpanID || c.Status == nil || c.Status.Message != "boom" {
+		t.Errorf("child span = %+v", c)
+	}
+	if r.Name != "GET /quotes" || r.Kind != otlpKindServer || r.ParentSpanID != "" || r.Status != nil ||
+		len(r.Attributes) != 1 || r.Attributes[0].Value["intValue"] != "200" || r.StartTimeUnixNano > r.EndTimeUnixNano {
+		t.Errorf("root span = %+v", r)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"test/quotes"
+)
+
+// purgeInterval is how often expired trash is purged.
+const purgeInterval = time.Hour
+
+// POST undelete handler. Moves a deleted quote back out of the trash and
+// answers with the restored quote.
+func (app *App) handleUndelete(w http.ResponseWriter, r *http.Request) {
+	trasher, ok := app.store(r).(quotes.Trasher)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "not_implemented", "undelete is not supported by this store")
+		return
+	}
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	q, err := trasher.Undelete(id)
+	if err == quotes.ErrNotFound {
+		writeError(w, http.StatusNotFound, "not_found", "quote is not in the trash")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(r, q.Rev))
+	writeJSON(w, http.StatusOK, q)
+}
+
+// GET trash handler, lists the deleted quotes that can still be restored
+func (app *App) handleTrash(w http.ResponseWriter, r *http.Request) {
+	trasher, ok := app.store(r).(quotes.Trasher)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "not_implemented", "trash is not supported by this store")
+		return
+	}
+	trash, err := trasher.Trash()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, trash)
+}
+
+// purgeTrash drops quotes that have been in the trash of db, or of any of
+// its tenants, for longer than retention, once at start and then every
+// purgeInterval until ctx is done.
+func purgeTrash(ctx context.Context, db quotes.QuoteStore, retention time.Duration) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		stores := map[string]quotes.QuoteStore{"": db}
+		if tenanter, ok := db.(quotes.Tenanter); ok {
+			tenants, err := tenanter.Tenants()
+			if err != nil {
+				logs.error(ctx, "cannot list tenants", err)
+			}
+			for _, t := range tenants {
+				stores[t.Name] = tenanter.ForTenant(t.Name)
+			}
+		}
+		for tenant, s := range stores {
+			n, err := s.(quotes.Trasher).PurgeTrash(time.Now().Add(-retention))
+			if err != nil {
+				logs.error(ctx, "cannot purge trash", err, "tenant", tenant)
+			} else if n > 0 {
+				logs.info(ctx, "purged trash", "quotes", n, "tenant", tenant)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
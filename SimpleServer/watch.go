@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"test/quotes"
+)
+
+// watchHeartbeat is the interval of keep-alive comments on idle streams.
+var watchHeartbeat = 30 * time.Second
+
+// GET change feed handler. Streams committed quote changes as Server-Sent
+// Events. A client that reconnects with a Last-Event-ID header (or
+// ?last_event_id=) first gets the changes it missed from the change log,
+// or a 410 if they were trimmed from it.
+func (app *App) handleWatch(w http.ResponseWriter, r *http.Request) {
+	watcher, ok := app.store(r).(quotes.Watcher)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "not_implemented", "watch is not supported by this store")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "internal_error", "streaming is not supported")
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var after uint64
+	resume := lastID != ""
+	if resume {
+		var err error
+		after, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid Last-Event-ID "+lastID)
+			return
+		}
+	}
+
+	// Subscribe before replaying, so that nothing falls in between.
+	events, cancel := watcher.Subscribe()
+	defer cancel()
+
+	var missed []*quotes.Event
+	if resume {
+		var err error
+		missed, err = watcher.Changes(after, 500)
+		if err == quotes.ErrTrimmed {
+			writeError(w, http.StatusGone, "gone", "the changes after "+lastID+" are no longer logged; get the quotes again and watch without Last-Event-ID")
+			return
+		}
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+	}
+
+	noWriteTimeout(r)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	for len(missed) > 0 {
+		for _, e := range missed {
+			if writeEvent(w, e) != nil {
+				return
+			}
+			after = e.ID
+		}
+		flusher.Flush()
+		var err error
+		missed, err = watcher.Changes(after, 500)
+		if err != nil {
+			// The client reconnects for the rest, and gets a 410
+			// if it was trimmed meanwhile.
+			logs.error(r.Context(), "cannot replay changes", err)
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				// Dropped for being too slow; the client
+				// reconnects and catches up from the log.
+				return
+			}
+			if e.ID <= after {
+				continue
+			}
+			if writeEvent(w, e) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes e in Server-Sent Events format.
+func writeEvent(w http.ResponseWriter, e *quotes.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}